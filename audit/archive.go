@@ -0,0 +1,225 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+)
+
+// ArchiveBatchSize is the maximum number of records written to a single archive file.
+const ArchiveBatchSize = 10000
+
+// Manifest describes a single archive file and is written next to it.
+type Manifest struct {
+	InternalKey      string
+	ArchiveFile      string
+	RecordCount      int
+	OldestRecord     time.Time
+	NewestRecord     time.Time
+	CreatedTimestamp time.Time
+	SHA256           string
+}
+
+// Archiver moves audit records older than their retention policy out of the database.
+type Archiver struct {
+	Dao       dao.DaoHandler
+	Directory string
+}
+
+// NewArchiver returns an archiver writing into directory.
+func NewArchiver(daoHandler dao.DaoHandler, directory string) *Archiver {
+	return &Archiver{Dao: daoHandler, Directory: directory}
+}
+
+// Run archives every record older than the retention configured for its internal key.
+func (a *Archiver) Run(now time.Time, policies map[string]time.Duration) ([]*Manifest, error) {
+	var ret []*Manifest
+	for internalKey, retention := range policies {
+		before := now.Add(-retention)
+		for {
+			records := a.Dao.LoadAuditRecordsBefore(internalKey, before, ArchiveBatchSize)
+			if len(records) == 0 {
+				break
+			}
+
+			manifest, err := WriteArchive(a.Directory, internalKey, records, now)
+			if err != nil {
+				return ret, err
+			}
+
+			// Only remove the records once they are safely on disk.
+			ids := make([]int64, len(records))
+			for i := range records {
+				ids[i] = records[i].ID
+			}
+			a.Dao.DeleteAuditRecords(ids)
+
+			ret = append(ret, manifest)
+			if len(records) < ArchiveBatchSize {
+				break
+			}
+		}
+	}
+	return ret, nil
+}
+
+func manifestPath(archivePath string) string {
+	return strings.TrimSuffix(archivePath, ".jsonl.gz") + ".manifest.json"
+}
+
+// WriteArchive writes records as gzipped json lines along with a manifest containing its checksum. Nothing is left
+// behind when it fails.
+func WriteArchive(directory, internalKey string, records []*dao.AuditRecord, now time.Time) (_ *Manifest, err error) {
+	if len(records) == 0 {
+		return nil, errors.New("no records to archive")
+	}
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("error creating archive directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s-%d.jsonl.gz", internalKey, now.UTC().Format("20060102T150405Z"), records[0].ID)
+	archivePath := filepath.Join(directory, name)
+
+	f, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error creating archive %s: %w", archivePath, err)
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			// A partial archive would pass for a complete one.
+			os.Remove(archivePath)
+		}
+	}()
+
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(f, hash))
+	enc := json.NewEncoder(gz)
+
+	manifest := &Manifest{
+		InternalKey:      internalKey,
+		ArchiveFile:      name,
+		RecordCount:      len(records),
+		OldestRecord:     records[0].CreatedTimestamp,
+		NewestRecord:     records[0].CreatedTimestamp,
+		CreatedTimestamp: now,
+	}
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return nil, fmt.Errorf("error writing audit record %d: %w", r.ID, err)
+		}
+		if r.CreatedTimestamp.Before(manifest.OldestRecord) {
+			manifest.OldestRecord = r.CreatedTimestamp
+		}
+		if r.CreatedTimestamp.After(manifest.NewestRecord) {
+			manifest.NewestRecord = r.CreatedTimestamp
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error closing archive %s: %w", archivePath, err)
+	}
+	if err := f.Sync(); err != nil {
+		return nil, fmt.Errorf("error syncing archive %s: %w", archivePath, err)
+	}
+	manifest.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileSync(manifestPath(archivePath), b); err != nil {
+		return nil, fmt.Errorf("error writing manifest for %s: %w", archivePath, err)
+	}
+
+	return manifest, nil
+}
+
+// writeFileSync writes a new file at path, removing it again if that fails.
+func writeFileSync(path string, b []byte) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// ReadArchive loads the manifest at manifestFile, verifies the checksum of its archive and returns the records.
+func ReadArchive(manifestFile string) (*Manifest, []*dao.AuditRecord, error) {
+	b, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return nil, nil, fmt.Errorf("error decoding manifest: %w", err)
+	}
+
+	archivePath := filepath.Join(filepath.Dir(manifestFile), manifest.ArchiveFile)
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening archive: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, nil, fmt.Errorf("error reading archive: %w", err)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != manifest.SHA256 {
+		return nil, nil, fmt.Errorf("checksum mismatch for %s", archivePath)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decompressing archive: %w", err)
+	}
+	defer gz.Close()
+
+	records := make([]*dao.AuditRecord, 0, manifest.RecordCount)
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		r := &dao.AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return nil, nil, fmt.Errorf("error decoding audit record: %w", err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading archive: %w", err)
+	}
+
+	if len(records) != manifest.RecordCount {
+		return nil, nil, fmt.Errorf("archive contains %d records manifest expects %d", len(records), manifest.RecordCount)
+	}
+
+	return manifest, records, nil
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	records := []*dao.AuditRecord{
+		{ID: 1, CreatedTimestamp: now.Add(-48 * time.Hour), CurrentState: 1, InternalKey: "webapp", Method: "GET", Metadata: dao.AuditMetadata{"a": "b"}, HumanReadable: "read"},
+		{ID: 2, CreatedTimestamp: now.Add(-72 * time.Hour), CurrentState: 0, InternalKey: "webapp", Method: "POST"},
+	}
+
+	manifest, err := WriteArchive(dir, "webapp", records, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.OldestRecord.Equal(records[1].CreatedTimestamp) || !manifest.NewestRecord.Equal(records[0].CreatedTimestamp) {
+		t.Fatalf("unexpected record range %v - %v", manifest.OldestRecord, manifest.NewestRecord)
+	}
+
+	manifestFile := filepath.Join(dir, strings.TrimSuffix(manifest.ArchiveFile, ".jsonl.gz")+".manifest.json")
+	_, restored, err := ReadArchive(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 2 || restored[0].HumanReadable != "read" || restored[0].Metadata["a"] != "b" || restored[1].Method != "POST" {
+		t.Fatalf("restored records do not match: %+v", restored)
+	}
+
+	// flip a byte in the archive and make sure we notice
+	archiveFile := filepath.Join(dir, manifest.ArchiveFile)
+	b, err := ioutil.ReadFile(archiveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err := ioutil.WriteFile(archiveFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadArchive(manifestFile); err == nil {
+		t.Fatal("expected checksum mismatch")
+	}
+}
+
+func TestArchiveFailureLeavesNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	records := []*dao.AuditRecord{
+		{ID: 1, InternalKey: "webapp", Method: "GET"},
+		{ID: 2, InternalKey: "webapp", Metadata: dao.AuditMetadata{"unencodable": make(chan int)}},
+	}
+	if _, err := WriteArchive(dir, "webapp", records, time.Now()); err == nil {
+		t.Fatal("expected an error encoding the record")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("partial archive left behind: %v", files[0].Name())
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/permissiontoken"
+	"github.com/genesis32/complianceweb/utils"
+)
+
+// LocalIssuer is the issuer of the credentials the service hands out itself, api keys and the access tokens
+// from its token endpoint. Service principals are registered with it.
+const LocalIssuer = permissiontoken.Issuer
+
+// APIKeyPrefix starts every api key so they can be told apart from jwts.
+const APIKeyPrefix = "cwk_"
+
+// ScopeClaim contains the space separated permissions a credential is restricted to.
+const ScopeClaim = "scope"
+
+// GenerateAPIKey returns a new key for id and the hash of its secret, only the hash should ever be stored.
+func GenerateAPIKey(id int64) (string, string, error) {
+	secret, err := NewRandomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%s%d_%s", APIKeyPrefix, id, secret), HashAPIKeySecret(secret), nil
+}
+
+// HashAPIKeySecret hashes the secret part of a key, secrets are random so a fast hash is enough.
+func HashAPIKeySecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// ParseAPIKey splits a key into its id and secret.
+func ParseAPIKey(key string) (int64, string, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return 0, "", errors.New("not an api key")
+	}
+	pieces := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), "_", 2)
+	if len(pieces) != 2 || pieces[1] == "" {
+		return 0, "", errors.New("malformed api key")
+	}
+	id, err := utils.StringToInt64(pieces[0])
+	if err != nil {
+		return 0, "", errors.New("malformed api key")
+	}
+	return id, pieces[1], nil
+}
+
+// IsAPIKeyHeader returns true if the authorization header carries an api key rather than a jwt.
+func IsAPIKeyHeader(headerValue string) bool {
+	token, err := bearerToken(headerValue)
+	return err == nil && strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// APIKeyAuthenticator validates api keys issued to service principals.
+type APIKeyAuthenticator struct {
+	Dao dao.DaoHandler
+	Now func() time.Time
+}
+
+// NewAPIKeyAuthenticator returns an authenticator checking keys against the database.
+func NewAPIKeyAuthenticator(daoHandler dao.DaoHandler) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{Dao: daoHandler, Now: time.Now}
+}
+
+// ValidateAPIKey returns the active key matching key and records that it was used.
+func (a *APIKeyAuthenticator) ValidateAPIKey(key string) (*dao.APIKey, error) {
+	id, secret, err := ParseAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := a.Dao.LoadAPIKey(id)
+	if apiKey == nil || subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(HashAPIKeySecret(secret))) != 1 {
+		return nil, errors.New("invalid api key")
+	}
+	if apiKey.CurrentState != dao.APIKeyActiveState {
+		return nil, errors.New("api key revoked")
+	}
+	now := a.Now().UTC()
+	if !now.Before(apiKey.ExpirationTimestamp) {
+		return nil, errors.New("api key expired")
+	}
+
+	a.Dao.TouchAPIKey(apiKey.ID, now)
+	return apiKey, nil
+}
+
+// ValidateAuthorizationHeader validates the api key and returns claims identifying the service principal.
+func (a *APIKeyAuthenticator) ValidateAuthorizationHeader(headerValue string) (utils.OpenIDClaims, error) {
+	rawToken, err := bearerToken(headerValue)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey, err := a.ValidateAPIKey(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.OpenIDClaims{
+		"iss":        LocalIssuer,
+		"sub":        fmt.Sprintf("%d", apiKey.OrganizationUserID),
+		"api_key_id": fmt.Sprintf("%d", apiKey.ID),
+		ScopeClaim:   strings.Join(apiKey.Scopes, " "),
+	}, nil
+}
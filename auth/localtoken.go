@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/permissiontoken"
+	"github.com/genesis32/complianceweb/utils"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// LocalTokenAlgorithm is the only algorithm local access tokens are signed with.
+const LocalTokenAlgorithm = permissiontoken.Algorithm
+
+const signingKeyBits = 2048
+
+// LocalTokenIssuer signs access tokens for service principals and validates them when they are presented back.
+// Keys live in the database so every instance of the server signs and verifies with the same set.
+type LocalTokenIssuer struct {
+	Dao      dao.DaoHandler
+	Lifetime time.Duration
+	Now      func() time.Time
+
+	mu         sync.Mutex
+	parsedKeys map[string]*rsa.PrivateKey
+}
+
+// NewLocalTokenIssuer returns an issuer whose tokens are valid for lifetime.
+func NewLocalTokenIssuer(daoHandler dao.DaoHandler, lifetime time.Duration) *LocalTokenIssuer {
+	return &LocalTokenIssuer{Dao: daoHandler, Lifetime: lifetime, Now: time.Now, parsedKeys: make(map[string]*rsa.PrivateKey)}
+}
+
+// IsLocalTokenHeader returns true if the authorization header carries a jwt this service issued.
+func IsLocalTokenHeader(headerValue string) bool {
+	rawToken, err := bearerToken(headerValue)
+	if err != nil {
+		return false
+	}
+	issuer, err := unverifiedIssuer(rawToken)
+	return err == nil && issuer == LocalIssuer
+}
+
+// RotateSigningKey generates a new signing key, the previous key is retired but still published.
+func (i *LocalTokenIssuer) RotateSigningKey() (*dao.SigningKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, signingKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("generating signing key: %w", err)
+	}
+	kid, err := NewRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey := &dao.SigningKey{
+		ID:               kid,
+		PrivateKey:       string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		CurrentState:     dao.SigningKeyActiveState,
+		CreatedTimestamp: i.Now().UTC(),
+	}
+	i.Dao.CreateSigningKey(signingKey)
+	return signingKey, nil
+}
+
+// EnsureSigningKey rotates the signing key if there is none or the active key is older than maxAge, and
+// drops retired keys once every token they signed has expired.
+func (i *LocalTokenIssuer) EnsureSigningKey(maxAge time.Duration) error {
+	now := i.Now().UTC()
+	i.Dao.PurgeSigningKeys(now.Add(-i.Lifetime))
+
+	active := activeSigningKey(i.Dao.LoadSigningKeys())
+	if active != nil && now.Sub(active.CreatedTimestamp) < maxAge {
+		return nil
+	}
+	_, err := i.RotateSigningKey()
+	return err
+}
+
+func activeSigningKey(keys []*dao.SigningKey) *dao.SigningKey {
+	for _, k := range keys {
+		if k.CurrentState == dao.SigningKeyActiveState {
+			return k
+		}
+	}
+	return nil
+}
+
+func (i *LocalTokenIssuer) parseKey(signingKey *dao.SigningKey) (*rsa.PrivateKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if key, ok := i.parsedKeys[signingKey.ID]; ok {
+		return key, nil
+	}
+	block, _ := pem.Decode([]byte(signingKey.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not pem encoded", signingKey.ID)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key %s: %w", signingKey.ID, err)
+	}
+	i.parsedKeys[signingKey.ID] = key
+	return key, nil
+}
+
+// IssueToken signs an access token for subject restricted to scopes, the server grants nothing to one without scopes.
+// It returns the token and when it expires.
+func (i *LocalTokenIssuer) IssueToken(subject string, scopes []string, extraClaims map[string]interface{}) (string, time.Time, error) {
+	claims := jwt.MapClaims{}
+	for k, v := range extraClaims {
+		claims[k] = v
+	}
+	claims[ScopeClaim] = strings.Join(scopes, " ")
+	return i.sign(subject, LocalIssuer, claims)
+}
+
+// IssuePermissionToken signs a token listing the permissions subject has in each organization of the subtree
+// rooted at organizationID. Its audience keeps it from being accepted as an access token.
+func (i *LocalTokenIssuer) IssuePermissionToken(subject string, organizationID int64, permissions map[int64][]string) (string, time.Time, error) {
+	permissionsClaim := make(map[string][]string, len(permissions))
+	for k, v := range permissions {
+		permissionsClaim[fmt.Sprintf("%d", k)] = v
+	}
+	return i.sign(subject, permissiontoken.Audience, jwt.MapClaims{
+		permissiontoken.OrganizationClaim: fmt.Sprintf("%d", organizationID),
+		permissiontoken.PermissionsClaim:  permissionsClaim,
+	})
+}
+
+func (i *LocalTokenIssuer) sign(subject, audience string, claims jwt.MapClaims) (string, time.Time, error) {
+	signingKey := activeSigningKey(i.Dao.LoadSigningKeys())
+	if signingKey == nil {
+		return "", time.Time{}, errors.New("no active signing key")
+	}
+	key, err := i.parseKey(signingKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	jti, err := NewRandomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := i.Now().UTC()
+	expires := now.Add(i.Lifetime)
+	claims["iss"] = LocalIssuer
+	claims["aud"] = audience
+	claims["sub"] = subject
+	claims["iat"] = now.Unix()
+	claims["exp"] = expires.Unix()
+	claims["jti"] = jti
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = signingKey.ID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expires, nil
+}
+
+// JSONWebKeySet returns the public half of every key a valid token could have been signed with.
+func (i *LocalTokenIssuer) JSONWebKeySet() (jose.JSONWebKeySet, error) {
+	ret := jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0)}
+	for _, signingKey := range i.Dao.LoadSigningKeys() {
+		key, err := i.parseKey(signingKey)
+		if err != nil {
+			return ret, err
+		}
+		ret.Keys = append(ret.Keys, jose.JSONWebKey{Key: &key.PublicKey, KeyID: signingKey.ID, Algorithm: LocalTokenAlgorithm, Use: "sig"})
+	}
+	return ret, nil
+}
+
+// ValidateAuthorizationHeader verifies a token from IssueToken and returns its claims.
+func (i *LocalTokenIssuer) ValidateAuthorizationHeader(headerValue string) (utils.OpenIDClaims, error) {
+	rawToken, err := bearerToken(headerValue)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &jwt.Parser{ValidMethods: []string{LocalTokenAlgorithm}}
+	token, err := parser.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, signingKey := range i.Dao.LoadSigningKeys() {
+			if signingKey.ID == kid {
+				key, err := i.parseKey(signingKey)
+				if err != nil {
+					return nil, err
+				}
+				return &key.PublicKey, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyIssuer(LocalIssuer, true) || !claims.VerifyAudience(LocalIssuer, true) {
+		return nil, errors.New("token was not issued by this service")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("token does not expire")
+	}
+
+	return utils.OpenIDClaims(claims), nil
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/permissiontoken"
+)
+
+// signingKeyDao keeps signing keys in memory, every other method panics.
+type signingKeyDao struct {
+	dao.DaoHandler
+	keys []*dao.SigningKey
+}
+
+func (d *signingKeyDao) CreateSigningKey(signingKey *dao.SigningKey) {
+	for _, k := range d.keys {
+		if k.CurrentState == dao.SigningKeyActiveState {
+			k.CurrentState = dao.SigningKeyRetiredState
+			k.RetiredTimestamp = signingKey.CreatedTimestamp
+		}
+	}
+	d.keys = append([]*dao.SigningKey{signingKey}, d.keys...)
+}
+
+func (d *signingKeyDao) LoadSigningKeys() []*dao.SigningKey {
+	return d.keys
+}
+
+func (d *signingKeyDao) PurgeSigningKeys(retiredBefore time.Time) {
+	var kept []*dao.SigningKey
+	for _, k := range d.keys {
+		if k.CurrentState == dao.SigningKeyActiveState || !k.RetiredTimestamp.Before(retiredBefore) {
+			kept = append(kept, k)
+		}
+	}
+	d.keys = kept
+}
+
+func TestLocalTokenRotation(t *testing.T) {
+	now := time.Now()
+	issuer := NewLocalTokenIssuer(&signingKeyDao{}, 15*time.Minute)
+	issuer.Now = func() time.Time { return now }
+
+	if err := issuer.EnsureSigningKey(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := issuer.IssueToken("1234", []string{"user.read.execute"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := "Bearer " + token
+	if !IsLocalTokenHeader(header) {
+		t.Fatal("token should be recognized as local")
+	}
+	claims, err := issuer.ValidateAuthorizationHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "1234" || claims[ScopeClaim] != "user.read.execute" {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+
+	// A rotated key is still published until the tokens it signed have expired.
+	now = now.Add(25 * time.Hour)
+	if err := issuer.EnsureSigningKey(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	keySet, err := issuer.JSONWebKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keySet.Keys) != 2 {
+		t.Fatalf("expected the active and retired key, got %d", len(keySet.Keys))
+	}
+	if _, err := issuer.ValidateAuthorizationHeader(header); err != nil {
+		t.Fatalf("token signed by retired key should validate: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := issuer.EnsureSigningKey(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	keySet, _ = issuer.JSONWebKeySet()
+	if len(keySet.Keys) != 1 {
+		t.Fatalf("expected the retired key to be purged, got %d keys", len(keySet.Keys))
+	}
+	if _, err := issuer.ValidateAuthorizationHeader(header); err == nil {
+		t.Fatal("token signed by purged key should not validate")
+	}
+}
+
+func TestLocalTokenRejectsForeignTokens(t *testing.T) {
+	issuer := NewLocalTokenIssuer(&signingKeyDao{}, 15*time.Minute)
+	if err := issuer.EnsureSigningKey(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	kid := issuer.Dao.LoadSigningKeys()[0].ID
+
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": LocalIssuer, "aud": LocalIssuer, "sub": "1234", "exp": time.Now().Add(time.Minute).Unix()})
+	hmacToken.Header["kid"] = kid
+	signed, err := hmacToken.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.ValidateAuthorizationHeader("Bearer " + signed); err == nil {
+		t.Fatal("HS256 token should be rejected")
+	}
+
+	if _, err := issuer.ValidateAuthorizationHeader("Bearer garbage"); err == nil {
+		t.Fatal("garbage should be rejected")
+	}
+}
+
+func TestPermissionTokenVerifiedOffline(t *testing.T) {
+	issuer := NewLocalTokenIssuer(&signingKeyDao{}, 15*time.Minute)
+	if err := issuer.EnsureSigningKey(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != permissiontoken.JWKSPath {
+			http.NotFound(w, r)
+			return
+		}
+		keySet, _ := issuer.JSONWebKeySet()
+		json.NewEncoder(w).Encode(keySet)
+	}))
+	defer jwks.Close()
+
+	token, _, err := issuer.IssuePermissionToken("1234", 10, map[int64][]string{
+		10: {"user.read.execute"},
+		11: {"user.read.execute", "user.update.execute"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := permissiontoken.NewVerifier(jwks.URL).Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Subject != "1234" || verified.OrganizationID != 10 {
+		t.Fatalf("unexpected token %+v", verified)
+	}
+	if !verified.HasPermission(11, "user.update.execute") || verified.HasPermission(10, "user.update.execute") || verified.HasPermission(12, "user.read.execute") {
+		t.Fatalf("unexpected permissions %v", verified.Permissions)
+	}
+
+	if _, err := issuer.ValidateAuthorizationHeader("Bearer " + token); err == nil {
+		t.Fatal("permission token should not be accepted as an access token")
+	}
+
+	accessToken, _, err := issuer.IssueToken("1234", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := permissiontoken.NewVerifier(jwks.URL).Verify(accessToken); err == nil {
+		t.Fatal("access token should not be accepted as a permission token")
+	}
+}
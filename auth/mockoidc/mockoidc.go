@@ -0,0 +1,309 @@
+// Package mockoidc is an in memory OpenID Connect issuer so the login flow can be exercised without a real identity provider.
+package mockoidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// The paths the provider serves relative to its issuer.
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/jwks"
+	AuthorizePath = "/authorize"
+	TokenPath     = "/token"
+	LogoutPath    = "/logout"
+)
+
+const (
+	codeLifetime    = time.Minute
+	idTokenLifetime = time.Hour
+)
+
+type authorization struct {
+	ClientID      string
+	RedirectURI   string
+	Subject       string
+	Nonce         string
+	CodeChallenge string
+	Expiry        time.Time
+}
+
+// Provider is a mock issuer. Every authorize request is approved immediately for Subject, or for the login_hint
+// parameter if one was passed. PKCE is enforced for codes requested with a code_challenge.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Subject      string
+	Claims       map[string]interface{}
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+// New returns a provider with a freshly generated RS256 signing key.
+func New(issuer, clientID, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generating signing key: %w", err)
+	}
+
+	return &Provider{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Subject:      "mockoidc|subject",
+		Claims: map[string]interface{}{
+			"given_name":  "John",
+			"family_name": "Smith",
+			"nickname":    "jsmith",
+			"name":        "John Smith",
+			"locale":      "en",
+		},
+		key:   key,
+		keyID: randomString(8),
+		codes: make(map[string]*authorization),
+	}, nil
+}
+
+// NewTestServer starts a provider on a local port whose issuer is the url of the returned server.
+func NewTestServer(clientID, clientSecret string) (*Provider, *httptest.Server, error) {
+	p, err := New("", clientID, clientSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := httptest.NewServer(p.Handler())
+	p.Issuer = s.URL
+	return p, s, nil
+}
+
+// Handler returns the http handler serving all of the provider endpoints.
+func (p *Provider) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DiscoveryPath, p.discoveryHandler)
+	mux.HandleFunc(JWKSPath, p.jwksHandler)
+	mux.HandleFunc(AuthorizePath, p.authorizeHandler)
+	mux.HandleFunc(TokenPath, p.tokenHandler)
+	mux.HandleFunc(LogoutPath, p.logoutHandler)
+	return mux
+}
+
+// SignIDToken returns an id token for subject signed with the provider key.
+func (p *Provider) SignIDToken(subject, nonce string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for k, v := range p.Claims {
+		claims[k] = v
+	}
+	claims["iss"] = p.Issuer
+	claims["sub"] = subject
+	claims["aud"] = p.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(idTokenLifetime).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.keyID
+	return token.SignedString(p.key)
+}
+
+func (p *Provider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + AuthorizePath,
+		"token_endpoint":                        p.Issuer + TokenPath,
+		"jwks_uri":                              p.Issuer + JWKSPath,
+		"end_session_endpoint":                  p.Issuer + LogoutPath,
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Key: &p.key.PublicKey, KeyID: p.keyID, Algorithm: "RS256", Use: "sig"},
+		},
+	})
+}
+
+func (p *Provider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("client_id") != p.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	redirectQuery := redirectURI.Query()
+	redirectQuery.Set("state", q.Get("state"))
+
+	if q.Get("response_type") != "code" {
+		redirectQuery.Set("error", "unsupported_response_type")
+		redirectURI.RawQuery = redirectQuery.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+		return
+	}
+
+	if q.Get("code_challenge") != "" && q.Get("code_challenge_method") != "S256" {
+		redirectQuery.Set("error", "invalid_request")
+		redirectURI.RawQuery = redirectQuery.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+		return
+	}
+
+	subject := q.Get("login_hint")
+	if subject == "" {
+		subject = p.Subject
+	}
+
+	code := randomString(32)
+	p.mu.Lock()
+	p.codes[code] = &authorization{
+		ClientID:      p.ClientID,
+		RedirectURI:   q.Get("redirect_uri"),
+		Subject:       subject,
+		Nonce:         q.Get("nonce"),
+		CodeChallenge: q.Get("code_challenge"),
+		Expiry:        time.Now().Add(codeLifetime),
+	}
+	p.mu.Unlock()
+
+	redirectQuery.Set("code", code)
+	redirectURI.RawQuery = redirectQuery.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tokenError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || clientSecret != p.ClientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	authz, err := p.redeemCode(r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	if err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	idToken, err := p.SignIDToken(authz.Subject, authz.Nonce)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(32),
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenLifetime.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+// logoutHandler ends the session at the provider, which is a no op since the provider keeps no sessions, and
+// returns the browser to the relying party.
+func (p *Provider) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("post_logout_redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// redeemCode exchanges a code exactly once, checking the PKCE verifier if the code was issued with a challenge.
+func (p *Provider) redeemCode(code, redirectURI, codeVerifier string) (*authorization, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	authz, ok := p.codes[code]
+	if !ok {
+		return nil, errors.New("unknown code")
+	}
+	delete(p.codes, code)
+
+	if time.Now().After(authz.Expiry) {
+		return nil, errors.New("code expired")
+	}
+	if authz.RedirectURI != redirectURI {
+		return nil, errors.New("redirect_uri mismatch")
+	}
+	if authz.CodeChallenge != "" {
+		h := sha256.Sum256([]byte(codeVerifier))
+		if base64.RawURLEncoding.EncodeToString(h[:]) != authz.CodeChallenge {
+			return nil, errors.New("code_verifier mismatch")
+		}
+	}
+	return authz, nil
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
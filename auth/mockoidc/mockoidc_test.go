@@ -0,0 +1,88 @@
+package mockoidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/genesis32/complianceweb/auth"
+)
+
+func TestLoginFlow(t *testing.T) {
+	p, s, err := NewTestServer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	callbackURL := "http://localhost/webapp/callback"
+	authenticator := auth.NewOIDCAuthenticator(callbackURL, auth.OIDCProviderConfiguration{
+		Name:         "mock",
+		Type:         auth.GenericProviderType,
+		IssuerURL:    s.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}).(*auth.OIDCAuthenticator)
+	provider := authenticator.ProviderByName("mock")
+
+	cl := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	verifier, _ := auth.NewRandomToken()
+	nonce, _ := auth.NewRandomToken()
+	resp, err := cl.Get(provider.Config.AuthCodeURL("thestate", auth.AuthCodeOptions(verifier, nonce)...) + "&login_hint=mock|1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize - statuscode expected: %d got: %d", http.StatusFound, resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("state") != "thestate" {
+		t.Fatalf("state not returned: %s", location)
+	}
+
+	// a code can't be redeemed without the verifier it was requested with
+	if _, err := provider.Config.Exchange(context.Background(), location.Query().Get("code"), auth.ExchangeOptions("wrong")...); err == nil {
+		t.Fatal("expected exchange with the wrong verifier to fail")
+	}
+
+	resp, err = cl.Get(provider.Config.AuthCodeURL("thestate", auth.AuthCodeOptions(verifier, nonce)...) + "&login_hint=mock|1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, err = url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := provider.Config.Exchange(context.Background(), location.Query().Get("code"), auth.ExchangeOptions(verifier)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawIDToken := token.Extra("id_token").(string)
+
+	if _, err := provider.VerifyIDTokenNonce(context.Background(), rawIDToken, nonce); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDTokenNonce(context.Background(), rawIDToken, "othernonce"); err == nil {
+		t.Fatal("expected nonce mismatch")
+	}
+
+	claims, err := authenticator.ValidateAuthorizationHeader("Bearer " + rawIDToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "mock|1234" || claims["iss"] != p.Issuer {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+
+	// codes can only be used once
+	if _, err := provider.Config.Exchange(context.Background(), location.Query().Get("code"), auth.ExchangeOptions(verifier)...); err == nil {
+		t.Fatal("expected code reuse to fail")
+	}
+}
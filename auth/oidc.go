@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/genesis32/complianceweb/utils"
+
+	oidc "github.com/coreos/go-oidc"
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+)
+
+// The identity provider types that can be configured.
+const (
+	Auth0ProviderType    = "AUTH0"
+	OktaProviderType     = "OKTA"
+	KeycloakProviderType = "KEYCLOAK"
+	AzureADProviderType  = "AZUREAD"
+	GoogleProviderType   = "GOOGLE"
+	GenericProviderType  = "OIDC"
+)
+
+var defaultProviderScopes = map[string][]string{
+	Auth0ProviderType:    {oidc.ScopeOpenID, "profile"},
+	OktaProviderType:     {oidc.ScopeOpenID, "profile", "email"},
+	KeycloakProviderType: {oidc.ScopeOpenID, "profile", "email"},
+	AzureADProviderType:  {oidc.ScopeOpenID, "profile", "email"},
+	GoogleProviderType:   {oidc.ScopeOpenID, "profile", "email"},
+	GenericProviderType:  {oidc.ScopeOpenID, "profile"},
+}
+
+// DefaultSigningAlgorithms are the algorithms accepted from a provider that doesn't configure any.
+var DefaultSigningAlgorithms = []string{oidc.RS256}
+
+// allowedSigningAlgorithms are the only asymmetric algorithms a provider can be configured with. Symmetric
+// algorithms and none are never accepted since anyone with the client secret could mint tokens.
+var allowedSigningAlgorithms = map[string]bool{
+	oidc.RS256: true,
+	oidc.RS384: true,
+	oidc.RS512: true,
+	oidc.ES256: true,
+	oidc.ES384: true,
+	oidc.ES512: true,
+	oidc.PS256: true,
+	oidc.PS384: true,
+	oidc.PS512: true,
+}
+
+// IsValidProviderType returns true if providerType is one we know how to talk to.
+func IsValidProviderType(providerType string) bool {
+	_, ok := defaultProviderScopes[providerType]
+	return ok
+}
+
+// OIDCProviderConfiguration is everything needed to talk to a single issuer.
+type OIDCProviderConfiguration struct {
+	Name              string
+	Type              string
+	IssuerURL         string
+	ClientID          string
+	ClientSecret      string
+	Scopes            []string
+	SigningAlgorithms []string
+}
+
+// OIDCProvider is a single OpenID Connect issuer users can log in with.
+type OIDCProvider struct {
+	Name          string
+	Type          string
+	Issuer        string
+	EndSessionURL string
+	Provider      *oidc.Provider
+	Config        oauth2.Config
+	verifier      *oidc.IDTokenVerifier
+}
+
+// VerifyIDToken verifies a raw id token was issued by this provider and returns its claims.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken string) (utils.OpenIDClaims, error) {
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile map[string]interface{}
+	if err := idToken.Claims(&profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// VerifyIDTokenNonce verifies a raw id token like VerifyIDToken and also makes sure it was issued for nonce.
+func (p *OIDCProvider) VerifyIDTokenNonce(ctx context.Context, rawIDToken, nonce string) (utils.OpenIDClaims, error) {
+	claims, err := p.VerifyIDToken(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce == "" || claims["nonce"] != nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+	return claims, nil
+}
+
+// LogoutURL returns where to send the browser to end its session at the provider, or "" if the provider
+// does not support logging out.
+func (p *OIDCProvider) LogoutURL(idTokenHint, postLogoutRedirectURI string) string {
+	// Auth0 does not advertise its logout endpoint in the discovery document.
+	if p.EndSessionURL == "" && p.Type == Auth0ProviderType {
+		q := url.Values{"client_id": {p.Config.ClientID}, "returnTo": {postLogoutRedirectURI}}
+		return strings.TrimSuffix(p.Issuer, "/") + "/v2/logout?" + q.Encode()
+	}
+	if p.EndSessionURL == "" {
+		return ""
+	}
+
+	endSessionURL, err := url.Parse(p.EndSessionURL)
+	if err != nil {
+		return ""
+	}
+	q := endSessionURL.Query()
+	q.Set("client_id", p.Config.ClientID)
+	q.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	if idTokenHint != "" {
+		q.Set("id_token_hint", idTokenHint)
+	}
+	endSessionURL.RawQuery = q.Encode()
+	return endSessionURL.String()
+}
+
+// OIDCAuthenticator validates jwts issued by any of the configured providers.
+type OIDCAuthenticator struct {
+	Ctx               context.Context
+	providersByIssuer map[string]*OIDCProvider
+	providersByName   map[string]*OIDCProvider
+}
+
+// NewOIDCProvider discovers the issuer and returns a provider that redirects back to callbackURL.
+func NewOIDCProvider(ctx context.Context, callbackURL string, c OIDCProviderConfiguration) (*OIDCProvider, error) {
+	if !IsValidProviderType(c.Type) {
+		return nil, fmt.Errorf("provider %s has unknown type %s", c.Name, c.Type)
+	}
+
+	algorithms := c.SigningAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultSigningAlgorithms
+	}
+	for _, alg := range algorithms {
+		if !allowedSigningAlgorithms[alg] {
+			return nil, fmt.Errorf("provider %s cannot use signing algorithm %s", c.Name, alg)
+		}
+	}
+
+	provider, err := oidc.NewProvider(ctx, c.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider %s: %w", c.Name, err)
+	}
+
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultProviderScopes[c.Type]
+	}
+
+	conf := oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  callbackURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+
+	oidcConfig := &oidc.Config{
+		ClientID:             c.ClientID,
+		SupportedSigningAlgs: algorithms,
+	}
+
+	// The discovered issuer is what will be in the iss claim, the configured url may differ by a trailing slash.
+	var discovery struct {
+		Issuer             string `json:"issuer"`
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&discovery); err != nil {
+		return nil, fmt.Errorf("failed to read discovery document for %s: %w", c.Name, err)
+	}
+
+	return &OIDCProvider{
+		Name:          c.Name,
+		Type:          c.Type,
+		Issuer:        discovery.Issuer,
+		EndSessionURL: discovery.EndSessionEndpoint,
+		Provider:      provider,
+		Config:        conf,
+		verifier:      provider.Verifier(oidcConfig),
+	}, nil
+}
+
+// NewOIDCAuthenticator returns an authenticator that accepts tokens from every provider in configs.
+func NewOIDCAuthenticator(callbackURL string, configs ...OIDCProviderConfiguration) Authenticator {
+	ctx := context.Background()
+
+	ret := &OIDCAuthenticator{
+		Ctx:               ctx,
+		providersByIssuer: make(map[string]*OIDCProvider),
+		providersByName:   make(map[string]*OIDCProvider),
+	}
+	for _, c := range configs {
+		p, err := NewOIDCProvider(ctx, callbackURL, c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ret.AddProvider(p)
+	}
+	return ret
+}
+
+// AddProvider makes the authenticator accept tokens from p.
+func (a *OIDCAuthenticator) AddProvider(p *OIDCProvider) {
+	a.providersByIssuer[p.Issuer] = p
+	a.providersByName[p.Name] = p
+}
+
+// ProviderByName returns the provider with name or nil if it isn't configured.
+func (a *OIDCAuthenticator) ProviderByName(name string) *OIDCProvider {
+	return a.providersByName[name]
+}
+
+// ProviderByIssuer returns the provider for issuer or nil if it isn't configured.
+func (a *OIDCAuthenticator) ProviderByIssuer(issuer string) *OIDCProvider {
+	return a.providersByIssuer[issuer]
+}
+
+// ProviderNames returns the names of all the configured providers in sorted order.
+func (a *OIDCAuthenticator) ProviderNames() []string {
+	ret := make([]string, 0, len(a.providersByName))
+	for k := range a.providersByName {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ValidateAuthorizationHeader validates a jwt against the provider that issued it.
+func (a *OIDCAuthenticator) ValidateAuthorizationHeader(headerValue string) (utils.OpenIDClaims, error) {
+
+	rawToken, err := bearerToken(headerValue)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, err := unverifiedIssuer(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := a.ProviderByIssuer(issuer)
+	if provider == nil {
+		return nil, fmt.Errorf("issuer %s is not configured", issuer)
+	}
+
+	// The nonce binds a token to the browser login that requested it and is checked in the callback,
+	// bearer tokens have no login to compare it with.
+	return provider.VerifyIDToken(a.Ctx, rawToken)
+}
+
+// unverifiedIssuer peeks at the iss claim so we know which provider's keys to verify the token with.
+func unverifiedIssuer(rawToken string) (string, error) {
+	claims := jwt.MapClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(rawToken, claims)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse token: %w", err)
+	}
+	issuer, ok := claims["iss"].(string)
+	if !ok || issuer == "" {
+		return "", errors.New("token has no issuer")
+	}
+	return issuer, nil
+}
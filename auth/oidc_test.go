@@ -0,0 +1,122 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/auth/mockoidc"
+)
+
+func TestOIDCAuthenticatorAlgorithms(t *testing.T) {
+	p, s, err := mockoidc.NewTestServer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	config := auth.OIDCProviderConfiguration{
+		Name:         "mock",
+		Type:         auth.GenericProviderType,
+		IssuerURL:    s.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}
+	authenticator := auth.NewOIDCAuthenticator("http://localhost/webapp/callback", config)
+
+	valid, err := p.SignIDToken("mock|1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticator.ValidateAuthorizationHeader("Bearer " + valid); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{"iss": p.Issuer, "sub": "mock|1", "aud": "client", "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+	sign := func(method jwt.SigningMethod, key interface{}, issuer string) string {
+		c := jwt.MapClaims{}
+		for k, v := range claims {
+			c[k] = v
+		}
+		c["iss"] = issuer
+		s, err := jwt.NewWithClaims(method, c).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	badTokens := map[string]string{
+		"alg none":       sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, p.Issuer),
+		"HS256 secret":   sign(jwt.SigningMethodHS256, []byte("secret"), p.Issuer),
+		"wrong key":      sign(jwt.SigningMethodRS256, otherKey, p.Issuer),
+		"unknown issuer": sign(jwt.SigningMethodRS256, otherKey, "https://issuer"),
+		"garbage":        "not.a.jwt",
+	}
+	for name, token := range badTokens {
+		if claims, err := authenticator.ValidateAuthorizationHeader("Bearer " + token); err == nil {
+			t.Errorf("%s: token accepted with claims %v", name, claims)
+		}
+	}
+
+	for _, algs := range [][]string{{"none"}, {"HS256"}, {"RS256", "HS512"}} {
+		config.SigningAlgorithms = algs
+		if _, err := auth.NewOIDCProvider(context.Background(), "http://localhost/webapp/callback", config); err == nil {
+			t.Errorf("provider allowed with algorithms %v", algs)
+		}
+	}
+}
+
+func TestOIDCAuthenticatorRoutesByIssuer(t *testing.T) {
+	var configs []auth.OIDCProviderConfiguration
+	providers := make(map[string]*mockoidc.Provider)
+	for _, name := range []string{"first", "second"} {
+		p, s, err := mockoidc.NewTestServer("client", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		providers[name] = p
+		configs = append(configs, auth.OIDCProviderConfiguration{Name: name, Type: auth.GenericProviderType, IssuerURL: s.URL, ClientID: "client", ClientSecret: "secret"})
+	}
+	authenticator := auth.NewOIDCAuthenticator("http://localhost/webapp/callback", configs...).(*auth.OIDCAuthenticator)
+
+	for name, p := range providers {
+		if got := authenticator.ProviderByIssuer(p.Issuer); got == nil || got.Name != name {
+			t.Errorf("issuer of %s routed to %v", name, got)
+		}
+		token, err := p.SignIDToken(name+"|1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := authenticator.ValidateAuthorizationHeader("Bearer " + token)
+		if err != nil {
+			t.Fatalf("%s: token rejected: %v", name, err)
+		}
+		if claims["iss"] != p.Issuer || claims["sub"] != name+"|1" {
+			t.Errorf("%s: claims %v", name, claims)
+		}
+	}
+
+	unknown, s, err := mockoidc.NewTestServer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	token, err := unknown.SignIDToken("unknown|1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticator.ValidateAuthorizationHeader("Bearer " + token); err == nil {
+		t.Error("token of an unconfigured issuer accepted")
+	}
+}
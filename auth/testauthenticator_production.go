@@ -0,0 +1,11 @@
+//go:build production
+// +build production
+
+package auth
+
+import "errors"
+
+// NewTestAuthenticator always fails, production builds can't accept tokens signed with the test key.
+func NewTestAuthenticator() (Authenticator, error) {
+	return nil, errors.New("the test authenticator is not available in production builds")
+}
@@ -0,0 +1,16 @@
+//go:build production
+// +build production
+
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/genesis32/complianceweb/auth"
+)
+
+func TestTestAuthenticatorUnavailable(t *testing.T) {
+	if _, err := auth.NewTestAuthenticator(); err == nil {
+		t.Fatal("test authenticator available in a production build")
+	}
+}
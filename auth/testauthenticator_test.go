@@ -0,0 +1,57 @@
+//go:build !production
+// +build !production
+
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/utils"
+)
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"iss": "https://issuer", "sub": "oauth|1", "aud": "foo", "exp": 1879432311}
+}
+
+func TestTestAuthenticatorAlgorithms(t *testing.T) {
+	authenticator, err := auth.NewTestAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authenticator.ValidateAuthorizationHeader("Bearer " + utils.GenerateTestJwt("oauth|1")); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeroKey := make([]byte, 64)
+
+	sign := func(method jwt.SigningMethod, key interface{}) string {
+		s, err := jwt.NewWithClaims(method, testClaims()).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	badTokens := map[string]string{
+		"alg none":        sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType),
+		"HS512":           sign(jwt.SigningMethodHS512, zeroKey),
+		"wrong key":       sign(jwt.SigningMethodHS256, []byte("not the zero key")),
+		"RS256":           sign(jwt.SigningMethodRS256, rsaKey),
+		"garbage":         "not.a.jwt",
+		"stripped header": "e30." + jwt.EncodeSegment([]byte(`{"sub":"oauth|1"}`)) + ".",
+	}
+	for name, token := range badTokens {
+		if claims, err := authenticator.ValidateAuthorizationHeader("Bearer " + token); err == nil {
+			t.Errorf("%s: token accepted with claims %v", name, claims)
+		}
+	}
+}
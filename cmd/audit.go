@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/genesis32/complianceweb/audit"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/spf13/cobra"
+)
+
+var restoreTableRegex = regexp.MustCompile("^[a-z_][a-z0-9_]{0,50}$")
+
+func init() {
+	RootCmd.AddCommand(restoreAuditArchiveCommand)
+	restoreAuditArchiveCommand.Flags().StringP("manifest", "m", "", "manifest file of the archive to restore")
+	restoreAuditArchiveCommand.Flags().StringP("table", "t", "", "name of the read only table to restore into")
+	restoreAuditArchiveCommand.MarkFlagRequired("manifest")
+	restoreAuditArchiveCommand.MarkFlagRequired("table")
+}
+
+var restoreAuditArchiveCommand = &cobra.Command{
+	Use:   "restoreauditarchive",
+	Short: "Restore an audit archive into a query only table",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		manifestFile, err := cmd.Flags().GetString("manifest")
+		if err != nil {
+			panic(err)
+		}
+		table, err := cmd.Flags().GetString("table")
+		if err != nil {
+			panic(err)
+		}
+		if !restoreTableRegex.MatchString(table) {
+			log.Fatalf("invalid table name %s", table)
+		}
+
+		manifest, records, err := audit.ReadArchive(manifestFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		daoHandler := dao.NewDaoHandler(nil)
+		daoHandler.Open()
+		defer daoHandler.Close()
+
+		if err := daoHandler.RestoreAuditRecords(table, records); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("restored %d %s audit records (%s - %s) into %s\n", manifest.RecordCount, manifest.InternalKey, manifest.OldestRecord, manifest.NewestRecord, table)
+	},
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/manifest"
+	"github.com/genesis32/complianceweb/server"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(importManifestCommand)
+	importManifestCommand.Flags().StringP("file", "f", "", "the YAML or JSON manifest to import")
+	importManifestCommand.Flags().Int64P("parent", "p", 0, "import into this organization instead of as a new root")
+	importManifestCommand.Flags().Bool("dry-run", false, "only print the changes importing would make")
+	importManifestCommand.MarkFlagRequired("file")
+}
+
+var importManifestCommand = &cobra.Command{
+	Use:   "importmanifest",
+	Short: "Import organizations, users and their roles from a manifest",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		file, err := cmd.Flags().GetString("file")
+		if err != nil {
+			panic(err)
+		}
+		parentID, err := cmd.Flags().GetInt64("parent")
+		if err != nil {
+			panic(err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			panic(err)
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m, err := manifest.Parse(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		daoHandler := dao.NewDaoHandler(nil)
+		daoHandler.Open()
+		defer daoHandler.Close()
+
+		changes, err := server.ImportManifest(daoHandler, parentID, m, !dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(&server.ImportResponse{DryRun: dryRun, Changes: changes}); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
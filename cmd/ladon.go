@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/ladon"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(exportLadonCommand)
+	exportLadonCommand.Flags().Int64P("organization", "o", 0, "only export the subtree of this organization")
+}
+
+var exportLadonCommand = &cobra.Command{
+	Use:   "exportladon",
+	Short: "Export the grants and denies as Ory Ladon policies",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		organizationID, err := cmd.Flags().GetInt64("organization")
+		if err != nil {
+			panic(err)
+		}
+
+		daoHandler := dao.NewDaoHandler(nil)
+		daoHandler.Open()
+		defer daoHandler.Close()
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(ladon.Policies(daoHandler.LoadPolicyExport(organizationID))); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
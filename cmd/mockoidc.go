@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/genesis32/complianceweb/auth/mockoidc"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(mockOIDCCommand)
+	mockOIDCCommand.Flags().IntP("port", "p", 9999, "port to listen on")
+	mockOIDCCommand.Flags().String("clientid", "mockoidc", "client id the provider accepts")
+	mockOIDCCommand.Flags().String("clientsecret", "mockoidc", "client secret the provider accepts")
+	mockOIDCCommand.Flags().StringP("sub", "s", "mockoidc|subject", "subject of users logging in without a login_hint")
+}
+
+var mockOIDCCommand = &cobra.Command{
+	Use:   "mockoidc",
+	Short: "Run a local OpenID Connect provider that logs everyone in",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		port, err := cmd.Flags().GetInt("port")
+		if err != nil {
+			panic(err)
+		}
+		clientID, err := cmd.Flags().GetString("clientid")
+		if err != nil {
+			panic(err)
+		}
+		clientSecret, err := cmd.Flags().GetString("clientsecret")
+		if err != nil {
+			panic(err)
+		}
+		sub, err := cmd.Flags().GetString("sub")
+		if err != nil {
+			panic(err)
+		}
+
+		issuer := fmt.Sprintf("http://localhost:%d", port)
+		provider, err := mockoidc.New(issuer, clientID, clientSecret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider.Subject = sub
+
+		log.Printf("mock oidc provider listening with issuer %s", issuer)
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), provider.Handler()))
+	},
+}
@@ -0,0 +1,527 @@
+// Package condition implements the small expression language of conditional role assignments. A condition is
+// evaluated against the attributes of a request, e.g.
+//
+//	organization.metadata.region == 'eu' && ipInRange(request.ip, '10.0.0.0/8') && request.hour >= 9
+//
+// Attributes are dotted paths into nested maps, missing ones evaluate to null. Literals are strings in single or
+// double quotes, numbers, true, false, null and lists in square brackets. The operators are ==, !=, <, <=, >, >=,
+// in, &&, || and !, with the usual precedence, and the only function is ipInRange(ip, cidr...).
+package condition
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxLength bounds the length of a condition.
+const MaxLength = 1024
+
+// ErrNotBoolean is returned when a condition doesn't evaluate to true or false.
+var ErrNotBoolean = errors.New("condition is not a boolean")
+
+// Expression is a compiled condition.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses a condition.
+func Compile(source string) (*Expression, error) {
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("condition longer than %d characters", MaxLength)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", p.peek().text, p.peek().pos)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the condition.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Evaluate returns whether the condition holds for the attributes.
+func (e *Expression) Evaluate(attributes map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(attributes)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, ErrNotBoolean
+	}
+	return b, nil
+}
+
+// Lookup returns the attribute at a dotted path, or nil if there is none.
+func Lookup(attributes map[string]interface{}, path string) interface{} {
+	var v interface{} = attributes
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(source[i+1:], source[i])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: source[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(source) && unicode.IsDigit(rune(source[i+1]))):
+			start := i
+			for i++; i < len(source) && (unicode.IsDigit(rune(source[i])) || source[i] == '.'); i++ {
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], pos: start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i++; i < len(source) && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])) || source[i] == '_' || source[i] == '.'); i++ {
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: source[start:i], pos: start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); (t.kind == tokenOperator || t.kind == tokenIdentifier) && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("expected %q at %d", op, p.peek().pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{or: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if p.accept(op) {
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return &comparisonNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return &literalNode{value: t.text}, nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return &literalNode{value: f}, nil
+	case tokenIdentifier:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{}, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		return &attributeNode{path: t.text}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items: items}, nil
+		}
+	}
+	if t.kind == tokenEOF {
+		return nil, errors.New("unexpected end of condition")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) parseList(end string) ([]node, error) {
+	var items []node
+	if p.accept(end) {
+		return items, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.accept(end) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	args, err := p.parseList(")")
+	if err != nil {
+		return nil, err
+	}
+	switch name.text {
+	case "ipInRange":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("ipInRange needs an ip and at least one range at %d", name.pos)
+		}
+		var ranges []*net.IPNet
+		for _, arg := range args[1:] {
+			l, ok := arg.(*literalNode)
+			s, isString := l.stringValue()
+			if !ok || !isString {
+				return nil, fmt.Errorf("ipInRange ranges must be strings at %d", name.pos)
+			}
+			_, ipNet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q at %d", s, name.pos)
+			}
+			ranges = append(ranges, ipNet)
+		}
+		return &ipInRangeNode{ip: args[0], ranges: ranges}, nil
+	}
+	return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+}
+
+type node interface {
+	eval(attributes map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *literalNode) stringValue() (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	s, ok := n.value.(string)
+	return s, ok
+}
+
+type attributeNode struct {
+	path string
+}
+
+func (n *attributeNode) eval(attributes map[string]interface{}) (interface{}, error) {
+	return normalize(Lookup(attributes, n.path)), nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(attributes map[string]interface{}) (interface{}, error) {
+	ret := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(attributes)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(attributes map[string]interface{}) (interface{}, error) {
+	v, err := evalBool(n.operand, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return !v, nil
+}
+
+type logicalNode struct {
+	or          bool
+	left, right node
+}
+
+func (n *logicalNode) eval(attributes map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(n.left, attributes)
+	if err != nil {
+		return nil, err
+	}
+	if left == n.or {
+		return left, nil
+	}
+	return evalBool(n.right, attributes)
+}
+
+type comparisonNode struct {
+	op          string
+	left, right node
+}
+
+func (n *comparisonNode) eval(attributes map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(attributes)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		items, ok := right.([]interface{})
+		if !ok {
+			return nil, errors.New("in needs a list")
+		}
+		for _, item := range items {
+			if equal(left, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("can't compare %v and %v", left, right)
+		}
+		cmp = compareFloats(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("can't compare %v and %v", left, right)
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("can't compare %v and %v", left, right)
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+type ipInRangeNode struct {
+	ip     node
+	ranges []*net.IPNet
+}
+
+func (n *ipInRangeNode) eval(attributes map[string]interface{}) (interface{}, error) {
+	v, err := n.ip.eval(attributes)
+	if err != nil {
+		return nil, err
+	}
+	s, _ := v.(string)
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false, nil
+	}
+	for _, r := range n.ranges {
+		if r.Contains(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func evalBool(n node, attributes map[string]interface{}) (bool, error) {
+	v, err := n.eval(attributes)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, ErrNotBoolean
+	}
+	return b, nil
+}
+
+// normalize turns the numbers attributes can hold into float64 so they compare with number literals.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+func equal(a, b interface{}) bool {
+	switch a.(type) {
+	case nil, bool, float64, string:
+		return a == b
+	}
+	return false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package condition
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	attributes := map[string]interface{}{
+		"request": map[string]interface{}{
+			"ip":      "10.1.2.3",
+			"hour":    14,
+			"weekday": 3,
+		},
+		"organization": map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"region": "eu",
+				"tier":   float64(2),
+			},
+		},
+	}
+
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"organization.metadata.region == 'eu'", true},
+		{`organization.metadata.region != "eu"`, false},
+		{"organization.metadata.tier >= 2 && organization.metadata.tier < 3", true},
+		{"organization.metadata.missing == null", true},
+		{"organization.metadata.missing == 'eu'", false},
+		{"ipInRange(request.ip, '192.168.0.0/16', '10.0.0.0/8')", true},
+		{"ipInRange(request.ip, '192.168.0.0/16')", false},
+		{"request.hour >= 9 && request.hour < 17 && request.weekday in [1, 2, 3, 4, 5]", true},
+		{"!(request.hour >= 9) || false", false},
+		{"true || request.hour < 'x'", true},
+	}
+	for _, test := range tests {
+		e, err := Compile(test.source)
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		got, err := e.Evaluate(attributes)
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v want %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	for _, source := range []string{"request.hour", "request.hour < 'x'", "organization.metadata.region < 3"} {
+		e, err := Compile(source)
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		if _, err := e.Evaluate(map[string]interface{}{"request": map[string]interface{}{"hour": 3}}); err == nil {
+			t.Errorf("%s: expected an error", source)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, source := range []string{"", "a ==", "'open", "a == b c", "ipInRange(a)", "ipInRange(a, 'nope')", "unknown(a)", "(a == b", "a # b"} {
+		if _, err := Compile(source); err == nil {
+			t.Errorf("%q: expected an error", source)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/lib/pq"
+)
+
+// CreateServicePrincipal creates an active non human user. Its credential is its own id at idpIssuer, it
+// authenticates with api keys instead of through an identity provider.
+func (d *dao) CreateServicePrincipal(organizationID int64, name, idpIssuer string) int64 {
+	orgUserID := utils.GetNextUniqueId()
+
+	sqlStatement := `
+		INSERT INTO organization_user (id, display_name, user_type, created_timestamp, current_state)
+		VALUES ($1, $2, $3, NOW(), $4);
+	`
+	_, err := d.Db.Exec(sqlStatement, orgUserID, name, ServiceUserType, UserActiveState)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = d.LinkUserIdentity(&UserIdentity{
+		ID:                 utils.GetNextUniqueId(),
+		OrganizationUserID: orgUserID,
+		IdpType:            ServiceIdpType,
+		IdpIssuer:          idpIssuer,
+		IdpCredentialValue: fmt.Sprintf("%d", orgUserID),
+		CreatedTimestamp:   time.Now().UTC(),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if organizationID != 0 {
+		sqlRefStatement := `
+INSERT INTO organization_organization_user_xref (organization_id, organization_user_id) VALUES ($1, $2);
+	`
+		_, err = d.Db.Exec(sqlRefStatement, organizationID, orgUserID)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return orgUserID
+}
+
+func (d *dao) CreateAPIKey(apiKey *APIKey) {
+	sqlStatement := `
+		INSERT INTO
+			api_key
+		(id, organization_user_id, key_hash, scopes, current_state, created_timestamp, expiration_timestamp)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7)
+`
+	_, err := d.Db.Exec(sqlStatement, apiKey.ID, apiKey.OrganizationUserID, apiKey.KeyHash, pq.Array(apiKey.Scopes), apiKey.CurrentState, apiKey.CreatedTimestamp, apiKey.ExpirationTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+const apiKeyColumns = `id, organization_user_id, key_hash, scopes, current_state, created_timestamp, expiration_timestamp, last_used_timestamp`
+
+func scanAPIKey(scanner interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	ret := &APIKey{}
+	var lastUsed sql.NullTime
+	err := scanner.Scan(&ret.ID, &ret.OrganizationUserID, &ret.KeyHash, pq.Array(&ret.Scopes), &ret.CurrentState, &ret.CreatedTimestamp, &ret.ExpirationTimestamp, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	ret.LastUsedTimestamp = lastUsed.Time
+	return ret, nil
+}
+
+func (d *dao) LoadAPIKey(id int64) *APIKey {
+	sqlStatement := `SELECT ` + apiKeyColumns + ` FROM api_key WHERE id = $1`
+
+	ret, err := scanAPIKey(d.Db.QueryRow(sqlStatement, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+func (d *dao) LoadAPIKeysForUser(userID int64) []*APIKey {
+	sqlStatement := `SELECT ` + apiKeyColumns + ` FROM api_key WHERE organization_user_id = $1 ORDER BY created_timestamp`
+
+	rows, err := d.Db.Query(sqlStatement, userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func (d *dao) RevokeAPIKey(userID, id int64) bool {
+	sqlStatement := `
+		UPDATE
+			api_key
+		SET
+			current_state = $3
+		WHERE
+			id = $1 AND
+			organization_user_id = $2 AND
+			current_state = $4
+`
+	res, err := d.Db.Exec(sqlStatement, id, userID, APIKeyRevokedState, APIKeyActiveState)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cnt, err := res.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cnt > 0
+}
+
+func (d *dao) TouchAPIKey(id int64, used time.Time) {
+	sqlStatement := `UPDATE api_key SET last_used_timestamp = $2 WHERE id = $1`
+	_, err := d.Db.Exec(sqlStatement, id, used)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
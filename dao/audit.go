@@ -0,0 +1,112 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func (d *dao) LoadAuditRecordsBefore(internalKey string, before time.Time, limit int) []*AuditRecord {
+	sqlStatement := `
+		SELECT
+			id, created, current_state, organization_user_id, organization_id, internal_key, method, metadata, human_readable
+		FROM
+			resource_audit_log
+		WHERE
+			internal_key = $1 AND
+			created < $2
+		ORDER BY
+			created
+		LIMIT $3
+`
+	rows, err := d.Db.Query(sqlStatement, internalKey, before, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*AuditRecord, 0)
+	for rows.Next() {
+		r := &AuditRecord{}
+		var metadata []byte
+		var humanReadable sql.NullString
+		err = rows.Scan(&r.ID, &r.CreatedTimestamp, &r.CurrentState, &r.OrganizationUserID, &r.OrganizationID, &r.InternalKey, &r.Method, &metadata, &humanReadable)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// unsealed records have no metadata yet
+		if metadata != nil {
+			if err := json.Unmarshal(metadata, &r.Metadata); err != nil {
+				log.Fatalf("error decoding metadata for audit record %d: %v", r.ID, err)
+			}
+		}
+		r.HumanReadable = humanReadable.String
+		ret = append(ret, r)
+	}
+
+	return ret
+}
+
+func (d *dao) DeleteAuditRecords(ids []int64) {
+	sqlStatement := `
+		DELETE FROM
+			resource_audit_log
+		WHERE
+			id = ANY($1)
+`
+	_, err := d.Db.Exec(sqlStatement, pq.Array(ids))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (d *dao) RestoreAuditRecords(tableName string, records []*AuditRecord) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting restore of %s: %w", tableName, err)
+	}
+
+	table := pq.QuoteIdentifier(tableName)
+
+	_, err = tx.Exec(fmt.Sprintf(`CREATE TABLE %s (LIKE resource_audit_log INCLUDING ALL)`, table))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error creating restore table %s: %w", tableName, err)
+	}
+
+	sqlStatement := fmt.Sprintf(`
+		INSERT INTO
+			%s
+		(id, created, current_state, organization_user_id, organization_id, internal_key, method, metadata, human_readable)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`, table)
+	for _, r := range records {
+		var metadata interface{}
+		if r.Metadata != nil {
+			metadata = r.Metadata
+		}
+		_, err = tx.Exec(sqlStatement, r.ID, r.CreatedTimestamp, r.CurrentState, r.OrganizationUserID, r.OrganizationID, r.InternalKey, r.Method, metadata, r.HumanReadable)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error restoring audit record %d: %w", r.ID, err)
+		}
+	}
+
+	// Once the records are in, nothing is allowed to touch them.
+	_, err = tx.Exec(fmt.Sprintf(`
+		CREATE TRIGGER %s
+			BEFORE INSERT OR UPDATE OR DELETE OR TRUNCATE ON %s
+			FOR EACH STATEMENT EXECUTE FUNCTION resource_audit_log_read_only()
+`, pq.QuoteIdentifier(tableName+"_read_only"), table))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error making %s read only: %w", tableName, err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,222 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Errors returned when breaking the glass or reviewing it isn't allowed in the access' current state.
+var (
+	ErrBreakGlassUnreviewed = errors.New("a previous break glass access has not been reviewed")
+	ErrBreakGlassReviewed   = errors.New("break glass access was already reviewed")
+)
+
+// SetSystemRoleToUser assigns or removes a role outside of any organization, leaving the user's other system
+// roles as they are.
+func (d *dao) SetSystemRoleToUser(userID int64, roleName string, assigned bool) {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sqlStatement := `
+		DELETE FROM
+			organization_organization_user_role_xref
+		WHERE
+			organization_id IS NULL AND organization_user_id = $1 AND role_id = (SELECT id FROM role WHERE display_name = $2)
+`
+	if _, err := tx.Exec(sqlStatement, userID, roleName); err != nil {
+		log.Fatal(err)
+	}
+	if assigned {
+		sqlStatement := `
+		INSERT INTO
+			organization_organization_user_role_xref
+		(organization_id, organization_user_id, role_id)
+		VALUES
+		(NULL, $1, (SELECT id FROM role WHERE display_name = $2))
+`
+		if _, err := tx.Exec(sqlStatement, userID, roleName); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ActivateBreakGlass records the access and assigns roleName outside of any organization until its expiration.
+// It returns ErrBreakGlassUnreviewed if an earlier access of the user is still waiting for its review.
+func (d *dao) ActivateBreakGlass(access *BreakGlassAccess, roleName string) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// Serialize activations of the same user so two requests can't both find nothing left to review.
+	if _, err := tx.Exec(`SELECT id FROM organization_user WHERE id = $1 FOR UPDATE`, access.OrganizationUserID); err != nil {
+		log.Fatal(err)
+	}
+
+	var unreviewed int
+	sqlStatement := `SELECT count(1) FROM break_glass_access WHERE organization_user_id = $1 AND reviewed_timestamp IS NULL`
+	if err := tx.QueryRow(sqlStatement, access.OrganizationUserID).Scan(&unreviewed); err != nil {
+		log.Fatal(err)
+	}
+	if unreviewed > 0 {
+		return ErrBreakGlassUnreviewed
+	}
+
+	sqlStatement = `
+		INSERT INTO
+			break_glass_access
+		(id, organization_user_id, reason, activated_timestamp, expiration_timestamp)
+		VALUES
+		($1, $2, $3, $4, $5)
+`
+	_, err = tx.Exec(sqlStatement, access.ID, access.OrganizationUserID, access.Reason, access.ActivatedTimestamp, access.ExpirationTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sqlStatement = `
+		INSERT INTO
+			organization_organization_user_role_xref
+		(organization_id, organization_user_id, role_id, valid_from, valid_until)
+		VALUES
+		(NULL, $1, (SELECT id FROM role WHERE display_name = $2), $3, $4)
+`
+	_, err = tx.Exec(sqlStatement, access.OrganizationUserID, roleName, access.ActivatedTimestamp, access.ExpirationTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+const breakGlassAccessColumns = `
+	id, organization_user_id, reason, activated_timestamp, expiration_timestamp, reviewer_id, review_notes, reviewed_timestamp
+`
+
+func scanBreakGlassAccesses(rows *sql.Rows) []*BreakGlassAccess {
+	defer rows.Close()
+
+	ret := make([]*BreakGlassAccess, 0)
+	for rows.Next() {
+		access := &BreakGlassAccess{}
+		var reviewerID sql.NullInt64
+		var reviewNotes sql.NullString
+		var reviewed sql.NullTime
+		err := rows.Scan(&access.ID, &access.OrganizationUserID, &access.Reason, &access.ActivatedTimestamp, &access.ExpirationTimestamp,
+			&reviewerID, &reviewNotes, &reviewed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		access.ReviewerID, access.ReviewNotes, access.ReviewedTimestamp = reviewerID.Int64, reviewNotes.String, reviewed.Time
+		ret = append(ret, access)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+func (d *dao) LoadBreakGlassAccess(id int64) *BreakGlassAccess {
+	rows, err := d.Db.Query(`SELECT `+breakGlassAccessColumns+` FROM break_glass_access WHERE id = $1`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	accesses := scanBreakGlassAccesses(rows)
+	if len(accesses) == 0 {
+		return nil
+	}
+	return accesses[0]
+}
+
+// LoadBreakGlassAccesses returns every access, newest first.
+func (d *dao) LoadBreakGlassAccesses() []*BreakGlassAccess {
+	rows, err := d.Db.Query(`SELECT ` + breakGlassAccessColumns + ` FROM break_glass_access ORDER BY activated_timestamp DESC`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return scanBreakGlassAccesses(rows)
+}
+
+// EndBreakGlassAccess ends an access and the role it granted at now, it returns false if the access had already
+// ended.
+func (d *dao) EndBreakGlassAccess(access *BreakGlassAccess, now time.Time) bool {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sqlStatement := `
+		UPDATE
+			break_glass_access
+		SET
+			expiration_timestamp = $2
+		WHERE
+			id = $1 AND expiration_timestamp > $2
+`
+	result, err := tx.Exec(sqlStatement, access.ID, now.UTC())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		log.Fatal(err)
+	} else if n == 0 {
+		return false
+	}
+
+	sqlStatement = `
+		UPDATE
+			organization_organization_user_role_xref
+		SET
+			valid_until = $3
+		WHERE
+			organization_id IS NULL AND organization_user_id = $1 AND valid_until = $2
+`
+	if _, err := tx.Exec(sqlStatement, access.OrganizationUserID, access.ExpirationTimestamp.UTC(), now.UTC()); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	access.ExpirationTimestamp = now.UTC()
+	return true
+}
+
+// ReviewBreakGlassAccess records the post incident review of an access, it returns ErrBreakGlassReviewed if it
+// was already reviewed.
+func (d *dao) ReviewBreakGlassAccess(access *BreakGlassAccess) error {
+	sqlStatement := `
+		UPDATE
+			break_glass_access
+		SET
+			reviewer_id = $2, review_notes = $3, reviewed_timestamp = $4
+		WHERE
+			id = $1 AND reviewed_timestamp IS NULL
+`
+	result, err := d.Db.Exec(sqlStatement, access.ID, access.ReviewerID, access.ReviewNotes, access.ReviewedTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n == 0 {
+		return ErrBreakGlassReviewed
+	}
+	return nil
+}
@@ -27,6 +27,48 @@ const (
 	UserDeactiveState = 2
 )
 
+// The kinds of principals that can be users.
+const (
+	HumanUserType   = 0
+	ServiceUserType = 1
+)
+
+// States an api key can be in.
+const (
+	APIKeyActiveState  = 1
+	APIKeyRevokedState = 2
+)
+
+// States a token signing key can be in. Retired keys no longer sign but are still published so the tokens
+// they signed can be verified until they expire.
+const (
+	SigningKeyActiveState  = 1
+	SigningKeyRetiredState = 2
+)
+
+// States an elevation request can be in. Approved requests hold their role until they expire.
+const (
+	ElevationPendingState  = 0
+	ElevationApprovedState = 1
+	ElevationDeniedState   = 2
+	ElevationExpiredState  = 3
+)
+
+// How far down the organization tree a role assignment applies.
+const (
+	RoleInheritanceSubtree      = 0 // the organization and its descendants
+	RoleInheritanceOrganization = 1 // the organization only
+	RoleInheritanceDescendants  = 2 // the descendants of the organization only
+)
+
+// States a pending operation can be in. An approved operation has been executed.
+const (
+	OperationPendingState  = 0
+	OperationApprovedState = 1
+	OperationDeniedState   = 2
+	OperationExpiredState  = 3
+)
+
 // RegisteredResourcesStore is the the resources which the permissions can operate on.
 type RegisteredResourcesStore map[string]*RegisteredResource
 
@@ -58,6 +100,7 @@ func (a *AuditMetadata) Scan(value interface{}) error {
 type AuditRecord struct {
 	ID                 int64
 	CreatedTimestamp   time.Time
+	CurrentState       int
 	OrganizationUserID int64
 	OrganizationID     int64
 	InternalKey        string
@@ -91,31 +134,127 @@ type DaoHandler interface {
 	AssignOrganizationToParent(parentID, orgID int64) bool
 	LoadOrganizationsForUser(userID int64) map[int64]*Organization
 	LoadOrganizationDetails(organizationID int64, permissionFlags uint) *Organization
+	LoadChildOrganizations(organizationID int64) []*Organization
+	ApplyImportPlan(plan *ImportPlan, commit bool) error
 
 	CreateInviteForUser(organizationID int64, name string) (int64, int64)
+	CreateServicePrincipal(organizationID int64, name, idpIssuer string) int64
+
+	CreateAPIKey(apiKey *APIKey)
+	LoadAPIKey(id int64) *APIKey
+	LoadAPIKeysForUser(userID int64) []*APIKey
+	RevokeAPIKey(userID, id int64) bool
+	TouchAPIKey(id int64, used time.Time)
+
+	CreateSigningKey(signingKey *SigningKey)
+	LoadSigningKeys() []*SigningKey
+	PurgeSigningKeys(retiredBefore time.Time)
 
 	LoadUserFromInviteCode(inviteCode int64) *OrganizationUser
-	LoadUserFromCredential(credential string, state int) *OrganizationUser
+	LoadUserFromCredential(idpIssuer, credential string, state int) *OrganizationUser
 	LoadUserFromID(id int64) *OrganizationUser
 	UpdateUserState(id int64, state int)
 
-	InitUserFromInviteCode(inviteCode, idpAuthCredential string) bool
-	LogUserIn(idpAuthCredential string) (*OrganizationUser, error)
+	CreateProvisionedUser(organizationID int64, user *OrganizationUser, identity *UserIdentity) error
+	UpdateProvisionedUser(user *OrganizationUser)
+	LoadOrganizationMembers(organizationID int64, userName string) []*OrganizationUser
+	IsOrganizationMember(organizationID, userID int64) bool
+
+	LoadRoles() []*Role
+	LoadRoleMembers(organizationID, roleID int64) []int64
+	ChangeRoleMembers(organizationID, roleID int64, addedUserIDs, removedUserIDs []int64) error
+
+	CreateUserGroup(group *UserGroup) error
+	LoadUserGroup(groupID int64) *UserGroup
+	LoadUserGroups(organizationID int64) []*UserGroup
+	DeleteUserGroup(groupID int64)
+	SetUserGroupMembers(groupID int64, userIDs, groupIDs []int64) error
+	SetRolesToUserGroup(groupID int64, assignments []*RoleAssignment) error
+	LoadUserGroupGrantedRoles(groupID int64) map[int64][]string
+	IsUserInUserGroup(userID, groupID int64) bool
+	IsOrganizationInSubtree(rootID, organizationID int64) bool
+
+	LinkUserIdentity(identity *UserIdentity) error
+	LoadUserIdentities(userID int64) []*UserIdentity
+	UnlinkUserIdentity(userID, identityID int64) error
+	AssignLegacyIdentityIssuer(idpType, idpIssuer string) int64
+
+	CreateUserSession(userSession *UserSession)
+	LoadUserSession(id string, now time.Time) *UserSession
+	RevokeUserSession(id string, revoked time.Time)
+	RevokeUserSessions(userID int64, revoked time.Time) int64
+	PurgeUserSessions(before time.Time)
+
+	CreateLoginState(loginState *LoginState)
+	ConsumeLoginState(state string, createdAfter time.Time) *LoginState
+	PurgeLoginStates(createdBefore time.Time)
+
+	InitUserFromInviteCode(inviteCode, idpType, idpIssuer, idpAuthCredential string) error
+	LogUserIn(idpIssuer, idpAuthCredential string) (*OrganizationUser, error)
 	CanUserViewOrg(userID, organizationID int64) bool
 
 	DoesUserHavePermission(userID, organizationID int64, permission string) bool
 	DoesUserHaveSystemPermission(userID int64, permission string) bool
+	DoesAnyActiveUserHaveSystemPermission(permission string) bool
+	LoadEffectivePermissions(userID, organizationID int64) map[int64][]string
+	LoadPermissionGrantConditions(userID, organizationID int64, permission string) []string
 
 	UpdateSettings(settings ...*Setting) error
 	GetSettings(key ...string) SettingsStore
-
-	SetRolesToUser(userID, organizationID int64, roleNames []string)
+	GetSettingsWithPrefix(prefix string) SettingsStore
+
+	SetRolesToUser(userID, organizationID int64, roleNames []string) error
+	SetTimeBoundRolesToUser(organizationID, userID int64, roleNames []string, validFrom, validUntil time.Time) error
+	SetRoleAssignmentsToUser(userID int64, assignments []*RoleAssignment) error
+	CheckSeparationOfDutyForRoles(organizationID int64, roleNames []string) error
+	RolesExceedingUserPermissions(userID, organizationID int64, roleNames []string) []string
+	ExpireRoleAssignments(now time.Time) []*ExpiredRoleAssignment
+
+	CreateElevationRequest(request *ElevationRequest)
+	LoadElevationRequest(id int64) *ElevationRequest
+	LoadElevationRequestsForUser(userID int64) []*ElevationRequest
+	LoadElevationRequestsInTree(organizationID int64) []*ElevationRequest
+	DecideElevationRequest(request *ElevationRequest) error
+	ExpireElevationRequests(now, requestedBefore time.Time) []*ElevationRequest
+
+	SetDualControlPolicies(organizationID int64, permissions []string)
+	LoadDualControlPolicies(organizationID int64) map[int64][]string
+	RequiresDualControl(organizationID int64, permission string) bool
+	CreatePendingOperation(operation *PendingOperation)
+	LoadPendingOperation(id int64) *PendingOperation
+	LoadPendingOperationsInTree(organizationID int64) []*PendingOperation
+	DecidePendingOperation(operation *PendingOperation) error
+	RecordPendingOperationResult(id int64, status int, body string)
+
+	CreatePermissionDeny(deny *PermissionDeny)
+	LoadPermissionDeny(id int64) *PermissionDeny
+	LoadPermissionDeniesInTree(organizationID int64) []*PermissionDeny
+	DeletePermissionDeny(id int64)
+	ExplainPermission(userID, organizationID int64, permission string) *PermissionExplanation
+	LoadPolicyExport(organizationID int64) *PolicyExport
+
+	CreateSeparationOfDuty(constraint *SeparationOfDuty)
+	LoadSeparationOfDuty(id int64) *SeparationOfDuty
+	LoadSeparationOfDutiesForOrganization(organizationID int64) []*SeparationOfDuty
+	DeleteSeparationOfDuty(id int64)
+	LoadSeparationOfDutyViolations(organizationID int64) []*SeparationOfDutyViolation
+
+	SetSystemRoleToUser(userID int64, roleName string, assigned bool)
+	ActivateBreakGlass(access *BreakGlassAccess, roleName string) error
+	LoadBreakGlassAccess(id int64) *BreakGlassAccess
+	LoadBreakGlassAccesses() []*BreakGlassAccess
+	EndBreakGlassAccess(access *BreakGlassAccess, now time.Time) bool
+	ReviewBreakGlassAccess(access *BreakGlassAccess) error
 	LoadEnabledResources() RegisteredResourcesStore
 
 	HasValidRoles(roles []string) bool
+	HasValidPermissions(permissions []string) bool
 
 	CreateAuditRecord(record *AuditRecord)
 	SealAuditRecord(record *AuditRecord)
+	LoadAuditRecordsBefore(internalKey string, before time.Time, limit int) []*AuditRecord
+	DeleteAuditRecords(ids []int64)
+	RestoreAuditRecords(tableName string, records []*AuditRecord) error
 }
 
 type dao struct {
@@ -174,6 +313,28 @@ func (d *dao) HasValidRoles(roles []string) bool {
 	return cnt == len(roles)
 }
 
+// HasValidPermissions returns true if all of the permissions exist. Permission patterns only make sense in roles so
+// they aren't valid permissions.
+func (d *dao) HasValidPermissions(permissions []string) bool {
+
+	sqlStatement := `
+		SELECT 
+			COUNT(DISTINCT value)
+		FROM
+			permission
+		WHERE
+			value = ANY($1) AND strpos(value, '*') = 0
+`
+	var cnt int
+	row := d.Db.QueryRow(sqlStatement, pq.Array(permissions))
+	err := row.Scan(&cnt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cnt == len(permissions)
+}
+
+// UpdateUserState changes the state of a user, deactivating a user also revokes all of its sessions.
 func (d *dao) UpdateUserState(id int64, state int) {
 	sqlStatement := `
 		UPDATE organization_user SET current_state = $2 WHERE id = $1 
@@ -183,6 +344,10 @@ func (d *dao) UpdateUserState(id int64, state int) {
 	if err != nil {
 		log.Fatalf("error updating state of user %d to %d err: %v", id, state, err)
 	}
+
+	if state == UserDeactiveState {
+		d.RevokeUserSessions(id, time.Now().UTC())
+	}
 }
 
 func (d *dao) LoadUserFromID(id int64) *OrganizationUser {
@@ -190,14 +355,14 @@ func (d *dao) LoadUserFromID(id int64) *OrganizationUser {
 	{
 		sqlStatement := `
 			SELECT
-				id, display_name, current_state
+				id, display_name, user_type, COALESCE(user_name, ''), COALESCE(external_id, ''), current_state
 			FROM
 				organization_user 
 			WHERE
 				id = $1
 `
 		row := d.Db.QueryRow(sqlStatement, id)
-		err := row.Scan(&ret.ID, &ret.DisplayName, &ret.CurrentState)
+		err := row.Scan(&ret.ID, &ret.DisplayName, &ret.UserType, &ret.UserName, &ret.ExternalID, &ret.CurrentState)
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil
 		}
@@ -210,11 +375,11 @@ func (d *dao) LoadUserFromID(id int64) *OrganizationUser {
 	{
 		sqlStatement := `
 		SELECT 
-			organization_id, role_id, (SELECT display_name FROM role where id = role_id) 
+			organization_id, role_id, (SELECT display_name FROM role where id = role_id), valid_from, valid_until, COALESCE(inheritance, 0), COALESCE(condition, ''), COALESCE(elevation_request_id, 0)
 		FROM 
 			organization_organization_user_role_xref 
 		WHERE 
-			organization_user_id = $1
+			organization_user_id = $1 AND (valid_until IS NULL OR valid_until > now() AT TIME ZONE 'UTC')
 `
 		rows, err := d.Db.Query(sqlStatement, id)
 		if err != nil {
@@ -226,12 +391,16 @@ func (d *dao) LoadUserFromID(id int64) *OrganizationUser {
 			var roleID int64
 			var roleName string
 			var organizationID sql.NullInt64
-			err = rows.Scan(&organizationID, &roleID, &roleName)
+			var validFrom, validUntil sql.NullTime
+			var inheritance int
+			var condition string
+			var elevationRequestID int64
+			err = rows.Scan(&organizationID, &roleID, &roleName, &validFrom, &validUntil, &inheritance, &condition, &elevationRequestID)
 			if err != nil {
 				log.Fatal(err)
 			}
 			if organizationID.Valid {
-				ret.UserRoles[organizationID.Int64] = append(ret.UserRoles[organizationID.Int64], Role{ID: roleID, DisplayName: roleName})
+				ret.UserRoles[organizationID.Int64] = append(ret.UserRoles[organizationID.Int64], Role{ID: roleID, DisplayName: roleName, ValidFrom: validFrom.Time, ValidUntil: validUntil.Time, Inheritance: inheritance, Condition: condition, ElevationRequestID: elevationRequestID})
 				ret.Organizations = append(ret.Organizations, organizationID.Int64)
 			}
 		}
@@ -271,40 +440,14 @@ func NewDaoHandler(db *sql.DB) DaoHandler {
 	return &dao{Db: db}
 }
 
-func (d *dao) SetRolesToUser(organizationID, userID int64, roleNames []string) {
-	tx, _ := d.Db.Begin()
-
-	sqlStatement := `
-		DELETE FROM
-			organization_organization_user_role_xref
-		WHERE 
-			organization_id = $1 
-			AND organization_user_id = $2
-`
-	_, err := tx.Exec(sqlStatement, organizationID, userID)
-	if err != nil {
-		tx.Rollback()
-		log.Fatal(err)
-	}
+func (d *dao) SetRolesToUser(organizationID, userID int64, roleNames []string) error {
+	return d.SetTimeBoundRolesToUser(organizationID, userID, roleNames, time.Time{}, time.Time{})
+}
 
-	for i := range roleNames {
-		sqlStatement := `
-		INSERT INTO
-				organization_organization_user_role_xref
-		(organization_id, organization_user_id, role_id)
-		VALUES
-				(NULLIF($1::bigint,0), $2, (SELECT id FROM role WHERE display_name = $3))
-`
-		_, err := tx.Exec(sqlStatement, organizationID, userID, roleNames[i])
-		if err != nil {
-			tx.Rollback()
-			log.Fatal(err)
-		}
-	}
-	err = tx.Commit()
-	if err != nil {
-		log.Fatal(err)
-	}
+// SetTimeBoundRolesToUser replaces the roles of a user in an organization with roles that are only valid from
+// validFrom until validUntil, a zero time leaves that side of the window open.
+func (d *dao) SetTimeBoundRolesToUser(organizationID, userID int64, roleNames []string, validFrom, validUntil time.Time) error {
+	return d.SetRoleAssignmentsToUser(userID, []*RoleAssignment{{OrganizationID: organizationID, RoleNames: roleNames, ValidFrom: validFrom, ValidUntil: validUntil}})
 }
 
 func (d *dao) UpdateSettings(settings ...*Setting) error {
@@ -363,19 +506,47 @@ func (d *dao) GetSettings(keys ...string) SettingsStore {
 	return ret
 }
 
+func (d *dao) GetSettingsWithPrefix(prefix string) SettingsStore {
+
+	sqlStatement := `
+		SELECT
+				key, value
+		FROM
+				settings
+		WHERE
+				starts_with(key, $1)
+`
+	rows, err := d.Db.Query(sqlStatement, prefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make(SettingsStore)
+	for rows.Next() {
+		s := &Setting{}
+		err = rows.Scan(&s.Key, &s.Value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ret[s.Key] = s
+	}
+
+	return ret
+}
+
 func (d *dao) DoesUserHaveSystemPermission(userID int64, permission string) bool {
 	// TODO: Verify that this has permission starts with system.
 	sqlStatement := `
 				SELECT
 						count(1)
 				FROM
-					organization_organization_user_role_xref 
+					organization_user_roles($1)
 				WHERE 
-						organization_id IS NULL AND 
-						organization_user_id = $1 AND 
+						organization_id IS NULL AND condition IS NULL AND
 						role_id IN 
 						(SELECT r.id FROM role r, permission p, role_permission_xref rpx WHERE
-				p.id = rpx.permission_id AND r.id = rpx.role_id AND p.value = $2)
+				p.id = rpx.permission_id AND r.id = rpx.role_id AND permission_matches(p.value, $2))
 `
 	var count int
 	row := d.Db.QueryRow(sqlStatement, userID, permission)
@@ -393,18 +564,86 @@ func (d *dao) DoesUserHaveSystemPermission(userID int64, permission string) bool
 	return count > 0
 }
 
+// DoesAnyActiveUserHaveSystemPermission returns true if an active user holds the permission through an unconditional
+// role outside of any organization.
+func (d *dao) DoesAnyActiveUserHaveSystemPermission(permission string) bool {
+	sqlStatement := `
+		SELECT
+			count(1)
+		FROM
+			organization_user u CROSS JOIN LATERAL organization_user_roles(u.id) x, role_permission_xref rpx, permission p
+		WHERE
+			u.current_state = $1 AND x.organization_id IS NULL AND x.condition IS NULL AND
+			rpx.role_id = x.role_id AND p.id = rpx.permission_id AND permission_matches(p.value, $2)
+`
+	var count int
+	if err := d.Db.QueryRow(sqlStatement, UserActiveState, permission).Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
+
+// LoadEffectivePermissions returns the permissions the user has in each organization of the subtree rooted at
+// organizationID. Like DoesUserHavePermission a role assigned in an organization applies to all of its descendants
+// unless the assignment says otherwise,
+// whether it is assigned to the user or to one of its groups, unless the permission is denied there. Conditional
+// assignments are left out as there is no request to evaluate them against. Permission patterns of roles are
+// expanded to the permissions they match.
+func (d *dao) LoadEffectivePermissions(userID, organizationID int64) map[int64][]string {
+	sqlStatement := `
+		SELECT
+				o.id, p.value
+		FROM
+				organization o, organization a, organization_user_roles($1) x, role_permission_xref rpx, permission rp, permission p
+		WHERE
+				o.path <@ (SELECT path FROM organization WHERE id=$2) AND
+				x.organization_id = a.id AND x.condition IS NULL AND
+				role_assignment_reaches(x.inheritance, a.path, o.path) AND
+				rpx.role_id = x.role_id AND rp.id = rpx.permission_id AND
+				strpos(p.value, '*') = 0 AND permission_matches(rp.value, p.value) AND
+				NOT EXISTS (SELECT 1 FROM organization_user_denies($1) d, organization da WHERE
+					d.permission = p.value AND da.id = d.organization_id AND da.path @> o.path)
+		GROUP BY
+				o.id, p.value
+		ORDER BY
+				o.id, p.value
+`
+	rows, err := d.Db.Query(sqlStatement, userID, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make(map[int64][]string)
+	for rows.Next() {
+		var orgID int64
+		var permission string
+		if err := rows.Scan(&orgID, &permission); err != nil {
+			log.Fatal(err)
+		}
+		ret[orgID] = append(ret[orgID], permission)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
 func (d *dao) DoesUserHavePermission(userID, organizationID int64, permission string) bool {
 	// Test if any of the orgs between the root of the user and the org they are acting on (including
-	// themselves contain the necessary role w/ permission.
+	// themselves contain the necessary role w/ permission, assigned to the user or to a group it is a member of,
+	// unless the assignment doesn't reach down to the org. A deny of the permission in any of those orgs overrides them.
+	// Conditional assignments don't count here, see LoadPermissionGrantConditions.
 	sqlStatement := `
 		SELECT
 				count(1)
 		FROM
-				organization_organization_user_role_xref 
+				organization_user_roles($1) x, organization a
 		WHERE 
-				(organization_id IN (SELECT id FROM organization WHERE path @> (SELECT path FROM organization WHERE id=$2)) AND
-				role_id IN (SELECT r.id FROM role r, permission p, role_permission_xref rpx WHERE p.id = rpx.permission_id AND r.id = rpx.role_id AND p.value = $3)) AND
-				organization_user_id = $1
+				(a.id = x.organization_id AND x.condition IS NULL AND role_assignment_reaches(x.inheritance, a.path, (SELECT path FROM organization WHERE id=$2)) AND
+				role_id IN (SELECT r.id FROM role r, permission p, role_permission_xref rpx WHERE p.id = rpx.permission_id AND r.id = rpx.role_id AND permission_matches(p.value, $3))) AND
+				NOT EXISTS (SELECT 1 FROM organization_user_denies($1) d, organization o WHERE
+					d.permission = $3 AND o.id = d.organization_id AND o.path @> (SELECT path FROM organization WHERE id=$2))
 `
 	var count int
 	row := d.Db.QueryRow(sqlStatement, userID, organizationID, permission)
@@ -443,17 +682,16 @@ func (d *dao) AssignOrganizationToParent(parentID int64, orgID int64) bool {
 	return true
 }
 
+// CanUserViewOrg returns true if the organization is one the user is a member of or a descendant of one, or one its
+// roles reach, directly or through a group, see organization_user_visible_organizations.
 func (d *dao) CanUserViewOrg(userID, organizationID int64) bool {
-	sqlStatement := ` 
+	sqlStatement := `
 	SELECT
 		count(1)
 	FROM
-		organization
-	WHERE TRUE
-		AND path <@ (SELECT path FROM organization WHERE id IN (SELECT organization_id FROM organization_organization_user_xref WHERE organization_user_id=$1))
-		AND id=$2
-	GROUP BY
-		path
+		organization_user_visible_organizations($1)
+	WHERE
+		organization_id = $2
 `
 	var count int
 	row := d.Db.QueryRow(sqlStatement, userID, organizationID)
@@ -588,7 +826,7 @@ func (d *dao) LoadOrganizationsForUser(userID int64) map[int64]*Organization {
 	FROM 
 		organization 
 	WHERE 
-		path <@ (SELECT path FROM organization WHERE id IN (SELECT organization_id FROM organization_organization_user_xref WHERE organization_user_id = $1))
+		id IN (SELECT organization_id FROM organization_user_visible_organizations($1))
 	ORDER BY 
 		path
 	`
@@ -612,11 +850,11 @@ func (d *dao) LoadOrganizationsForUser(userID int64) map[int64]*Organization {
 	return userOrgs
 }
 
-func (d *dao) LogUserIn(idpAuthCredential string) (*OrganizationUser, error) {
-	sqlStatement := `SELECT id, display_name, ARRAY(SELECT organization_id FROM organization_organization_user_xref WHERE organization_user_id = id) AS organizations FROM organization_user WHERE idp_type = 'AUTH0' AND idp_credential_value=$1 AND current_state=1`
+func (d *dao) LogUserIn(idpIssuer, idpAuthCredential string) (*OrganizationUser, error) {
+	sqlStatement := `SELECT id, display_name, ARRAY(SELECT organization_id FROM organization_organization_user_xref WHERE organization_user_id = id) AS organizations FROM organization_user WHERE id = (SELECT organization_user_id FROM organization_user_identity WHERE idp_issuer = $1 AND idp_credential_value = $2) AND current_state=1`
 	var orgUser OrganizationUser
 
-	row := d.Db.QueryRow(sqlStatement, idpAuthCredential)
+	row := d.Db.QueryRow(sqlStatement, idpIssuer, idpAuthCredential)
 	err := row.Scan(&orgUser.ID, &orgUser.DisplayName, pq.Array(&orgUser.Organizations))
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
@@ -628,12 +866,12 @@ func (d *dao) LogUserIn(idpAuthCredential string) (*OrganizationUser, error) {
 	return &orgUser, nil
 }
 
-func (d *dao) LoadUserFromCredential(credential string, state int) *OrganizationUser {
-	sqlStatement := `SELECT id, display_name, ARRAY(SELECT organization_id FROM organization_organization_user_xref WHERE organization_user_id = id), current_state FROM organization_user WHERE idp_credential_value=$1 AND current_state=$2`
+func (d *dao) LoadUserFromCredential(idpIssuer, credential string, state int) *OrganizationUser {
+	sqlStatement := `SELECT id, display_name, user_type, ARRAY(SELECT organization_id FROM organization_organization_user_xref WHERE organization_user_id = id), current_state FROM organization_user WHERE id = (SELECT organization_user_id FROM organization_user_identity WHERE idp_issuer = $1 AND idp_credential_value = $2) AND current_state=$3`
 	var orgUser OrganizationUser
 
-	row := d.Db.QueryRow(sqlStatement, credential, state)
-	err := row.Scan(&orgUser.ID, &orgUser.DisplayName, pq.Array(&orgUser.Organizations), &orgUser.CurrentState)
+	row := d.Db.QueryRow(sqlStatement, idpIssuer, credential, state)
+	err := row.Scan(&orgUser.ID, &orgUser.DisplayName, &orgUser.UserType, pq.Array(&orgUser.Organizations), &orgUser.CurrentState)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil
 	}
@@ -697,25 +935,51 @@ func (d *dao) CreateOrganization(org *Organization) {
 	}
 }
 
-func (d *dao) InitUserFromInviteCode(inviteCode, idpAuthCredential string) bool {
+// InitUserFromInviteCode activates the invited user and links the identity it logged in with. It returns
+// ErrInviteCodeInvalid if the invite was already used and ErrIdentityAlreadyLinked if the identity belongs to
+// another user.
+func (d *dao) InitUserFromInviteCode(inviteCode, idpType, idpIssuer, idpAuthCredential string) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
 	sqlStatement := `
 	UPDATE 
 		organization_user 
     SET
-		idp_type = 'AUTH0',
-	    idp_credential_value = $1,
 	    current_state = 1 
 	WHERE
-		invite_code = $2 AND current_state=0
+		invite_code = $1 AND current_state=0
+	RETURNING
+		id
 	`
-	_, err := d.Db.Exec(sqlStatement, idpAuthCredential, inviteCode)
+	var userID int64
+	err = tx.QueryRow(sqlStatement, inviteCode).Scan(&userID)
 	if errors.Is(err, sql.ErrNoRows) {
-		return false
+		return ErrInviteCodeInvalid
 	}
 	if err != nil {
 		log.Fatalf("error loading user from invite code %s: %v", inviteCode, err)
 	}
-	return true
+
+	identity := &UserIdentity{
+		ID:                 utils.GetNextUniqueId(),
+		OrganizationUserID: userID,
+		IdpType:            idpType,
+		IdpIssuer:          idpIssuer,
+		IdpCredentialValue: idpAuthCredential,
+		CreatedTimestamp:   time.Now().UTC(),
+	}
+	if err := insertUserIdentity(tx, identity); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
 }
 
 func (d *dao) LoadEnabledResources() RegisteredResourcesStore {
@@ -0,0 +1,204 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrOperationNotPending is returned when an operation that was already decided is decided again.
+var ErrOperationNotPending = errors.New("operation is no longer pending")
+
+// SetDualControlPolicies replaces the permissions under dual control in an organization and its descendants.
+func (d *dao) SetDualControlPolicies(organizationID int64, permissions []string) {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM dual_control_policy WHERE organization_id = $1`, organizationID); err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range permissions {
+		sqlStatement := `
+		INSERT INTO
+			dual_control_policy
+		(organization_id, permission, created_timestamp)
+		VALUES
+		($1, $2, $3)
+		ON CONFLICT DO NOTHING
+`
+		if _, err := tx.Exec(sqlStatement, organizationID, p, time.Now().UTC()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LoadDualControlPolicies returns the permissions under dual control in an organization keyed by the organization,
+// itself or one of its ancestors, whose policy puts them there.
+func (d *dao) LoadDualControlPolicies(organizationID int64) map[int64][]string {
+	sqlStatement := `
+		SELECT
+			p.organization_id, p.permission
+		FROM
+			dual_control_policy p, organization o
+		WHERE
+			o.id = p.organization_id AND o.path @> (SELECT path FROM organization WHERE id = $1)
+		ORDER BY
+			o.path, p.permission
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make(map[int64][]string)
+	for rows.Next() {
+		var orgID int64
+		var permission string
+		if err := rows.Scan(&orgID, &permission); err != nil {
+			log.Fatal(err)
+		}
+		ret[orgID] = append(ret[orgID], permission)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// RequiresDualControl returns true if a policy on the organization or one of its ancestors covers permission.
+func (d *dao) RequiresDualControl(organizationID int64, permission string) bool {
+	sqlStatement := `
+		SELECT
+			count(1)
+		FROM
+			dual_control_policy
+		WHERE
+			permission = $2 AND
+			organization_id IN (SELECT id FROM organization WHERE path @> (SELECT path FROM organization WHERE id = $1))
+`
+	var count int
+	if err := d.Db.QueryRow(sqlStatement, organizationID, permission).Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
+
+const pendingOperationColumns = `
+	id, organization_user_id, organization_id, permission, method, request_uri, content_type, body, scope,
+	current_state, approver_id, decision_reason, requested_timestamp, decided_timestamp, result_status, result_body
+`
+
+func scanPendingOperations(rows *sql.Rows) []*PendingOperation {
+	defer rows.Close()
+
+	ret := make([]*PendingOperation, 0)
+	for rows.Next() {
+		operation := &PendingOperation{}
+		var approverID sql.NullInt64
+		var resultStatus sql.NullInt32
+		var decisionReason, resultBody sql.NullString
+		var decided sql.NullTime
+		err := rows.Scan(&operation.ID, &operation.OrganizationUserID, &operation.OrganizationID, &operation.Permission, &operation.Method,
+			&operation.RequestURI, &operation.ContentType, &operation.Body, &operation.Scope, &operation.CurrentState, &approverID,
+			&decisionReason, &operation.RequestedTimestamp, &decided, &resultStatus, &resultBody)
+		if err != nil {
+			log.Fatal(err)
+		}
+		operation.ApproverID, operation.DecisionReason, operation.DecidedTimestamp = approverID.Int64, decisionReason.String, decided.Time
+		operation.ResultStatus, operation.ResultBody = int(resultStatus.Int32), resultBody.String
+		ret = append(ret, operation)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+func (d *dao) CreatePendingOperation(operation *PendingOperation) {
+	sqlStatement := `
+		INSERT INTO
+			pending_operation
+		(id, organization_user_id, organization_id, permission, method, request_uri, content_type, body, scope, current_state, requested_timestamp)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+`
+	_, err := d.Db.Exec(sqlStatement, operation.ID, operation.OrganizationUserID, operation.OrganizationID, operation.Permission, operation.Method,
+		operation.RequestURI, operation.ContentType, operation.Body, operation.Scope, OperationPendingState, operation.RequestedTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	operation.CurrentState = OperationPendingState
+}
+
+func (d *dao) LoadPendingOperation(id int64) *PendingOperation {
+	rows, err := d.Db.Query(`SELECT `+pendingOperationColumns+` FROM pending_operation WHERE id = $1`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	operations := scanPendingOperations(rows)
+	if len(operations) == 0 {
+		return nil
+	}
+	return operations[0]
+}
+
+// LoadPendingOperationsInTree returns the operations in an organization and its descendants, newest first.
+func (d *dao) LoadPendingOperationsInTree(organizationID int64) []*PendingOperation {
+	sqlStatement := `
+		SELECT ` + pendingOperationColumns + `
+		FROM
+			pending_operation
+		WHERE
+			organization_id IN (SELECT id FROM organization WHERE path <@ (SELECT path FROM organization WHERE id = $1))
+		ORDER BY
+			requested_timestamp DESC
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return scanPendingOperations(rows)
+}
+
+// DecidePendingOperation records the decision on a pending operation, it returns ErrOperationNotPending if the
+// operation was already decided.
+func (d *dao) DecidePendingOperation(operation *PendingOperation) error {
+	sqlStatement := `
+		UPDATE
+			pending_operation
+		SET
+			current_state = $2, approver_id = NULLIF($3::bigint, 0), decision_reason = $4, decided_timestamp = $5
+		WHERE
+			id = $1 AND current_state = $6
+`
+	result, err := d.Db.Exec(sqlStatement, operation.ID, operation.CurrentState, operation.ApproverID, operation.DecisionReason,
+		operation.DecidedTimestamp, OperationPendingState)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n == 0 {
+		return ErrOperationNotPending
+	}
+	return nil
+}
+
+// RecordPendingOperationResult keeps the response the operation got when it was executed.
+func (d *dao) RecordPendingOperationResult(id int64, status int, body string) {
+	_, err := d.Db.Exec(`UPDATE pending_operation SET result_status = $2, result_body = $3 WHERE id = $1`, id, status, body)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,187 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrElevationNotPending is returned when a request that was already decided is decided again.
+var ErrElevationNotPending = errors.New("elevation request is no longer pending")
+
+const elevationRequestColumns = `
+	e.id, e.organization_user_id, e.organization_id, e.role_id, COALESCE(r.display_name, ''), e.duration_seconds,
+	e.justification, e.current_state, e.approver_id, e.decision_reason, e.requested_timestamp, e.decided_timestamp,
+	e.expiration_timestamp
+`
+
+func scanElevationRequests(rows *sql.Rows) []*ElevationRequest {
+	defer rows.Close()
+
+	ret := make([]*ElevationRequest, 0)
+	for rows.Next() {
+		request := &ElevationRequest{}
+		var durationSeconds int64
+		var approverID sql.NullInt64
+		var decisionReason sql.NullString
+		var decided, expiration sql.NullTime
+		err := rows.Scan(&request.ID, &request.OrganizationUserID, &request.OrganizationID, &request.RoleID, &request.RoleName, &durationSeconds,
+			&request.Justification, &request.CurrentState, &approverID, &decisionReason, &request.RequestedTimestamp, &decided, &expiration)
+		if err != nil {
+			log.Fatal(err)
+		}
+		request.Duration = time.Duration(durationSeconds) * time.Second
+		request.ApproverID, request.DecisionReason = approverID.Int64, decisionReason.String
+		request.DecidedTimestamp, request.ExpirationTimestamp = decided.Time, expiration.Time
+		ret = append(ret, request)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+func (d *dao) CreateElevationRequest(request *ElevationRequest) {
+	sqlStatement := `
+		INSERT INTO
+			elevation_request
+		(id, organization_user_id, organization_id, role_id, duration_seconds, justification, current_state, requested_timestamp)
+		VALUES
+		($1, $2, $3, (SELECT id FROM role WHERE display_name = $4), $5, $6, $7, $8)
+		RETURNING
+			role_id
+`
+	err := d.Db.QueryRow(sqlStatement, request.ID, request.OrganizationUserID, request.OrganizationID, request.RoleName,
+		int64(request.Duration/time.Second), request.Justification, ElevationPendingState, request.RequestedTimestamp).Scan(&request.RoleID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	request.CurrentState = ElevationPendingState
+}
+
+func (d *dao) LoadElevationRequest(id int64) *ElevationRequest {
+	sqlStatement := `SELECT ` + elevationRequestColumns + ` FROM elevation_request e LEFT JOIN role r ON r.id = e.role_id WHERE e.id = $1`
+	rows, err := d.Db.Query(sqlStatement, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	requests := scanElevationRequests(rows)
+	if len(requests) == 0 {
+		return nil
+	}
+	return requests[0]
+}
+
+// LoadElevationRequestsForUser returns the requests a user made, newest first.
+func (d *dao) LoadElevationRequestsForUser(userID int64) []*ElevationRequest {
+	sqlStatement := `
+		SELECT ` + elevationRequestColumns + `
+		FROM
+			elevation_request e LEFT JOIN role r ON r.id = e.role_id
+		WHERE
+			e.organization_user_id = $1
+		ORDER BY
+			e.requested_timestamp DESC
+`
+	rows, err := d.Db.Query(sqlStatement, userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return scanElevationRequests(rows)
+}
+
+// LoadElevationRequestsInTree returns the requests for roles in organizationID and its descendants, newest first.
+func (d *dao) LoadElevationRequestsInTree(organizationID int64) []*ElevationRequest {
+	sqlStatement := `
+		SELECT ` + elevationRequestColumns + `
+		FROM
+			elevation_request e LEFT JOIN role r ON r.id = e.role_id, organization o
+		WHERE
+			o.id = e.organization_id AND o.path <@ (SELECT path FROM organization WHERE id = $1)
+		ORDER BY
+			e.requested_timestamp DESC
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return scanElevationRequests(rows)
+}
+
+// DecideElevationRequest records the decision of a pending request, an approved request has its role assigned
+// from DecidedTimestamp until ExpirationTimestamp. It returns ErrElevationNotPending if it was already decided, and
+// an error wrapping ErrSeparationOfDuty, recording nothing, if the role can't be held with the user's other roles.
+func (d *dao) DecideElevationRequest(request *ElevationRequest) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sqlStatement := `
+		UPDATE
+			elevation_request
+		SET
+			current_state = $2, approver_id = $3, decision_reason = $4, decided_timestamp = $5, expiration_timestamp = $6
+		WHERE
+			id = $1 AND current_state = $7
+`
+	result, err := tx.Exec(sqlStatement, request.ID, request.CurrentState, request.ApproverID, request.DecisionReason,
+		request.DecidedTimestamp, nullTime(request.ExpirationTimestamp), ElevationPendingState)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		log.Fatal(err)
+	} else if n == 0 {
+		return ErrElevationNotPending
+	}
+
+	if request.CurrentState == ElevationApprovedState {
+		sqlGrantStatement := `
+			INSERT INTO
+				organization_organization_user_role_xref
+			(organization_id, organization_user_id, role_id, valid_from, valid_until, elevation_request_id)
+			VALUES
+			($1, $2, $3, $4, $5, $6)
+`
+		_, err := tx.Exec(sqlGrantStatement, request.OrganizationID, request.OrganizationUserID, request.RoleID, request.DecidedTimestamp, request.ExpirationTimestamp, request.ID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := checkStaticSeparationOfDuty(tx, request.OrganizationUserID, []int64{request.OrganizationID}); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// ExpireElevationRequests marks approved requests whose role expired before now, and pending requests made
+// before requestedBefore, as expired and returns them. The role assignment itself ends at its own expiration.
+func (d *dao) ExpireElevationRequests(now, requestedBefore time.Time) []*ElevationRequest {
+	sqlStatement := `
+		WITH e AS (
+			UPDATE
+				elevation_request
+			SET
+				current_state = $3
+			WHERE
+				(current_state = $4 AND expiration_timestamp <= $1) OR (current_state = $5 AND requested_timestamp < $2)
+			RETURNING
+				*
+		)
+		SELECT ` + elevationRequestColumns + `
+		FROM
+			e LEFT JOIN role r ON r.id = e.role_id
+`
+	rows, err := d.Db.Query(sqlStatement, now.UTC(), requestedBefore.UTC(), ElevationExpiredState, ElevationApprovedState, ElevationPendingState)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return scanElevationRequests(rows)
+}
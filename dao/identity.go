@@ -0,0 +1,147 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// ServiceIdpType is the identity type of service principals, they have no identity provider.
+const ServiceIdpType = "SERVICE"
+
+// Errors returned when identities are linked and unlinked.
+var (
+	ErrInviteCodeInvalid     = errors.New("invite code is invalid or was already used")
+	ErrIdentityAlreadyLinked = errors.New("identity is already linked to a user")
+	ErrIdentityNotFound      = errors.New("identity not found")
+	ErrLastIdentity          = errors.New("cannot unlink the only identity of a user")
+)
+
+// uniqueViolation is the postgres error code for a duplicate key.
+const uniqueViolation = "23505"
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertUserIdentity(db execer, identity *UserIdentity) error {
+	sqlStatement := `
+		INSERT INTO
+			organization_user_identity
+		(id, organization_user_id, idp_type, idp_issuer, idp_credential_value, created_timestamp)
+		VALUES
+		($1, $2, $3, $4, $5, $6)
+`
+	_, err := db.Exec(sqlStatement, identity.ID, identity.OrganizationUserID, identity.IdpType, identity.IdpIssuer, identity.IdpCredentialValue, identity.CreatedTimestamp)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return ErrIdentityAlreadyLinked
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// LinkUserIdentity adds an identity to a user, it returns ErrIdentityAlreadyLinked if any user already has it.
+func (d *dao) LinkUserIdentity(identity *UserIdentity) error {
+	return insertUserIdentity(d.Db, identity)
+}
+
+func (d *dao) LoadUserIdentities(userID int64) []*UserIdentity {
+	sqlStatement := `
+		SELECT
+			id, organization_user_id, idp_type, idp_issuer, idp_credential_value, created_timestamp
+		FROM
+			organization_user_identity
+		WHERE
+			organization_user_id = $1
+		ORDER BY
+			created_timestamp
+`
+	rows, err := d.Db.Query(sqlStatement, userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*UserIdentity, 0)
+	for rows.Next() {
+		identity := &UserIdentity{}
+		var idpType, idpIssuer sql.NullString
+		var created sql.NullTime
+		if err := rows.Scan(&identity.ID, &identity.OrganizationUserID, &idpType, &idpIssuer, &identity.IdpCredentialValue, &created); err != nil {
+			log.Fatal(err)
+		}
+		identity.IdpType, identity.IdpIssuer, identity.CreatedTimestamp = idpType.String, idpIssuer.String, created.Time
+		ret = append(ret, identity)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// UnlinkUserIdentity removes an identity from a user as long as the user is left with another one to log in with.
+func (d *dao) UnlinkUserIdentity(userID, identityID int64) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user's identities so two concurrent unlinks can't remove the last two.
+	var ids []int64
+	rows, err := tx.Query(`SELECT id FROM organization_user_identity WHERE organization_user_id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			log.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	rows.Close()
+
+	found := false
+	for _, id := range ids {
+		found = found || id == identityID
+	}
+	if !found {
+		return ErrIdentityNotFound
+	}
+	if len(ids) == 1 {
+		return ErrLastIdentity
+	}
+
+	_, err = tx.Exec(`DELETE FROM organization_user_identity WHERE id = $1 AND organization_user_id = $2`, identityID, userID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// AssignLegacyIdentityIssuer sets the issuer of the identities of idpType linked before issuers were recorded and
+// returns how many there were.
+func (d *dao) AssignLegacyIdentityIssuer(idpType, idpIssuer string) int64 {
+	sqlStatement := `UPDATE organization_user_identity SET idp_issuer = $2 WHERE idp_issuer IS NULL AND idp_type = $1`
+	result, err := d.Db.Exec(sqlStatement, idpType, idpIssuer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package dao
+
+import (
+	"fmt"
+	"log"
+)
+
+// ApplyImportPlan makes the changes of the plan at once. It returns an error wrapping ErrIdentityAlreadyLinked or
+// ErrSeparationOfDuty, and changes nothing, if one of the users can't be changed. Without commit it only returns
+// the error.
+func (d *dao) ApplyImportPlan(plan *ImportPlan, commit bool) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, o := range plan.Organizations {
+		if o.Create {
+			sqlStatement := `
+		INSERT INTO
+			organization
+		(id, display_name, metadata, path)
+		VALUES
+			($1, $2, '{}', CASE WHEN $3::bigint = 0 THEN text2ltree($1::text) ELSE (SELECT path FROM organization WHERE id = $3) || $1::text END)
+`
+			if _, err := tx.Exec(sqlStatement, o.ID, o.DisplayName, o.ParentID); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if o.Metadata != nil {
+			if _, err := tx.Exec(`UPDATE organization SET metadata = $2 WHERE id = $1`, o.ID, o.Metadata); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	for _, u := range plan.Users {
+		if u.Create {
+			state := UserCreatedState
+			if u.Identity != nil {
+				state = UserActiveState
+			}
+			sqlStatement := `
+		INSERT INTO
+			organization_user
+		(id, display_name, user_type, user_name, invite_code, created_timestamp, current_state)
+		VALUES
+			($1, $2, $3, $4, NULLIF($5::bigint, 0), NOW(), $6)
+`
+			if _, err := tx.Exec(sqlStatement, u.ID, u.DisplayName, HumanUserType, u.UserName, u.InviteCode, state); err != nil {
+				log.Fatal(err)
+			}
+			sqlRefStatement := `
+		INSERT INTO organization_organization_user_xref (organization_id, organization_user_id) VALUES ($1, $2)
+`
+			if _, err := tx.Exec(sqlRefStatement, u.OrganizationID, u.ID); err != nil {
+				log.Fatal(err)
+			}
+			if u.Identity != nil {
+				u.Identity.OrganizationUserID = u.ID
+				if err := insertUserIdentity(tx, u.Identity); err != nil {
+					return fmt.Errorf("user %s: %w", u.UserName, err)
+				}
+			}
+		}
+		if len(u.Assignments) > 0 {
+			organizationIDs := replaceRoleAssignments(tx, u.ID, u.Assignments)
+			if err := checkStaticSeparationOfDuty(tx, u.ID, organizationIDs); err != nil {
+				return fmt.Errorf("user %s: %w", u.UserName, err)
+			}
+		}
+	}
+
+	if !commit {
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// LoadChildOrganizations returns the organizations directly below organizationID, or the roots of the hierarchy
+// when it is zero.
+func (d *dao) LoadChildOrganizations(organizationID int64) []*Organization {
+	sqlStatement := `
+		SELECT
+			id, display_name, path::text
+		FROM
+			organization
+		WHERE
+			($1::bigint = 0 AND nlevel(path) = 1) OR
+			(path <@ (SELECT path FROM organization WHERE id = $1) AND nlevel(path) = (SELECT nlevel(path) + 1 FROM organization WHERE id = $1))
+		ORDER BY
+			display_name
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*Organization, 0)
+	for rows.Next() {
+		o := &Organization{}
+		if err := rows.Scan(&o.ID, &o.DisplayName, &o.Path); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, o)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+func (d *dao) CreateLoginState(loginState *LoginState) {
+	sqlStatement := `
+		INSERT INTO
+			login_state
+		(state, provider_name, code_verifier, nonce, invite_code, link_user_id, created_timestamp)
+		VALUES
+		($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0), $7)
+`
+	_, err := d.Db.Exec(sqlStatement, loginState.State, loginState.ProviderName, loginState.CodeVerifier, loginState.Nonce, loginState.InviteCode, loginState.LinkUserID, loginState.CreatedTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ConsumeLoginState removes the login state so it can only ever be used once.
+func (d *dao) ConsumeLoginState(state string, createdAfter time.Time) *LoginState {
+	sqlStatement := `
+		DELETE FROM
+			login_state
+		WHERE
+			state = $1
+		RETURNING
+			state, provider_name, code_verifier, nonce, COALESCE(invite_code, ''), COALESCE(link_user_id, 0), created_timestamp
+`
+	ret := &LoginState{}
+	row := d.Db.QueryRow(sqlStatement, state)
+	err := row.Scan(&ret.State, &ret.ProviderName, &ret.CodeVerifier, &ret.Nonce, &ret.InviteCode, &ret.LinkUserID, &ret.CreatedTimestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if ret.CreatedTimestamp.Before(createdAfter) {
+		return nil
+	}
+	return ret
+}
+
+func (d *dao) PurgeLoginStates(createdBefore time.Time) {
+	sqlStatement := `
+		DELETE FROM
+			login_state
+		WHERE
+			created_timestamp < $1
+`
+	_, err := d.Db.Exec(sqlStatement, createdBefore)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package dao
 import (
 	"encoding/base64"
 	"log"
+	"time"
 )
 
 // RegisteredResource is the entity which determines which resources the app is protecting
@@ -26,6 +27,9 @@ type Organization struct {
 type OrganizationUser struct {
 	ID            int64
 	DisplayName   string
+	UserType      int
+	UserName      string // set for users provisioned over SCIM
+	ExternalID    string // set for users provisioned over SCIM
 	Organizations []int64
 	CurrentState  int
 	UserRoles     UserRoleStore
@@ -41,6 +45,15 @@ type UserRole struct {
 type Role struct {
 	ID          int64
 	DisplayName string
+	// ValidFrom and ValidUntil bound a time bound assignment of the role, they are zero when it is unbounded.
+	ValidFrom  time.Time
+	ValidUntil time.Time
+	// Inheritance is one of the RoleInheritance values.
+	Inheritance int
+	// Condition must hold for the assignment to apply, it is empty for unconditional assignments.
+	Condition string
+	// ElevationRequestID is the approved elevation request that granted the role, if any.
+	ElevationRequestID int64
 }
 
 // Setting contains just a key value mapping of settings for the app
@@ -62,3 +75,248 @@ func (s *Setting) Base64DecodeValue() []byte {
 	}
 	return ret
 }
+
+// LoginState is the server side half of a browser login that is in progress.
+type LoginState struct {
+	State            string
+	ProviderName     string
+	CodeVerifier     string
+	Nonce            string
+	InviteCode       string
+	LinkUserID       int64 // the logged in user the identity is linked to instead of logging in with it
+	CreatedTimestamp time.Time
+}
+
+// APIKey is a credential a service principal uses to call the api. Only the hash of the secret is stored.
+type APIKey struct {
+	ID                  int64
+	OrganizationUserID  int64
+	KeyHash             string `json:"-"`
+	Scopes              []string
+	CurrentState        int
+	CreatedTimestamp    time.Time
+	ExpirationTimestamp time.Time
+	LastUsedTimestamp   time.Time
+}
+
+// SigningKey is an RSA key the service signs its own access tokens with, the private key is PEM encoded.
+type SigningKey struct {
+	ID               string
+	PrivateKey       string `json:"-"` // TODO: Encrypt in database
+	CurrentState     int
+	CreatedTimestamp time.Time
+	RetiredTimestamp time.Time
+}
+
+// UserSession is a browser login. The cookie holds a random session id and only its hash is stored as ID.
+type UserSession struct {
+	ID                  string
+	OrganizationUserID  int64
+	ProviderName        string
+	IDToken             string `json:"-"`
+	CreatedTimestamp    time.Time
+	ExpirationTimestamp time.Time
+	RevokedTimestamp    time.Time
+}
+
+// UserIdentity is an account at an identity provider a user can log in with, a user can have several.
+type UserIdentity struct {
+	ID                 int64
+	OrganizationUserID int64
+	IdpType            string
+	IdpIssuer          string
+	IdpCredentialValue string
+	CreatedTimestamp   time.Time
+}
+
+// UserGroup is a set of users, and of groups nested in it, that roles are assigned to together. Members of a
+// group hold the roles assigned to it and to every group it is nested in.
+type UserGroup struct {
+	ID               int64
+	OrganizationID   int64
+	DisplayName      string
+	MemberUserIDs    []int64
+	MemberGroupIDs   []int64
+	Roles            UserRoleStore
+	CreatedTimestamp time.Time
+}
+
+// ExpiredRoleAssignment is a time bound role assignment that was removed after it expired.
+type ExpiredRoleAssignment struct {
+	OrganizationID     int64
+	OrganizationUserID int64
+	RoleID             int64
+	RoleName           string
+	ValidUntil         time.Time
+}
+
+// ElevationRequest asks for a role in an organization for a limited time. Once approved the role is assigned
+// until ExpirationTimestamp.
+type ElevationRequest struct {
+	ID                  int64
+	OrganizationUserID  int64
+	OrganizationID      int64
+	RoleID              int64
+	RoleName            string
+	Duration            time.Duration
+	Justification       string
+	CurrentState        int
+	ApproverID          int64
+	DecisionReason      string
+	RequestedTimestamp  time.Time
+	DecidedTimestamp    time.Time
+	ExpirationTimestamp time.Time
+}
+
+// PendingOperation is a request that needs a permission under dual control. It is captured when it is made and
+// executed as the requester once a second user approves it.
+type PendingOperation struct {
+	ID                 int64
+	OrganizationUserID int64
+	OrganizationID     int64
+	Permission         string
+	Method             string
+	RequestURI         string
+	ContentType        string
+	Body               []byte
+	Scope              string
+	CurrentState       int
+	ApproverID         int64
+	DecisionReason     string
+	RequestedTimestamp time.Time
+	DecidedTimestamp   time.Time
+	ResultStatus       int
+	ResultBody         string
+}
+
+// BreakGlassAccess is an emergency grant of System Admin a designated user gave itself. It stays on record until
+// a second user reviews it after the incident.
+type BreakGlassAccess struct {
+	ID                  int64
+	OrganizationUserID  int64
+	Reason              string
+	ActivatedTimestamp  time.Time
+	ExpirationTimestamp time.Time
+	ReviewerID          int64
+	ReviewNotes         string
+	ReviewedTimestamp   time.Time
+}
+
+// RoleAssignment replaces the roles a user holds in an organization, a zero ValidFrom or ValidUntil leaves that
+// side of the window open, Inheritance is one of the RoleInheritance values and an empty Condition always holds.
+type RoleAssignment struct {
+	OrganizationID int64
+	RoleNames      []string
+	ValidFrom      time.Time
+	ValidUntil     time.Time
+	Inheritance    int
+	Condition      string
+}
+
+// SeparationOfDuty makes two roles mutually exclusive for the same user in an organization and its descendants.
+type SeparationOfDuty struct {
+	ID                  int64
+	OrganizationID      int64
+	RoleName            string
+	ConflictingRoleName string
+	Dynamic             bool
+	CreatedTimestamp    time.Time
+}
+
+// SeparationOfDutyViolation is a user that holds both roles of a separation of duty constraint.
+type SeparationOfDutyViolation struct {
+	SeparationOfDutyID        int64
+	Dynamic                   bool
+	OrganizationUserID        int64
+	OrganizationID            int64
+	RoleName                  string
+	ConflictingOrganizationID int64
+	ConflictingRoleName       string
+}
+
+// PermissionDeny takes a permission away from a user, or the members of a group, in an organization and its
+// descendants whatever roles they hold. Exactly one of OrganizationUserID and UserGroupID is set.
+type PermissionDeny struct {
+	ID                 int64
+	OrganizationID     int64
+	OrganizationUserID int64
+	UserGroupID        int64
+	Permission         string
+	CreatedTimestamp   time.Time
+}
+
+// PermissionGrant is a role a user holds that grants a permission in an organization.
+type PermissionGrant struct {
+	OrganizationID int64
+	RoleName       string
+	// Permission is the permission of the role that grants it, which can be a pattern matching it.
+	Permission string
+	// Condition must also hold for the grant to apply, it is empty for unconditional grants.
+	Condition string
+}
+
+// PermissionExplanation lists why a user has, or doesn't have, a permission in an organization. It is allowed
+// when there is at least one grant whose condition holds and no deny.
+type PermissionExplanation struct {
+	Grants []*PermissionGrant
+	Denies []*PermissionDeny
+}
+
+// PolicyGrant is a role a user holds, directly or through a group, with the permissions it grants. OrganizationID is
+// zero and OrganizationPath empty for roles outside of any organization.
+type PolicyGrant struct {
+	OrganizationUserID int64
+	OrganizationID     int64
+	OrganizationPath   string
+	RoleName           string
+	Inheritance        int
+	Permissions        []string
+}
+
+// PolicyDeny is a deny that applies to a user, directly or through a group.
+type PolicyDeny struct {
+	ID                 int64
+	OrganizationUserID int64
+	OrganizationID     int64
+	OrganizationPath   string
+	Permission         string
+}
+
+// PolicyExport is a snapshot of the grants and denies of the active users, see LoadPolicyExport. Organizations maps
+// the organizations they can refer to to their paths and RolePermissions the roles to their permissions.
+type PolicyExport struct {
+	Grants          []*PolicyGrant
+	Denies          []*PolicyDeny
+	Organizations   map[int64]string
+	RolePermissions map[string][]string
+}
+
+// ImportOrganization is an organization a manifest import creates under ParentID, zero for a new root, or whose
+// metadata it replaces when Metadata is set.
+type ImportOrganization struct {
+	ID          int64
+	ParentID    int64
+	DisplayName string
+	Metadata    OrganizationMetadata
+	Create      bool
+}
+
+// ImportUser is a user a manifest import creates as a member of OrganizationID, or changes. A created user without
+// an Identity is invited with InviteCode, otherwise Identity is linked to it, and Assignments replace its roles in
+// their organizations. Identities are never linked to users that already exist.
+type ImportUser struct {
+	ID             int64
+	OrganizationID int64
+	DisplayName    string
+	UserName       string
+	Create         bool
+	InviteCode     int64
+	Identity       *UserIdentity
+	Assignments    []*RoleAssignment
+}
+
+// ImportPlan is what a manifest import changes, in the order it does.
+type ImportPlan struct {
+	Organizations []*ImportOrganization
+	Users         []*ImportUser
+}
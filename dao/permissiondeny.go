@@ -0,0 +1,129 @@
+package dao
+
+import (
+	"database/sql"
+	"log"
+)
+
+func (d *dao) CreatePermissionDeny(deny *PermissionDeny) {
+	sqlStatement := `
+		INSERT INTO
+			permission_deny
+		(id, organization_id, organization_user_id, user_group_id, permission, created_timestamp)
+		VALUES
+		($1, $2, NULLIF($3::bigint, 0), NULLIF($4::bigint, 0), $5, $6)
+`
+	_, err := d.Db.Exec(sqlStatement, deny.ID, deny.OrganizationID, deny.OrganizationUserID, deny.UserGroupID, deny.Permission, deny.CreatedTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+const permissionDenyColumns = `
+	d.id, d.organization_id, d.organization_user_id, d.user_group_id, d.permission, d.created_timestamp
+`
+
+func scanPermissionDenies(rows *sql.Rows) []*PermissionDeny {
+	defer rows.Close()
+
+	ret := make([]*PermissionDeny, 0)
+	for rows.Next() {
+		deny := &PermissionDeny{}
+		var userID, groupID sql.NullInt64
+		if err := rows.Scan(&deny.ID, &deny.OrganizationID, &userID, &groupID, &deny.Permission, &deny.CreatedTimestamp); err != nil {
+			log.Fatal(err)
+		}
+		deny.OrganizationUserID, deny.UserGroupID = userID.Int64, groupID.Int64
+		ret = append(ret, deny)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+func (d *dao) LoadPermissionDeny(id int64) *PermissionDeny {
+	rows, err := d.Db.Query(`SELECT `+permissionDenyColumns+` FROM permission_deny d WHERE d.id = $1`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	denies := scanPermissionDenies(rows)
+	if len(denies) == 0 {
+		return nil
+	}
+	return denies[0]
+}
+
+// LoadPermissionDeniesInTree returns the denies in an organization and its descendants.
+func (d *dao) LoadPermissionDeniesInTree(organizationID int64) []*PermissionDeny {
+	sqlStatement := `
+		SELECT ` + permissionDenyColumns + `
+		FROM
+			permission_deny d, organization o
+		WHERE
+			o.id = d.organization_id AND o.path <@ (SELECT path FROM organization WHERE id = $1)
+		ORDER BY
+			o.path, d.created_timestamp
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return scanPermissionDenies(rows)
+}
+
+func (d *dao) DeletePermissionDeny(id int64) {
+	if _, err := d.Db.Exec(`DELETE FROM permission_deny WHERE id = $1`, id); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ExplainPermission lists the roles granting a user the permission in the organization and the denies taking it
+// away, the same ones DoesUserHavePermission looks at.
+func (d *dao) ExplainPermission(userID, organizationID int64, permission string) *PermissionExplanation {
+	sqlStatement := `
+		SELECT DISTINCT
+			x.organization_id, r.display_name, p.value, COALESCE(x.condition, ''), a.path
+		FROM
+			organization_user_roles($1) x, organization a, role r, role_permission_xref rpx, permission p
+		WHERE
+			a.id = x.organization_id AND role_assignment_reaches(x.inheritance, a.path, (SELECT path FROM organization WHERE id = $2)) AND
+			r.id = x.role_id AND rpx.role_id = r.id AND p.id = rpx.permission_id AND permission_matches(p.value, $3)
+		ORDER BY
+			a.path, r.display_name
+`
+	rows, err := d.Db.Query(sqlStatement, userID, organizationID, permission)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := &PermissionExplanation{Grants: make([]*PermissionGrant, 0)}
+	for rows.Next() {
+		grant := &PermissionGrant{}
+		var path string
+		if err := rows.Scan(&grant.OrganizationID, &grant.RoleName, &grant.Permission, &grant.Condition, &path); err != nil {
+			log.Fatal(err)
+		}
+		ret.Grants = append(ret.Grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	sqlStatement = `
+		SELECT ` + permissionDenyColumns + `
+		FROM
+			organization_user_denies($1) d, organization o
+		WHERE
+			d.permission = $3 AND o.id = d.organization_id AND o.path @> (SELECT path FROM organization WHERE id = $2)
+		ORDER BY
+			o.path
+`
+	rows, err = d.Db.Query(sqlStatement, userID, organizationID, permission)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ret.Denies = scanPermissionDenies(rows)
+	return ret
+}
@@ -0,0 +1,139 @@
+package dao
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// LoadPolicyExport returns the grants and denies of the active users that apply in the subtree rooted at
+// organizationID, or everywhere, including roles outside of any organization, when it is zero. Like
+// LoadEffectivePermissions it leaves out conditional assignments and assignments outside their validity window. The
+// organizations are those of the subtree and its ancestors.
+func (d *dao) LoadPolicyExport(organizationID int64) *PolicyExport {
+	ret := &PolicyExport{
+		Grants:          make([]*PolicyGrant, 0),
+		Denies:          make([]*PolicyDeny, 0),
+		Organizations:   make(map[int64]string),
+		RolePermissions: make(map[string][]string),
+	}
+
+	sqlStatement := `
+		SELECT
+			u.id, x.organization_id, COALESCE(o.path::text, ''), r.display_name, x.inheritance, array_agg(DISTINCT p.value ORDER BY p.value)
+		FROM
+			organization_user u CROSS JOIN LATERAL organization_user_roles(u.id) x LEFT JOIN organization o ON o.id = x.organization_id,
+			role r, role_permission_xref rpx, permission p
+		WHERE
+			u.current_state = $2 AND x.condition IS NULL AND
+			r.id = x.role_id AND rpx.role_id = r.id AND p.id = rpx.permission_id AND
+			($1::bigint = 0 OR o.path <@ (SELECT path FROM organization WHERE id = $1) OR
+				role_assignment_reaches(x.inheritance, o.path, (SELECT path FROM organization WHERE id = $1)))
+		GROUP BY
+			u.id, x.organization_id, o.path, r.display_name, x.inheritance
+		ORDER BY
+			u.id, o.path NULLS FIRST, r.display_name
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID, UserActiveState)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		grant := &PolicyGrant{}
+		var orgID sql.NullInt64
+		if err := rows.Scan(&grant.OrganizationUserID, &orgID, &grant.OrganizationPath, &grant.RoleName, &grant.Inheritance, pq.Array(&grant.Permissions)); err != nil {
+			log.Fatal(err)
+		}
+		grant.OrganizationID = orgID.Int64
+		ret.Grants = append(ret.Grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	sqlStatement = `
+		SELECT DISTINCT
+			d.id, u.id, d.organization_id, o.path::text, d.permission
+		FROM
+			organization_user u CROSS JOIN LATERAL organization_user_denies(u.id) d, organization o
+		WHERE
+			u.current_state = $2 AND o.id = d.organization_id AND
+			($1::bigint = 0 OR o.path <@ (SELECT path FROM organization WHERE id = $1) OR o.path @> (SELECT path FROM organization WHERE id = $1))
+		ORDER BY
+			u.id, d.id
+`
+	rows, err = d.Db.Query(sqlStatement, organizationID, UserActiveState)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		deny := &PolicyDeny{}
+		if err := rows.Scan(&deny.ID, &deny.OrganizationUserID, &deny.OrganizationID, &deny.OrganizationPath, &deny.Permission); err != nil {
+			log.Fatal(err)
+		}
+		ret.Denies = append(ret.Denies, deny)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	sqlStatement = `
+		SELECT
+			o.id, o.path::text
+		FROM
+			organization o
+		WHERE
+			$1::bigint = 0 OR o.path <@ (SELECT path FROM organization WHERE id = $1) OR o.path @> (SELECT path FROM organization WHERE id = $1)
+`
+	rows, err = d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orgID int64
+		var path string
+		if err := rows.Scan(&orgID, &path); err != nil {
+			log.Fatal(err)
+		}
+		ret.Organizations[orgID] = path
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	sqlStatement = `
+		SELECT
+			r.display_name, array_agg(p.value ORDER BY p.value)
+		FROM
+			role r, role_permission_xref rpx, permission p
+		WHERE
+			rpx.role_id = r.id AND p.id = rpx.permission_id
+		GROUP BY
+			r.display_name
+`
+	rows, err = d.Db.Query(sqlStatement)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roleName string
+		var permissions []string
+		if err := rows.Scan(&roleName, pq.Array(&permissions)); err != nil {
+			log.Fatal(err)
+		}
+		ret.RolePermissions[roleName] = permissions
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
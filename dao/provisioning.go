@@ -0,0 +1,209 @@
+package dao
+
+import (
+	"fmt"
+	"log"
+)
+
+// CreateProvisionedUser creates an active user that is a member of organizationID and can log in with identity
+// straight away. It returns ErrIdentityAlreadyLinked if another user has the identity.
+func (d *dao) CreateProvisionedUser(organizationID int64, user *OrganizationUser, identity *UserIdentity) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	sqlStatement := `
+		INSERT INTO organization_user (id, display_name, user_type, user_name, external_id, created_timestamp, current_state)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NOW(), $6);
+	`
+	_, err = tx.Exec(sqlStatement, user.ID, user.DisplayName, HumanUserType, user.UserName, user.ExternalID, UserActiveState)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sqlRefStatement := `
+INSERT INTO organization_organization_user_xref (organization_id, organization_user_id) VALUES ($1, $2);
+	`
+	_, err = tx.Exec(sqlRefStatement, organizationID, user.ID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	identity.OrganizationUserID = user.ID
+	if err := insertUserIdentity(tx, identity); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	user.CurrentState = UserActiveState
+	return nil
+}
+
+func (d *dao) UpdateProvisionedUser(user *OrganizationUser) {
+	sqlStatement := `
+		UPDATE
+			organization_user
+		SET
+			display_name = $2, user_name = $3, external_id = NULLIF($4, '')
+		WHERE
+			id = $1
+`
+	_, err := d.Db.Exec(sqlStatement, user.ID, user.DisplayName, user.UserName, user.ExternalID)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LoadOrganizationMembers returns the human users that are members of organizationID, if userName is not
+// empty only the user with that name compared case insensitively.
+func (d *dao) LoadOrganizationMembers(organizationID int64, userName string) []*OrganizationUser {
+	sqlStatement := `
+		SELECT
+			ou.id, ou.display_name, ou.user_type, COALESCE(ou.user_name, ''), COALESCE(ou.external_id, ''), ou.current_state
+		FROM
+			organization_user ou, organization_organization_user_xref x
+		WHERE
+			x.organization_id = $1 AND x.organization_user_id = ou.id AND ou.user_type = $2 AND
+			($3 = '' OR lower(ou.user_name) = lower($3))
+		ORDER BY
+			ou.id
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID, HumanUserType, userName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*OrganizationUser, 0)
+	for rows.Next() {
+		u := &OrganizationUser{Organizations: []int64{organizationID}}
+		if err := rows.Scan(&u.ID, &u.DisplayName, &u.UserType, &u.UserName, &u.ExternalID, &u.CurrentState); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, u)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+func (d *dao) IsOrganizationMember(organizationID, userID int64) bool {
+	sqlStatement := `
+		SELECT
+			count(1)
+		FROM
+			organization_organization_user_xref
+		WHERE
+			organization_id = $1 AND organization_user_id = $2
+`
+	var count int
+	err := d.Db.QueryRow(sqlStatement, organizationID, userID).Scan(&count)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
+
+func (d *dao) LoadRoles() []*Role {
+	rows, err := d.Db.Query(`SELECT id, display_name FROM role ORDER BY id`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*Role, 0)
+	for rows.Next() {
+		r := &Role{}
+		if err := rows.Scan(&r.ID, &r.DisplayName); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, r)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// LoadRoleMembers returns the users that have the role assigned directly in organizationID and not expired.
+func (d *dao) LoadRoleMembers(organizationID, roleID int64) []int64 {
+	sqlStatement := `
+		SELECT DISTINCT
+			organization_user_id
+		FROM
+			organization_organization_user_role_xref
+		WHERE
+			organization_id = $1 AND role_id = $2 AND (valid_until IS NULL OR valid_until > now() AT TIME ZONE 'UTC')
+		ORDER BY
+			organization_user_id
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID, roleID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, id)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// ChangeRoleMembers assigns the role in the organization to the added users, without a time bound unless they
+// already have it there without one, and removes it from the removed users. Roles granted by elevation requests are
+// kept, they end with the request. It returns an error wrapping ErrSeparationOfDuty, and changes nothing, if an added
+// user would end up holding two roles a static separation of duty constraint excludes.
+func (d *dao) ChangeRoleMembers(organizationID, roleID int64, addedUserIDs, removedUserIDs []int64) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, userID := range removedUserIDs {
+		sqlStatement := `
+		DELETE FROM
+				organization_organization_user_role_xref
+		WHERE
+				organization_id = $1 AND organization_user_id = $2 AND role_id = $3 AND elevation_request_id IS NULL
+`
+		if _, err := tx.Exec(sqlStatement, organizationID, userID, roleID); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, userID := range addedUserIDs {
+		sqlStatement := `
+		INSERT INTO
+				organization_organization_user_role_xref
+		(organization_id, organization_user_id, role_id)
+		SELECT
+				$1, $2, $3
+		WHERE NOT EXISTS
+				(SELECT 1 FROM organization_organization_user_role_xref WHERE organization_id = $1 AND organization_user_id = $2 AND role_id = $3 AND valid_from IS NULL AND valid_until IS NULL)
+`
+		if _, err := tx.Exec(sqlStatement, organizationID, userID, roleID); err != nil {
+			log.Fatal(err)
+		}
+		if err := checkStaticSeparationOfDuty(tx, userID, []int64{organizationID}); err != nil {
+			return fmt.Errorf("user %d: %w", userID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
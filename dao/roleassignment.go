@@ -0,0 +1,194 @@
+package dao
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// ExpireRoleAssignments removes the role assignments whose window ended before now and returns them. Permission
+// checks already ignore them, removing them only leaves a record of when they expired.
+func (d *dao) ExpireRoleAssignments(now time.Time) []*ExpiredRoleAssignment {
+	sqlStatement := `
+		WITH expired AS (
+			DELETE FROM
+				organization_organization_user_role_xref
+			WHERE
+				valid_until <= $1
+			RETURNING
+				organization_id, organization_user_id, role_id, valid_until
+		)
+		SELECT
+			e.organization_id, e.organization_user_id, e.role_id, COALESCE(r.display_name, ''), e.valid_until
+		FROM
+			expired e LEFT JOIN role r ON r.id = e.role_id
+`
+	rows, err := d.Db.Query(sqlStatement, now.UTC())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*ExpiredRoleAssignment, 0)
+	for rows.Next() {
+		a := &ExpiredRoleAssignment{}
+		var organizationID sql.NullInt64
+		if err := rows.Scan(&organizationID, &a.OrganizationUserID, &a.RoleID, &a.RoleName, &a.ValidUntil); err != nil {
+			log.Fatal(err)
+		}
+		a.OrganizationID = organizationID.Int64
+		ret = append(ret, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// SetRoleAssignmentsToUser replaces the roles of a user in each organization of the assignments at once. It
+// returns an error wrapping ErrSeparationOfDuty, and changes nothing, if the user would end up holding two roles
+// a static separation of duty constraint excludes and one of them is in the assignments.
+func (d *dao) SetRoleAssignmentsToUser(userID int64, assignments []*RoleAssignment) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	organizationIDs := replaceRoleAssignments(tx, userID, assignments)
+	if err := checkStaticSeparationOfDuty(tx, userID, organizationIDs); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// replaceRoleAssignments replaces the roles of a user in the organizations of the assignments and returns those
+// organizations. An organization can be in several assignments, its roles are replaced by all of them. Roles granted
+// by elevation requests are kept, they end with the request.
+func replaceRoleAssignments(tx *sql.Tx, userID int64, assignments []*RoleAssignment) []int64 {
+	organizationIDs := make([]int64, 0, len(assignments))
+	replaced := make(map[int64]bool)
+	for _, a := range assignments {
+		if replaced[a.OrganizationID] {
+			continue
+		}
+		replaced[a.OrganizationID] = true
+		organizationIDs = append(organizationIDs, a.OrganizationID)
+
+		sqlStatement := `
+		DELETE FROM
+			organization_organization_user_role_xref
+		WHERE
+			organization_id = $1
+			AND organization_user_id = $2
+			AND elevation_request_id IS NULL
+`
+		if _, err := tx.Exec(sqlStatement, a.OrganizationID, userID); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, a := range assignments {
+		for i := range a.RoleNames {
+			sqlStatement := `
+		INSERT INTO
+				organization_organization_user_role_xref
+		(organization_id, organization_user_id, role_id, valid_from, valid_until, inheritance, condition)
+		VALUES
+				(NULLIF($1::bigint,0), $2, (SELECT id FROM role WHERE display_name = $3), $4, $5, $6, NULLIF($7, ''))
+`
+			_, err := tx.Exec(sqlStatement, a.OrganizationID, userID, a.RoleNames[i], nullTime(a.ValidFrom), nullTime(a.ValidUntil), a.Inheritance, a.Condition)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	return organizationIDs
+}
+
+// RolesExceedingUserPermissions returns the roles among roleNames that grant a permission the user doesn't hold in
+// the organization, through an unconditional role reaching it or one outside of any organization. A permission
+// pattern of a role is checked along with every permission it matches, so the user must hold a pattern at least as
+// wide.
+func (d *dao) RolesExceedingUserPermissions(userID, organizationID int64, roleNames []string) []string {
+	sqlStatement := `
+		SELECT DISTINCT
+			r.display_name
+		FROM
+			role r, role_permission_xref rpx, permission rp, permission p
+		WHERE
+			r.display_name = ANY($3) AND rpx.role_id = r.id AND rp.id = rpx.permission_id AND permission_matches(rp.value, p.value) AND NOT EXISTS (
+				SELECT 1 FROM organization_user_roles($1) x LEFT JOIN organization a ON a.id = x.organization_id, role_permission_xref urpx, permission up
+				WHERE
+					urpx.role_id = x.role_id AND up.id = urpx.permission_id AND permission_matches(up.value, p.value) AND x.condition IS NULL AND (x.organization_id IS NULL OR
+					role_assignment_reaches(x.inheritance, a.path, (SELECT path FROM organization WHERE id = $2))))
+		ORDER BY
+			r.display_name
+`
+	rows, err := d.Db.Query(sqlStatement, userID, organizationID, pq.Array(roleNames))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]string, 0)
+	for rows.Next() {
+		var roleName string
+		if err := rows.Scan(&roleName); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, roleName)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// LoadPermissionGrantConditions returns the distinct conditions of the conditional assignments granting the user the
+// permission in the organization, the permission is held if any of them holds. It returns none if the permission is
+// denied there.
+func (d *dao) LoadPermissionGrantConditions(userID, organizationID int64, permission string) []string {
+	sqlStatement := `
+		SELECT DISTINCT
+			x.condition
+		FROM
+			organization_user_roles($1) x, organization a, role_permission_xref rpx, permission p
+		WHERE
+			a.id = x.organization_id AND x.condition IS NOT NULL AND
+			role_assignment_reaches(x.inheritance, a.path, (SELECT path FROM organization WHERE id = $2)) AND
+			rpx.role_id = x.role_id AND p.id = rpx.permission_id AND permission_matches(p.value, $3) AND
+			NOT EXISTS (SELECT 1 FROM organization_user_denies($1) d, organization o WHERE
+				d.permission = $3 AND o.id = d.organization_id AND o.path @> (SELECT path FROM organization WHERE id = $2))
+		ORDER BY
+			x.condition
+`
+	rows, err := d.Db.Query(sqlStatement, userID, organizationID, permission)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]string, 0)
+	for rows.Next() {
+		var condition string
+		if err := rows.Scan(&condition); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, condition)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
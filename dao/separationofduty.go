@@ -0,0 +1,231 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// ErrSeparationOfDuty is returned when a role assignment would violate a static separation of duty constraint.
+var ErrSeparationOfDuty = errors.New("violates separation of duty")
+
+// checkStaticSeparationOfDuty looks for static violations in the roles the user holds in the organizations, taking
+// in everything the transaction changed so far.
+func checkStaticSeparationOfDuty(tx *sql.Tx, userID int64, organizationIDs []int64) error {
+	sqlStatement := `
+		SELECT
+			s.organization_id, r.display_name, cr.display_name
+		FROM
+			separation_of_duty_conflicts($1, false) c, separation_of_duty s, role r, role cr
+		WHERE
+			NOT c.dynamic AND s.id = c.separation_of_duty_id AND r.id = c.role_id AND cr.id = c.conflicting_role_id AND
+			(c.organization_id = ANY($2) OR c.conflicting_organization_id = ANY($2))
+		LIMIT 1
+`
+	var organizationID int64
+	var roleName, conflictingRoleName string
+	err := tx.QueryRow(sqlStatement, userID, pq.Array(organizationIDs)).Scan(&organizationID, &roleName, &conflictingRoleName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fmt.Errorf("%w: %s and %s can't be held together in organization %d", ErrSeparationOfDuty, roleName, conflictingRoleName, organizationID)
+}
+
+// checkStaticSeparationOfDutyOfGroupMembers checks every user in the group, directly or through nested groups, in
+// the organizations where the group or the groups it is nested in hold roles.
+func checkStaticSeparationOfDutyOfGroupMembers(tx *sql.Tx, groupID int64) error {
+	sqlOrganizationsStatement := `
+		WITH RECURSIVE nesting (user_group_id) AS (
+			SELECT $1::bigint
+		  UNION
+			SELECT x.user_group_id FROM nesting n, user_group_member_xref x WHERE x.member_group_id = n.user_group_id
+		)
+		SELECT DISTINCT g.organization_id FROM nesting n, organization_user_group_role_xref g WHERE g.user_group_id = n.user_group_id
+`
+	organizationIDs := queryIDs(tx, sqlOrganizationsStatement, groupID)
+	if len(organizationIDs) == 0 {
+		return nil
+	}
+
+	sqlMembersStatement := `
+		WITH RECURSIVE nested (user_group_id) AS (
+			SELECT $1::bigint
+		  UNION
+			SELECT x.member_group_id FROM nested n, user_group_member_xref x WHERE x.user_group_id = n.user_group_id AND x.member_group_id IS NOT NULL
+		)
+		SELECT DISTINCT x.organization_user_id FROM nested n, user_group_member_xref x WHERE x.user_group_id = n.user_group_id AND x.organization_user_id IS NOT NULL
+`
+	for _, userID := range queryIDs(tx, sqlMembersStatement, groupID) {
+		if err := checkStaticSeparationOfDuty(tx, userID, organizationIDs); err != nil {
+			return fmt.Errorf("user %d: %w", userID, err)
+		}
+	}
+	return nil
+}
+
+func queryIDs(tx *sql.Tx, sqlStatement string, args ...interface{}) []int64 {
+	rows, err := tx.Query(sqlStatement, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, id)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// CheckSeparationOfDutyForRoles returns an error wrapping ErrSeparationOfDuty if two of roleNames can't be held
+// together in the organization under a static constraint, as for a new user that holds nothing else yet.
+func (d *dao) CheckSeparationOfDutyForRoles(organizationID int64, roleNames []string) error {
+	sqlStatement := `
+		SELECT
+			r.display_name, cr.display_name
+		FROM
+			separation_of_duty s, organization so, organization o, role r, role cr
+		WHERE
+			NOT s.dynamic AND so.id = s.organization_id AND o.id = $1 AND (o.path <@ so.path OR o.path @> so.path) AND
+			r.id = s.role_id AND cr.id = s.conflicting_role_id AND r.display_name = ANY($2) AND cr.display_name = ANY($2)
+		LIMIT 1
+`
+	var roleName, conflictingRoleName string
+	err := d.Db.QueryRow(sqlStatement, organizationID, pq.Array(roleNames)).Scan(&roleName, &conflictingRoleName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fmt.Errorf("%w: %s and %s can't be held together in organization %d", ErrSeparationOfDuty, roleName, conflictingRoleName, organizationID)
+}
+
+func (d *dao) CreateSeparationOfDuty(constraint *SeparationOfDuty) {
+	sqlStatement := `
+		INSERT INTO
+			separation_of_duty
+		(id, organization_id, role_id, conflicting_role_id, dynamic, created_timestamp)
+		VALUES
+		($1, $2, (SELECT id FROM role WHERE display_name = $3), (SELECT id FROM role WHERE display_name = $4), $5, $6)
+`
+	_, err := d.Db.Exec(sqlStatement, constraint.ID, constraint.OrganizationID, constraint.RoleName, constraint.ConflictingRoleName,
+		constraint.Dynamic, constraint.CreatedTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+const separationOfDutyColumns = `
+	s.id, s.organization_id, COALESCE(r.display_name, ''), COALESCE(cr.display_name, ''), s.dynamic, s.created_timestamp
+`
+
+const separationOfDutyTables = `
+	separation_of_duty s LEFT JOIN role r ON r.id = s.role_id LEFT JOIN role cr ON cr.id = s.conflicting_role_id
+`
+
+func scanSeparationOfDuties(rows *sql.Rows) []*SeparationOfDuty {
+	defer rows.Close()
+
+	ret := make([]*SeparationOfDuty, 0)
+	for rows.Next() {
+		constraint := &SeparationOfDuty{}
+		err := rows.Scan(&constraint.ID, &constraint.OrganizationID, &constraint.RoleName, &constraint.ConflictingRoleName,
+			&constraint.Dynamic, &constraint.CreatedTimestamp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, constraint)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+func (d *dao) LoadSeparationOfDuty(id int64) *SeparationOfDuty {
+	rows, err := d.Db.Query(`SELECT `+separationOfDutyColumns+` FROM `+separationOfDutyTables+` WHERE s.id = $1`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	constraints := scanSeparationOfDuties(rows)
+	if len(constraints) == 0 {
+		return nil
+	}
+	return constraints[0]
+}
+
+// LoadSeparationOfDutiesForOrganization returns the constraints of an organization, its ancestors and its
+// descendants.
+func (d *dao) LoadSeparationOfDutiesForOrganization(organizationID int64) []*SeparationOfDuty {
+	sqlStatement := `
+		SELECT ` + separationOfDutyColumns + `
+		FROM ` + separationOfDutyTables + `, organization o, organization x
+		WHERE
+			o.id = s.organization_id AND x.id = $1 AND (o.path @> x.path OR o.path <@ x.path)
+		ORDER BY
+			o.path, s.created_timestamp
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return scanSeparationOfDuties(rows)
+}
+
+func (d *dao) DeleteSeparationOfDuty(id int64) {
+	if _, err := d.Db.Exec(`DELETE FROM separation_of_duty WHERE id = $1`, id); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LoadSeparationOfDutyViolations returns the users that hold both roles of a constraint of the organization, its
+// ancestors or its descendants, whether through assignments made before the constraint, through groups or, for
+// dynamic constraints, on purpose.
+func (d *dao) LoadSeparationOfDutyViolations(organizationID int64) []*SeparationOfDutyViolation {
+	sqlStatement := `
+		SELECT
+			c.separation_of_duty_id, c.dynamic, u.id, c.organization_id, r.display_name, c.conflicting_organization_id, cr.display_name
+		FROM
+			organization_user u, LATERAL separation_of_duty_conflicts(u.id, true) c,
+			role r, role cr, separation_of_duty s, organization o, organization x
+		WHERE
+			r.id = c.role_id AND cr.id = c.conflicting_role_id AND s.id = c.separation_of_duty_id AND
+			o.id = s.organization_id AND x.id = $1 AND (o.path @> x.path OR o.path <@ x.path)
+		ORDER BY
+			u.id, c.separation_of_duty_id
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*SeparationOfDutyViolation, 0)
+	for rows.Next() {
+		v := &SeparationOfDutyViolation{}
+		err := rows.Scan(&v.SeparationOfDutyID, &v.Dynamic, &v.OrganizationUserID, &v.OrganizationID, &v.RoleName,
+			&v.ConflictingOrganizationID, &v.ConflictingRoleName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, v)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
@@ -0,0 +1,90 @@
+package dao
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// CreateSigningKey stores a new active key and retires the key it replaces.
+func (d *dao) CreateSigningKey(signingKey *SigningKey) {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	retireStatement := `
+		UPDATE
+			signing_key
+		SET
+			current_state = $1, retired_timestamp = $2
+		WHERE
+			current_state = $3
+`
+	_, err = tx.Exec(retireStatement, SigningKeyRetiredState, signingKey.CreatedTimestamp, SigningKeyActiveState)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	insertStatement := `
+		INSERT INTO
+			signing_key
+		(id, private_key, current_state, created_timestamp)
+		VALUES
+		($1, $2, $3, $4)
+`
+	_, err = tx.Exec(insertStatement, signingKey.ID, signingKey.PrivateKey, SigningKeyActiveState, signingKey.CreatedTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LoadSigningKeys returns the active and retired keys, newest first.
+func (d *dao) LoadSigningKeys() []*SigningKey {
+	sqlStatement := `
+		SELECT
+			id, private_key, current_state, created_timestamp, retired_timestamp
+		FROM
+			signing_key
+		ORDER BY
+			created_timestamp DESC
+`
+	rows, err := d.Db.Query(sqlStatement)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make([]*SigningKey, 0)
+	for rows.Next() {
+		k := &SigningKey{}
+		var retired sql.NullTime
+		if err := rows.Scan(&k.ID, &k.PrivateKey, &k.CurrentState, &k.CreatedTimestamp, &retired); err != nil {
+			log.Fatal(err)
+		}
+		k.RetiredTimestamp = retired.Time
+		ret = append(ret, k)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// PurgeSigningKeys deletes keys that were retired long enough ago that no token they signed is still valid.
+func (d *dao) PurgeSigningKeys(retiredBefore time.Time) {
+	sqlStatement := `
+		DELETE FROM
+			signing_key
+		WHERE
+			current_state = $1 AND retired_timestamp < $2
+`
+	_, err := d.Db.Exec(sqlStatement, SigningKeyRetiredState, retiredBefore)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,326 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// Errors returned when groups are created and their members change.
+var (
+	ErrUserGroupExists = errors.New("a group with that name already exists in the organization")
+	ErrUserGroupCycle  = errors.New("a group cannot be nested in itself")
+)
+
+// CreateUserGroup creates an empty group, it returns ErrUserGroupExists if the organization already has a group
+// with the same name.
+func (d *dao) CreateUserGroup(group *UserGroup) error {
+	sqlStatement := `
+		INSERT INTO
+			user_group
+		(id, organization_id, display_name, created_timestamp)
+		VALUES
+		($1, $2, $3, $4)
+`
+	_, err := d.Db.Exec(sqlStatement, group.ID, group.OrganizationID, group.DisplayName, group.CreatedTimestamp)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return ErrUserGroupExists
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func scanUserGroups(rows *sql.Rows) []*UserGroup {
+	defer rows.Close()
+
+	ret := make([]*UserGroup, 0)
+	for rows.Next() {
+		group := &UserGroup{}
+		if err := rows.Scan(&group.ID, &group.OrganizationID, &group.DisplayName, &group.CreatedTimestamp); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, group)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// LoadUserGroup loads a group with its direct members and the roles assigned to it.
+func (d *dao) LoadUserGroup(groupID int64) *UserGroup {
+	rows, err := d.Db.Query(`SELECT id, organization_id, display_name, created_timestamp FROM user_group WHERE id = $1`, groupID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	groups := scanUserGroups(rows)
+	if len(groups) == 0 {
+		return nil
+	}
+	ret := groups[0]
+
+	{
+		rows, err := d.Db.Query(`SELECT organization_user_id, member_group_id FROM user_group_member_xref WHERE user_group_id = $1`, groupID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var userID, memberGroupID sql.NullInt64
+			if err := rows.Scan(&userID, &memberGroupID); err != nil {
+				log.Fatal(err)
+			}
+			if userID.Valid {
+				ret.MemberUserIDs = append(ret.MemberUserIDs, userID.Int64)
+			}
+			if memberGroupID.Valid {
+				ret.MemberGroupIDs = append(ret.MemberGroupIDs, memberGroupID.Int64)
+			}
+		}
+	}
+
+	ret.Roles = make(UserRoleStore)
+	{
+		sqlStatement := `
+		SELECT
+			x.organization_id, r.id, r.display_name
+		FROM
+			organization_user_group_role_xref x, role r
+		WHERE
+			x.user_group_id = $1 AND r.id = x.role_id
+`
+		rows, err := d.Db.Query(sqlStatement, groupID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var organizationID int64
+			var role Role
+			if err := rows.Scan(&organizationID, &role.ID, &role.DisplayName); err != nil {
+				log.Fatal(err)
+			}
+			ret.Roles[organizationID] = append(ret.Roles[organizationID], role)
+		}
+	}
+	return ret
+}
+
+// LoadUserGroupGrantedRoles returns, by organization, the names of the roles the members of a group hold through it:
+// the roles of the group and of every group it is nested in.
+func (d *dao) LoadUserGroupGrantedRoles(groupID int64) map[int64][]string {
+	sqlStatement := `
+		WITH RECURSIVE nesting (user_group_id) AS (
+			SELECT $1::bigint
+		  UNION
+			SELECT x.user_group_id FROM nesting n, user_group_member_xref x WHERE x.member_group_id = n.user_group_id
+		)
+		SELECT DISTINCT
+			g.organization_id, r.display_name
+		FROM
+			nesting n, organization_user_group_role_xref g, role r
+		WHERE
+			g.user_group_id = n.user_group_id AND r.id = g.role_id
+`
+	rows, err := d.Db.Query(sqlStatement, groupID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	ret := make(map[int64][]string)
+	for rows.Next() {
+		var organizationID int64
+		var roleName string
+		if err := rows.Scan(&organizationID, &roleName); err != nil {
+			log.Fatal(err)
+		}
+		ret[organizationID] = append(ret[organizationID], roleName)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+// IsUserInUserGroup returns true if the user is a member of the group, directly or through nested groups.
+func (d *dao) IsUserInUserGroup(userID, groupID int64) bool {
+	var count int
+	if err := d.Db.QueryRow(`SELECT count(1) FROM organization_user_groups($1) WHERE user_group_id = $2`, userID, groupID).Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
+
+// LoadUserGroups returns the groups of an organization without their members and roles.
+func (d *dao) LoadUserGroups(organizationID int64) []*UserGroup {
+	sqlStatement := `
+		SELECT
+			id, organization_id, display_name, created_timestamp
+		FROM
+			user_group
+		WHERE
+			organization_id = $1
+		ORDER BY
+			display_name
+`
+	rows, err := d.Db.Query(sqlStatement, organizationID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return scanUserGroups(rows)
+}
+
+// DeleteUserGroup deletes a group, its members lose the roles they held through it.
+func (d *dao) DeleteUserGroup(groupID int64) {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, sqlStatement := range []string{
+		`DELETE FROM organization_user_group_role_xref WHERE user_group_id = $1`,
+		`DELETE FROM user_group_member_xref WHERE user_group_id = $1 OR member_group_id = $1`,
+		`DELETE FROM user_group WHERE id = $1`,
+	} {
+		if _, err := tx.Exec(sqlStatement, groupID); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetUserGroupMembers replaces the members of a group. It returns ErrUserGroupCycle if one of groupIDs is the
+// group itself or has the group nested in it, and an error wrapping ErrSeparationOfDuty if a member would hold two
+// roles a static separation of duty constraint excludes.
+func (d *dao) SetUserGroupMembers(groupID int64, userIDs, groupIDs []int64) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// Serialize membership changes so two requests can't each add half of a cycle.
+	if _, err := tx.Exec(`LOCK TABLE user_group_member_xref IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		log.Fatal(err)
+	}
+
+	sqlCycleStatement := `
+		WITH RECURSIVE nested (user_group_id) AS (
+			SELECT unnest($2::bigint[])
+		  UNION
+			SELECT x.member_group_id FROM nested n, user_group_member_xref x WHERE x.user_group_id = n.user_group_id AND x.member_group_id IS NOT NULL
+		)
+		SELECT count(1) FROM nested WHERE user_group_id = $1
+`
+	var count int
+	if err := tx.QueryRow(sqlCycleStatement, groupID, pq.Array(groupIDs)).Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	if count > 0 {
+		return ErrUserGroupCycle
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_group_member_xref WHERE user_group_id = $1`, groupID); err != nil {
+		log.Fatal(err)
+	}
+	sqlStatement := `
+		INSERT INTO
+			user_group_member_xref
+		(user_group_id, organization_user_id, member_group_id)
+		VALUES
+		($1, $2, $3)
+`
+	for _, id := range userIDs {
+		if _, err := tx.Exec(sqlStatement, groupID, id, nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, id := range groupIDs {
+		if _, err := tx.Exec(sqlStatement, groupID, nil, id); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := checkStaticSeparationOfDutyOfGroupMembers(tx, groupID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// SetRolesToUserGroup replaces the roles assigned to a group in each organization of the assignments at once, only
+// their OrganizationID and RoleNames are used. It returns an error wrapping ErrSeparationOfDuty, and changes nothing,
+// if a member would hold two roles a static separation of duty constraint excludes.
+func (d *dao) SetRolesToUserGroup(groupID int64, assignments []*RoleAssignment) error {
+	tx, err := d.Db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, a := range assignments {
+		sqlStatement := `
+		DELETE FROM
+			organization_user_group_role_xref
+		WHERE
+			organization_id = $1
+			AND user_group_id = $2
+`
+		if _, err := tx.Exec(sqlStatement, a.OrganizationID, groupID); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, a := range assignments {
+		for i := range a.RoleNames {
+			sqlStatement := `
+		INSERT INTO
+				organization_user_group_role_xref
+		(organization_id, user_group_id, role_id)
+		VALUES
+				($1, $2, (SELECT id FROM role WHERE display_name = $3))
+`
+			if _, err := tx.Exec(sqlStatement, a.OrganizationID, groupID, a.RoleNames[i]); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if err := checkStaticSeparationOfDutyOfGroupMembers(tx, groupID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// IsOrganizationInSubtree returns true if organizationID is rootID or one of its descendants.
+func (d *dao) IsOrganizationInSubtree(rootID, organizationID int64) bool {
+	sqlStatement := `
+		SELECT
+			count(1)
+		FROM
+			organization o, organization r
+		WHERE
+			o.id = $2 AND r.id = $1 AND o.path <@ r.path
+`
+	var count int
+	if err := d.Db.QueryRow(sqlStatement, rootID, organizationID).Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
@@ -0,0 +1,94 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+func (d *dao) CreateUserSession(userSession *UserSession) {
+	sqlStatement := `
+		INSERT INTO
+			user_session
+		(id, organization_user_id, provider_name, id_token, created_timestamp, expiration_timestamp)
+		VALUES
+		($1, $2, $3, $4, $5, $6)
+`
+	_, err := d.Db.Exec(sqlStatement, userSession.ID, userSession.OrganizationUserID, userSession.ProviderName, userSession.IDToken, userSession.CreatedTimestamp, userSession.ExpirationTimestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LoadUserSession returns the session if it has neither expired nor been revoked at now.
+func (d *dao) LoadUserSession(id string, now time.Time) *UserSession {
+	sqlStatement := `
+		SELECT
+			id, organization_user_id, provider_name, id_token, created_timestamp, expiration_timestamp
+		FROM
+			user_session
+		WHERE
+			id = $1 AND revoked_timestamp IS NULL AND expiration_timestamp > $2
+`
+	ret := &UserSession{}
+	row := d.Db.QueryRow(sqlStatement, id, now)
+	err := row.Scan(&ret.ID, &ret.OrganizationUserID, &ret.ProviderName, &ret.IDToken, &ret.CreatedTimestamp, &ret.ExpirationTimestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ret
+}
+
+func (d *dao) RevokeUserSession(id string, revoked time.Time) {
+	sqlStatement := `
+		UPDATE
+			user_session
+		SET
+			revoked_timestamp = $2
+		WHERE
+			id = $1 AND revoked_timestamp IS NULL
+`
+	_, err := d.Db.Exec(sqlStatement, id, revoked)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// RevokeUserSessions revokes every session of the user and returns how many were still active.
+func (d *dao) RevokeUserSessions(userID int64, revoked time.Time) int64 {
+	sqlStatement := `
+		UPDATE
+			user_session
+		SET
+			revoked_timestamp = $2
+		WHERE
+			organization_user_id = $1 AND revoked_timestamp IS NULL AND expiration_timestamp > $2
+`
+	result, err := d.Db.Exec(sqlStatement, userID, revoked)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cnt, err := result.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cnt
+}
+
+// PurgeUserSessions deletes sessions that expired or were revoked before before.
+func (d *dao) PurgeUserSessions(before time.Time) {
+	sqlStatement := `
+		DELETE FROM
+			user_session
+		WHERE
+			expiration_timestamp < $1 OR revoked_timestamp < $1
+`
+	_, err := d.Db.Exec(sqlStatement, before)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+package integrationtests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/auth/mockoidc"
+	"github.com/genesis32/complianceweb/server"
+	"github.com/genesis32/complianceweb/utils"
+)
+
+// TestInviteLoginFlow accepts an invite and logs in through the browser flow against a mock provider.
+func TestInviteLoginFlow(t *testing.T) {
+	baseServer := server.NewServer()
+	defer baseServer.Shutdown()
+	engine := baseServer.Initialize()
+
+	httpServer := httptest.NewServer(engine)
+	defer httpServer.Close()
+	baseServer.Config.SystemBaseUrl = httpServer.URL
+
+	provider, providerServer, err := mockoidc.NewTestServer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer providerServer.Close()
+	provider.Subject = "mockoidc|invitelogin"
+
+	baseServer.Authenticator = auth.NewOIDCAuthenticator(httpServer.URL+"/webapp/callback", auth.OIDCProviderConfiguration{
+		Name:         "mock",
+		Type:         auth.GenericProviderType,
+		IssuerURL:    providerServer.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	})
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := httpServer.Client()
+	cl.Jar = jar
+
+	req := createBaseRequest(t, httpServer, "", "POST", "/system/bootstrap")
+	addJsonBody(req, map[string]interface{}{
+		"SystemAdminName": "MockSystemAdmin",
+	})
+	resp, err := cl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bootstrap - statuscode expected: %d got: %d", http.StatusOK, resp.StatusCode)
+	}
+	var bootstrapResp genericJSON
+	if errs := json.NewDecoder(resp.Body).Decode(&bootstrapResp); errs != nil {
+		t.Fatal(errs)
+	}
+
+	resp, err = cl.Get(httpServer.URL + "/webapp/login?provider=mock&inviteCode=" + bootstrapResp["InviteCode"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login - statuscode expected: %d got: %d", http.StatusOK, resp.StatusCode)
+	}
+	var loginResp genericJSON
+	if errs := json.NewDecoder(resp.Body).Decode(&loginResp); errs != nil {
+		t.Fatal(errs)
+	}
+
+	req = createBaseRequest(t, httpServer, loginResp["idToken"].(string), "GET", "/api/me")
+	resp, err = cl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("me details - statuscode expected: %d got: %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// The browser is logged in through its session cookie until it logs out.
+	resp, err = cl.Get(httpServer.URL + "/api/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("me details with session - statuscode expected: %d got: %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// Other sites can make the browser send the cookie, but not the header changes need.
+	req, err = http.NewRequest(http.MethodPost, httpServer.URL+"/api/organizations", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addJsonBody(req, map[string]interface{}{
+		"Name": fmt.Sprintf("SessionOrg%d", utils.GetNextUniqueId()),
+	})
+	resp, err = cl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("add org with session - statuscode expected: %d got: %d", http.StatusForbidden, resp.StatusCode)
+	}
+	req.Header.Set(server.SessionRequestHeader, "XMLHttpRequest")
+	addJsonBody(req, map[string]interface{}{
+		"Name": fmt.Sprintf("SessionOrg%d", utils.GetNextUniqueId()),
+	})
+	resp, err = cl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("add org with session and header - statuscode expected: %d got: %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, err = cl.Get(httpServer.URL + "/webapp/logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("logout with get - statuscode expected: %d got: %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, err = cl.Post(httpServer.URL+"/webapp/logout", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/webapp/" {
+		t.Fatalf("logout - expected to end up at /webapp/ got: %d %s", resp.StatusCode, resp.Request.URL)
+	}
+
+	resp, err = cl.Get(httpServer.URL + "/api/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("me details after logout - statuscode expected: %d got: %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
@@ -0,0 +1,145 @@
+package integrationtests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/opa"
+	"github.com/genesis32/complianceweb/utils"
+)
+
+var opaTestRoles = []string{"Organization Admin", "GCP Administrator", "AWS Administrator"}
+
+var opaTestPermissions = []string{"user.read.execute", "user.update.execute", "gcp.serviceaccount.read.execute", "gcp.serviceaccount.write.execute", "aws.iam.user.create.execute"}
+
+type opaQuery struct {
+	User         string `json:"user"`
+	Organization string `json:"organization"`
+	Permission   string `json:"permission"`
+}
+
+// createOpaTestTree creates a random hierarchy with active users holding random grants and denies in it, and
+// returns its root, organizations and users.
+func createOpaTestTree(handler dao.DaoHandler, r *rand.Rand) (int64, []int64, []int64) {
+	orgIDs := make([]int64, 0)
+	for i := 2 + r.Intn(8); i > 0; i-- {
+		org := &dao.Organization{ID: utils.GetNextUniqueId(), DisplayName: fmt.Sprintf("OpaOrg%d", len(orgIDs))}
+		handler.CreateOrganization(org)
+		if len(orgIDs) > 0 {
+			handler.AssignOrganizationToParent(orgIDs[r.Intn(len(orgIDs))], org.ID)
+		}
+		orgIDs = append(orgIDs, org.ID)
+	}
+
+	userIDs := make([]int64, 0)
+	for i := 1 + r.Intn(4); i > 0; i-- {
+		userID, inviteCode := handler.CreateInviteForUser(orgIDs[0], fmt.Sprintf("OpaUser%d", len(userIDs)))
+		if err := handler.InitUserFromInviteCode(strconv.FormatInt(inviteCode, 10), auth.TestProviderType, "opa", fmt.Sprintf("opa|%d", userID)); err != nil {
+			log.Fatal(err)
+		}
+		userIDs = append(userIDs, userID)
+
+		assignments := make([]*dao.RoleAssignment, 0)
+		for j := r.Intn(4); j > 0; j-- {
+			assignments = append(assignments, &dao.RoleAssignment{
+				OrganizationID: orgIDs[r.Intn(len(orgIDs))],
+				RoleNames:      []string{opaTestRoles[r.Intn(len(opaTestRoles))]},
+				Inheritance:    r.Intn(3),
+			})
+		}
+		if err := handler.SetRoleAssignmentsToUser(userID, assignments); err != nil {
+			log.Fatal(err)
+		}
+		for j := r.Intn(3); j > 0; j-- {
+			handler.CreatePermissionDeny(&dao.PermissionDeny{
+				ID:                 utils.GetNextUniqueId(),
+				OrganizationID:     orgIDs[r.Intn(len(orgIDs))],
+				OrganizationUserID: userID,
+				Permission:         opaTestPermissions[r.Intn(len(opaTestPermissions))],
+				CreatedTimestamp:   time.Now().UTC(),
+			})
+		}
+	}
+	return orgIDs[0], orgIDs, userIDs
+}
+
+// TestOpaBundleAgreesWithDao evaluates the bundle of generated trees with the opa binary and compares its decisions
+// with DoesUserHavePermission.
+func TestOpaBundleAgreesWithDao(t *testing.T) {
+	opaBinary, err := exec.LookPath("opa")
+	if err != nil {
+		t.Skip("opa binary not found")
+	}
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := dao.NewDaoHandler(nil)
+	handler.Open()
+	defer handler.Close()
+
+	for seed := int64(0); seed < 10; seed++ {
+		rootID, orgIDs, userIDs := createOpaTestTree(handler, rand.New(rand.NewSource(seed)))
+		bundle, _, err := opa.Bundle(opa.NewData(handler.LoadPolicyExport(rootID)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundleFile := filepath.Join(dir, "bundle.tar.gz")
+		if err := ioutil.WriteFile(bundleFile, bundle, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		qs := make([]opaQuery, 0)
+		for _, userID := range userIDs {
+			for _, orgID := range orgIDs {
+				for _, permission := range opaTestPermissions {
+					qs = append(qs, opaQuery{User: strconv.FormatInt(userID, 10), Organization: strconv.FormatInt(orgID, 10), Permission: permission})
+				}
+			}
+		}
+		input, _ := json.Marshal(map[string]interface{}{"queries": qs})
+		cmd := exec.Command(opaBinary, "eval", "--bundle", bundleFile, "--format", "json", "--stdin-input",
+			"[data."+opa.Root+".authz.decision(q) | q := input.queries[_]]")
+		cmd.Stdin = bytes.NewReader(input)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+
+		var result struct {
+			Result []struct {
+				Expressions []struct {
+					Value []bool
+				}
+			}
+		}
+		if err := json.Unmarshal(out, &result); err != nil || len(result.Result) != 1 {
+			t.Fatalf("seed %d: %s %v", seed, out, err)
+		}
+		decisions := result.Result[0].Expressions[0].Value
+		if len(decisions) != len(qs) {
+			t.Fatalf("seed %d: got %d decisions for %d queries", seed, len(decisions), len(qs))
+		}
+		for i, q := range qs {
+			userID, _ := utils.StringToInt64(q.User)
+			orgID, _ := utils.StringToInt64(q.Organization)
+			if want := handler.DoesUserHavePermission(userID, orgID, q.Permission); decisions[i] != want {
+				t.Errorf("seed %d: %+v: rego %v dao %v", seed, q, decisions[i], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package integrationtests
+
+import (
+	"testing"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+)
+
+// TestPermissionPatternMatching checks the gcp.serviceaccount.* pattern the GCP Administrator role is seeded with
+// grants the gcp service account permissions and nothing that only shares its prefix.
+func TestPermissionPatternMatching(t *testing.T) {
+	handler := dao.NewDaoHandler(nil)
+	handler.Open()
+	defer handler.Close()
+
+	org := &dao.Organization{ID: utils.GetNextUniqueId(), DisplayName: "PatternOrg"}
+	handler.CreateOrganization(org)
+	userID, _ := handler.CreateInviteForUser(org.ID, "PatternUser")
+	if err := handler.SetRolesToUser(org.ID, userID, []string{"GCP Administrator"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for permission, want := range map[string]bool{
+		"gcp.serviceaccount.read.execute":        true,
+		"gcp.serviceaccount.write.execute":       true,
+		"gcp.serviceaccount.keys.create.execute": true,
+		"gcp.serviceaccount":                     false,
+		"gcp.serviceaccount.":                    false,
+		"gcp.serviceaccount..read.execute":       false,
+		"gcp.serviceaccount.read.":               false,
+		"gcp.serviceaccounts.read.execute":       false,
+		"gcp.serviceaccount.*":                   true,
+		"aws.iam.user.create.execute":            false,
+	} {
+		if got := handler.DoesUserHavePermission(userID, org.ID, permission); got != want {
+			t.Errorf("%s - expected %v got: %v", permission, want, got)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package integrationtests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+)
+
+// TestTimeBoundRoleAssignments checks roles grant nothing outside of their validity window and that expired ones
+// are removed.
+func TestTimeBoundRoleAssignments(t *testing.T) {
+	handler := dao.NewDaoHandler(nil)
+	handler.Open()
+	defer handler.Close()
+
+	org := &dao.Organization{ID: utils.GetNextUniqueId(), DisplayName: "TimeBoundOrg"}
+	handler.CreateOrganization(org)
+	now := time.Now().UTC()
+
+	pendingUserID, _ := handler.CreateInviteForUser(org.ID, "TimeBoundPendingUser")
+	if err := handler.SetTimeBoundRolesToUser(org.ID, pendingUserID, []string{"Organization Admin"}, now.Add(time.Hour), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	expiredUserID, _ := handler.CreateInviteForUser(org.ID, "TimeBoundExpiredUser")
+	if err := handler.SetTimeBoundRolesToUser(org.ID, expiredUserID, []string{"Organization Admin"}, time.Time{}, now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	currentUserID, _ := handler.CreateInviteForUser(org.ID, "TimeBoundCurrentUser")
+	if err := handler.SetTimeBoundRolesToUser(org.ID, currentUserID, []string{"Organization Admin"}, now.Add(-time.Minute), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for userID, want := range map[int64]bool{pendingUserID: false, expiredUserID: false, currentUserID: true} {
+		if got := handler.DoesUserHavePermission(userID, org.ID, "user.read.execute"); got != want {
+			t.Errorf("user %d - expected permission %v got: %v", userID, want, got)
+		}
+	}
+
+	expired := false
+	for _, a := range handler.ExpireRoleAssignments(now) {
+		if a.OrganizationUserID == pendingUserID || a.OrganizationUserID == currentUserID {
+			t.Errorf("user %d - expected the assignment to be kept", a.OrganizationUserID)
+		}
+		if a.OrganizationUserID == expiredUserID && a.OrganizationID == org.ID && a.RoleName == "Organization Admin" {
+			expired = true
+		}
+	}
+	if !expired {
+		t.Errorf("expected the expired assignment to be removed")
+	}
+
+	for userID, want := range map[int64]int{pendingUserID: 1, expiredUserID: 0, currentUserID: 1} {
+		if got := len(handler.LoadUserFromID(userID).UserRoles[org.ID]); got != want {
+			t.Errorf("user %d - expected %d roles got: %d", userID, want, got)
+		}
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/genesis32/complianceweb/utils"
 
@@ -18,15 +20,18 @@ import (
 type genericJSON map[string]interface{}
 
 const (
-	TreeOpAddUser           = 0
-	TreeOpAddOrg            = 1
-	TreeOpUserLogin         = 2
-	TreeOpBootstrap         = 3
-	TreeOpUpdateRole        = 4
-	TreeOpListOrganizations = 5
-	TreeOpDeactivateUser    = 6
-	TreeOpActivateUser      = 7
-	TreeOpMeDetails         = 8
+	TreeOpAddUser             = 0
+	TreeOpAddOrg              = 1
+	TreeOpUserLogin           = 2
+	TreeOpBootstrap           = 3
+	TreeOpUpdateRole          = 4
+	TreeOpListOrganizations   = 5
+	TreeOpDeactivateUser      = 6
+	TreeOpActivateUser        = 7
+	TreeOpMeDetails           = 8
+	TreeOpAddServicePrincipal = 9
+	TreeOpAPICall             = 10
+	TreeOpRunJob              = 11
 )
 
 type treeOp struct {
@@ -35,10 +40,65 @@ type treeOp struct {
 	ParentOrgName       string
 	Name                string
 	Roles               []string
+	Scopes              []string
 	SimulateLogin       bool
 	HTTPExpectedStatus  int
 	ResponseBody        string
 	ValidateFunc        func(t *testing.T, o *treeOp)
+	// Method, Path and Body of a TreeOpAPICall. {org:Name}, {user:Name} and {id:Name} in the path and in the strings
+	// of the body are replaced by the ids of the organizations, users and other objects created earlier.
+	Method string
+	Path   string
+	Body   interface{}
+	// StoreID keeps the id in the IDField of the response, ID by default, as {id:StoreID} for later calls.
+	StoreID string
+	IDField string
+	// Job is run by a TreeOpRunJob as if it was JobDelay from now.
+	Job      func(s *server.Server, now time.Time)
+	JobDelay time.Duration
+}
+
+// decodeResponse decodes the JSON response of the op into v.
+func decodeResponse(t *testing.T, o *treeOp, v interface{}) {
+	if err := json.Unmarshal([]byte(o.ResponseBody), v); err != nil {
+		t.Fatalf("%s %s - %v: %s", o.Method, o.Path, err, o.ResponseBody)
+	}
+}
+
+var placeholderPattern = regexp.MustCompile(`\{(org|user|id):([^}]+)\}`)
+
+// expandPlaceholders replaces the placeholders in the strings of v, see treeOp.
+func expandPlaceholders(t *testing.T, v interface{}, names map[string]map[string]int64) interface{} {
+	switch v := v.(type) {
+	case string:
+		return placeholderPattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+			m := placeholderPattern.FindStringSubmatch(placeholder)
+			id, ok := names[m[1]][m[2]]
+			if !ok {
+				t.Fatalf("unknown %s %s", m[1], m[2])
+			}
+			return strconv.FormatInt(id, 10)
+		})
+	case []string:
+		ret := make([]string, len(v))
+		for i := range v {
+			ret[i] = expandPlaceholders(t, v[i], names).(string)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i := range v {
+			ret[i] = expandPlaceholders(t, v[i], names)
+		}
+		return ret
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			ret[k] = expandPlaceholders(t, e, names)
+		}
+		return ret
+	}
+	return v
 }
 
 func testRunner(opsToRun []treeOp, baseServer *server.Server, s *httptest.Server) func(t *testing.T) {
@@ -46,6 +106,8 @@ func testRunner(opsToRun []treeOp, baseServer *server.Server, s *httptest.Server
 		var credentials = map[string]string{}
 		var orgNameToID = make(map[string]int64)
 		var usernameToID = make(map[string]int64)
+		var objectIDs = make(map[string]int64)
+		names := map[string]map[string]int64{"org": orgNameToID, "user": usernameToID, "id": objectIDs}
 		for i := range opsToRun {
 			cl := s.Client()
 			switch opsToRun[i].Op {
@@ -70,6 +132,8 @@ func testRunner(opsToRun []treeOp, baseServer *server.Server, s *httptest.Server
 							t.Fatal(errs)
 						}
 						inviteCode := jsonResp["InviteCode"].(string)
+						code, _ := utils.StringToInt64(inviteCode)
+						usernameToID[opsToRun[i].Name] = baseServer.Dao.LoadUserFromInviteCode(code).ID
 						credentials[opsToRun[i].Name] = simulateLogin(baseServer.Dao, inviteCode)
 					}
 				}
@@ -142,6 +206,37 @@ func testRunner(opsToRun []treeOp, baseServer *server.Server, s *httptest.Server
 						}
 					}
 				}
+			case TreeOpAddServicePrincipal:
+				{
+					req := createBaseRequest(t, s, credentials[opsToRun[i].CallerCredentialJwt], "POST", "/api/users")
+					addJsonBody(req, map[string]interface{}{
+						"Name":                 opsToRun[i].Name,
+						"ParentOrganizationID": strconv.FormatInt(orgNameToID[opsToRun[i].ParentOrgName], 10),
+						"RoleNames":            opsToRun[i].Roles,
+						"CreateCredential":     true,
+						"CredentialScopes":     opsToRun[i].Scopes,
+					})
+
+					resp, err := cl.Do(req)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if resp.StatusCode != opsToRun[i].HTTPExpectedStatus {
+						t.Fatalf("add service principal - statuscode expected: %d got: %d", opsToRun[i].HTTPExpectedStatus, resp.StatusCode)
+					}
+					if opsToRun[i].HTTPExpectedStatus >= 200 && opsToRun[i].HTTPExpectedStatus < 300 {
+						var jsonResp genericJSON
+						if errs := json.NewDecoder(resp.Body).Decode(&jsonResp); errs != nil {
+							t.Fatal(errs)
+						}
+						if v, errs := utils.StringToInt64(jsonResp["UserID"].(string)); errs != nil {
+							t.Fatal(errs)
+						} else {
+							usernameToID[opsToRun[i].Name] = v
+						}
+						credentials[opsToRun[i].Name] = jsonResp["Credentials"].(string)
+					}
+				}
 			case TreeOpUpdateRole:
 				{
 
@@ -203,6 +298,50 @@ func testRunner(opsToRun []treeOp, baseServer *server.Server, s *httptest.Server
 
 					}
 				}
+			case TreeOpAPICall:
+				{
+					path := expandPlaceholders(t, opsToRun[i].Path, names).(string)
+					req := createBaseRequest(t, s, credentials[opsToRun[i].CallerCredentialJwt], opsToRun[i].Method, path)
+					if opsToRun[i].Body != nil {
+						addJsonBody(req, expandPlaceholders(t, opsToRun[i].Body, names))
+					}
+					resp, err := cl.Do(req)
+					if err != nil {
+						t.Fatal(err)
+					}
+					body, err := ioutil.ReadAll(resp.Body)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if resp.StatusCode != opsToRun[i].HTTPExpectedStatus {
+						t.Fatalf("%s %s - statuscode expected: %d got: %d %s", opsToRun[i].Method, opsToRun[i].Path, opsToRun[i].HTTPExpectedStatus, resp.StatusCode, body)
+					}
+					opsToRun[i].ResponseBody = string(body)
+					if opsToRun[i].StoreID != "" {
+						field := opsToRun[i].IDField
+						if field == "" {
+							field = "ID"
+						}
+						var jsonResp genericJSON
+						decoder := json.NewDecoder(bytes.NewReader(body))
+						decoder.UseNumber()
+						if errs := decoder.Decode(&jsonResp); errs != nil {
+							t.Fatal(errs)
+						}
+						id, errs := utils.StringToInt64(fmt.Sprint(jsonResp[field]))
+						if errs != nil {
+							t.Fatalf("%s %s - no %s in %s", opsToRun[i].Method, opsToRun[i].Path, field, body)
+						}
+						objectIDs[opsToRun[i].StoreID] = id
+					}
+					if opsToRun[i].ValidateFunc != nil {
+						opsToRun[i].ValidateFunc(t, &opsToRun[i])
+					}
+				}
+			case TreeOpRunJob:
+				{
+					opsToRun[i].Job(baseServer, time.Now().UTC().Add(opsToRun[i].JobDelay))
+				}
 			case TreeOpMeDetails:
 				{
 					req := createBaseRequest(t, s, credentials[opsToRun[i].CallerCredentialJwt], "GET", "/api/me")
@@ -429,6 +568,1131 @@ var activateUserTest = append(deactivateUserTest, []treeOp{
 	},
 }...)
 
+var servicePrincipalTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddServicePrincipal,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0Service",
+		Roles:               []string{"Organization Admin"},
+		Scopes:              []string{"organization.create.execute"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Service",
+		Op:                  TreeOpAddOrg,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0ServiceSubOrg0",
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Service",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0ServiceUser",
+		Roles:               []string{"Organization Admin"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddServicePrincipal,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0BadScopeService",
+		Roles:               []string{"Organization Admin"},
+		Scopes:              []string{"not.a.permission"},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+}...)
+
+// privilegeCeilingTest runs after bootstrap, once the System Admin accepted its invite.
+var privilegeCeilingTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User1",
+		SimulateLogin:       true,
+		Roles:               []string{"Organization Admin"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/users/{user:RootOrg0Admin}/roles",
+		Body:                map[string]interface{}{"Roles": []interface{}{map[string]interface{}{"OrganizationID": "{org:RootOrg0}", "RoleNames": []string{"Organization Admin", "GCP Administrator"}}}},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/users/{user:RootOrg0User1}/roles",
+		Body:                map[string]interface{}{"Roles": []interface{}{map[string]interface{}{"OrganizationID": "{org:RootOrg0}", "RoleNames": []string{"GCP Administrator"}}}},
+		HTTPExpectedStatus:  http.StatusForbidden,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0GcpAdmin",
+		Roles:               []string{"GCP Administrator"},
+		HTTPExpectedStatus:  http.StatusForbidden,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/groups",
+		Body:                map[string]interface{}{"DisplayName": "Admins"},
+		StoreID:             "Admins",
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Admins}/members",
+		Body:                map[string]interface{}{"UserIDs": []string{"{user:RootOrg0User1}"}},
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Admins}/roles",
+		Body:                map[string]interface{}{"Roles": []interface{}{map[string]interface{}{"OrganizationID": "{org:RootOrg0}", "RoleNames": []string{"Organization Admin"}}}},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Admins}/roles",
+		Body:                map[string]interface{}{"Roles": []interface{}{map[string]interface{}{"OrganizationID": "{org:RootOrg0}", "RoleNames": []string{"Organization Admin"}}}},
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Admins}/members",
+		Body:                map[string]interface{}{"UserIDs": []string{"{user:RootOrg0User1}", "{user:RootOrg0Admin}"}},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+}...)
+
+// importManifest returns a manifest creating an organization with a user, linked to identity when it isn't empty,
+// holding roleNames in it.
+func importManifest(userName, identity string, roleNames ...string) map[string]interface{} {
+	user := map[string]interface{}{"name": userName, "userName": userName, "organization": "Imported"}
+	if identity != "" {
+		user["identity"] = map[string]interface{}{"issuer": "https://idp.example", "subject": identity}
+	}
+	if len(roleNames) > 0 {
+		user["roles"] = []interface{}{map[string]interface{}{"organization": "Imported", "roleNames": roleNames}}
+	}
+	return map[string]interface{}{
+		"organizations": []interface{}{map[string]interface{}{"name": "Imported"}},
+		"users":         []interface{}{user},
+	}
+}
+
+// importChangesValidator checks the changes of an import, each formatted as Action Kind.
+func importChangesValidator(dryRun bool, want ...string) func(t *testing.T, o *treeOp) {
+	return func(t *testing.T, o *treeOp) {
+		var response server.ImportResponse
+		decodeResponse(t, o, &response)
+		got := make([]string, 0, len(response.Changes))
+		for _, change := range response.Changes {
+			got = append(got, change.Action+" "+change.Kind)
+		}
+		if response.DryRun != dryRun || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("import - expected dry run %v %v got: %v %v", dryRun, want, response.DryRun, got)
+		}
+	}
+}
+
+var importTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/import?dryRun=true",
+		Body:                importManifest("bob@example.com", "bob-{org:RootOrg0}", "Organization Admin"),
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        importChangesValidator(true, "create organization", "create user", "update roles"),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/import",
+		Body:                importManifest("bob@example.com", "bob-{org:RootOrg0}", "Organization Admin"),
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        importChangesValidator(false, "create organization", "create user", "update roles"),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/import",
+		Body:                importManifest("bob@example.com", "bob-{org:RootOrg0}", "Organization Admin"),
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        importChangesValidator(false),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/import",
+		Body:                importManifest("bob@example.com", "admin-{org:RootOrg0}", "Organization Admin"),
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/import",
+		Body:                importManifest("carol@example.com", ""),
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        importChangesValidator(false, "create user"),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/import",
+		Body:                importManifest("carol@example.com", "admin-{org:RootOrg0}"),
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/import",
+		Body:                importManifest("dave@example.com", "", "GCP Administrator"),
+		HTTPExpectedStatus:  http.StatusForbidden,
+	},
+}...)
+
+// scimUserActiveValidator checks whether the SCIM user in the response is active.
+func scimUserActiveValidator(active bool) func(t *testing.T, o *treeOp) {
+	return func(t *testing.T, o *treeOp) {
+		var user server.ScimUser
+		decodeResponse(t, o, &user)
+		if user.Active == nil || *user.Active != active {
+			t.Fatalf("scim user - expected active %v got: %s", active, o.ResponseBody)
+		}
+	}
+}
+
+// scimGroupMembersValidator checks how many members the SCIM group in the response has.
+func scimGroupMembersValidator(want int) func(t *testing.T, o *treeOp) {
+	return func(t *testing.T, o *treeOp) {
+		var group server.ScimGroup
+		decodeResponse(t, o, &group)
+		if len(group.Members) != want {
+			t.Fatalf("scim group - expected %d members got: %s", want, o.ResponseBody)
+		}
+	}
+}
+
+func scimMembers(userNames ...string) map[string]interface{} {
+	members := make([]interface{}, 0, len(userNames))
+	for _, userName := range userNames {
+		members = append(members, map[string]interface{}{"value": "{user:" + userName + "}"})
+	}
+	return map[string]interface{}{"members": members}
+}
+
+// scimTest provisions RootOrg0 through a service principal, groups are roles and its own stay out of its reach.
+var scimTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User1",
+		SimulateLogin:       true,
+		Roles:               []string{"Organization Admin"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddServicePrincipal,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0Scim",
+		Roles:               []string{"Organization Admin"},
+		Scopes:              []string{"user.read.execute", "user.update.execute"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/scim/v2/Users",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc: func(t *testing.T, o *treeOp) {
+			var response server.ScimListResponse
+			decodeResponse(t, o, &response)
+			if response.TotalResults < 2 {
+				t.Fatalf("scim users - expected RootOrg0Admin and RootOrg0User1 got: %s", o.ResponseBody)
+			}
+		},
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPatch,
+		Path:                "/scim/v2/Users/{user:RootOrg0User1}",
+		Body:                map[string]interface{}{"Operations": []interface{}{map[string]interface{}{"op": "replace", "path": "active", "value": "False"}}},
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        scimUserActiveValidator(false),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPatch,
+		Path:                "/scim/v2/Users/{user:RootOrg0User1}",
+		Body:                map[string]interface{}{"Operations": []interface{}{map[string]interface{}{"op": "replace", "value": map[string]interface{}{"active": true}}}},
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        scimUserActiveValidator(true),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/scim/v2/Groups",
+		Body:                map[string]interface{}{"displayName": "Organization Admin"},
+		HTTPExpectedStatus:  http.StatusCreated,
+		StoreID:             "OrganizationAdminGroup",
+		IDField:             "id",
+		ValidateFunc:        scimGroupMembersValidator(3),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/scim/v2/Groups/{id:OrganizationAdminGroup}",
+		Body:                scimMembers("RootOrg0User1"),
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        scimGroupMembersValidator(2),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPatch,
+		Path:                "/scim/v2/Groups/{id:OrganizationAdminGroup}",
+		Body:                map[string]interface{}{"Operations": []interface{}{map[string]interface{}{"op": "add", "path": "members", "value": scimMembers("RootOrg0Admin")["members"]}}},
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        scimGroupMembersValidator(3),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPatch,
+		Path:                "/scim/v2/Groups/{id:OrganizationAdminGroup}",
+		Body:                map[string]interface{}{"Operations": []interface{}{map[string]interface{}{"op": "remove", "path": "members[value eq \"{user:RootOrg0Scim}\"]"}}},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/scim/v2/Groups/{id:OrganizationAdminGroup}",
+		Body:                scimMembers("RootOrg0User1", "RootOrg0Scim"),
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Scim",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/scim/v2/Groups",
+		Body:                map[string]interface{}{"displayName": "GCP Administrator", "members": scimMembers("RootOrg0User1")["members"]},
+		HTTPExpectedStatus:  http.StatusForbidden,
+	},
+}...)
+
+// rolesRequest sets the roles of a user or group in the organization.
+func rolesRequest(orgName string, roleNames ...string) map[string]interface{} {
+	return map[string]interface{}{"Roles": []interface{}{map[string]interface{}{"OrganizationID": "{org:" + orgName + "}", "RoleNames": roleNames}}}
+}
+
+// separationOfDutyTest makes GCP Administrator and AWS Administrator mutually exclusive in RootOrg0 after a user
+// already holds both.
+var separationOfDutyTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0CloudAdmin",
+		Roles:               []string{"GCP Administrator", "AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/separationofduty",
+		Body:                map[string]interface{}{"RoleName": "GCP Administrator", "ConflictingRoleName": "AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/separationofduty",
+		Body:                map[string]interface{}{"RoleName": "GCP Administrator", "ConflictingRoleName": "GCP Administrator"},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/separationofduty",
+		Body:                map[string]interface{}{"RoleName": "GCP Administrator", "ConflictingRoleName": "AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/api/organizations/{org:RootOrg0}/separationofduty/violations",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc: func(t *testing.T, o *treeOp) {
+			var violations []*server.SeparationOfDutyViolationResponse
+			decodeResponse(t, o, &violations)
+			if len(violations) != 1 || violations[0].RoleName != "GCP Administrator" || violations[0].ConflictingRoleName != "AWS Administrator" {
+				t.Fatalf("violations - expected RootOrg0CloudAdmin got: %s", o.ResponseBody)
+			}
+		},
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0CloudAdmin2",
+		Roles:               []string{"GCP Administrator", "AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0GcpAdmin",
+		Roles:               []string{"GCP Administrator"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/users/{user:RootOrg0GcpAdmin}/roles",
+		Body:                rolesRequest("RootOrg0", "GCP Administrator", "AWS Administrator"),
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/users/{user:RootOrg0CloudAdmin}/roles",
+		Body:                rolesRequest("RootOrg0", "GCP Administrator"),
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/api/organizations/{org:RootOrg0}/separationofduty/violations",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc: func(t *testing.T, o *treeOp) {
+			if o.ResponseBody != "[]" {
+				t.Fatalf("violations - expected none got: %s", o.ResponseBody)
+			}
+		},
+	},
+}...)
+
+// permissionExplanationValidator checks whether the explained permission is allowed and how many grants and denies
+// the explanation lists.
+func permissionExplanationValidator(allowed bool, grants, denies int) func(t *testing.T, o *treeOp) {
+	return func(t *testing.T, o *treeOp) {
+		var explanation server.PermissionExplanationResponse
+		decodeResponse(t, o, &explanation)
+		if explanation.Allowed != allowed || len(explanation.Grants) != grants || len(explanation.Denies) != denies {
+			t.Fatalf("explain - expected allowed %v with %d grants and %d denies got: %s", allowed, grants, denies, o.ResponseBody)
+		}
+	}
+}
+
+// permissionDenyTest denies RootOrg0User1 a permission its role in RootOrg0 grants in RootOrg0SubOrg0.
+var permissionDenyTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddOrg,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0SubOrg0",
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User1",
+		SimulateLogin:       true,
+		Roles:               []string{"Organization Admin"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0SubOrg0}/denies",
+		Body:                map[string]interface{}{"UserID": "{user:RootOrg0User1}", "Permission": "user.create.execute"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0SubOrg0}/denies",
+		Body:                map[string]interface{}{"UserID": "{user:RootOrg0User1}", "Permission": "gcp.serviceaccount.*"},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0SubOrg0}/denies",
+		Body:                map[string]interface{}{"UserID": "{user:RootOrg0User1}", "Permission": "user.create.execute"},
+		HTTPExpectedStatus:  http.StatusCreated,
+		StoreID:             "UserCreateDeny",
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0SubOrg0",
+		Name:                "RootOrg0SubOrg0User",
+		Roles:               []string{"Organization Admin"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User2",
+		Roles:               []string{"Organization Admin"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/api/users/{user:RootOrg0User1}/permissions/explain?organizationID={org:RootOrg0SubOrg0}&permission=user.create.execute",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        permissionExplanationValidator(false, 1, 1),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/api/users/{user:RootOrg0User1}/permissions/explain?organizationID={org:RootOrg0}&permission=user.create.execute",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        permissionExplanationValidator(true, 1, 0),
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodDelete,
+		Path:                "/api/denies/{id:UserCreateDeny}",
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0SubOrg0",
+		Name:                "RootOrg0SubOrg0User",
+		Roles:               []string{"Organization Admin"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+}...)
+
+// elevationStateValidator checks the state of the elevation request in the response.
+func elevationStateValidator(state string) func(t *testing.T, o *treeOp) {
+	return func(t *testing.T, o *treeOp) {
+		var elevation server.ElevationResponse
+		decodeResponse(t, o, &elevation)
+		if elevation.State != state {
+			t.Fatalf("elevation - expected %s got: %s", state, o.ResponseBody)
+		}
+	}
+}
+
+// elevationTest elevates RootOrg0User1 to Organization Admin until the elevation expires.
+var elevationTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User1",
+		SimulateLogin:       true,
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations",
+		Body:                map[string]interface{}{"OrganizationID": "{org:RootOrg0}", "RoleName": "Organization Admin"},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations",
+		Body:                map[string]interface{}{"OrganizationID": "{org:RootOrg0}", "RoleName": "Organization Admin", "DurationMinutes": 30, "Justification": "onboarding"},
+		HTTPExpectedStatus:  http.StatusCreated,
+		StoreID:             "Approved",
+		ValidateFunc:        elevationStateValidator("pending"),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User2",
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations/{id:Approved}/approve",
+		Body:                map[string]interface{}{"Reason": "self"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations/{id:Approved}/approve",
+		Body:                map[string]interface{}{"Reason": "ticket 1"},
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        elevationStateValidator("approved"),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations/{id:Approved}/deny",
+		Body:                map[string]interface{}{"Reason": "too late"},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User2",
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations",
+		Body:                map[string]interface{}{"OrganizationID": "{org:RootOrg0}", "RoleName": "AWS Administrator", "Justification": "incident"},
+		HTTPExpectedStatus:  http.StatusCreated,
+		StoreID:             "Self",
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations/{id:Self}/approve",
+		Body:                map[string]interface{}{"Reason": "self"},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations/{id:Self}/deny",
+		Body:                map[string]interface{}{"Reason": "not needed"},
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        elevationStateValidator("denied"),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/elevations/{id:Self}/approve",
+		Body:                map[string]interface{}{"Reason": "changed my mind"},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		Op:       TreeOpRunJob,
+		Job:      server.ExpireElevationRequestsJob,
+		JobDelay: time.Hour,
+	},
+	{
+		Op:       TreeOpRunJob,
+		Job:      server.ExpireRoleAssignmentsJob,
+		JobDelay: time.Hour,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/api/elevations",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc: func(t *testing.T, o *treeOp) {
+			var elevations []*server.ElevationResponse
+			decodeResponse(t, o, &elevations)
+			if len(elevations) != 1 || elevations[0].State != "expired" {
+				t.Fatalf("elevations - expected an expired one got: %s", o.ResponseBody)
+			}
+		},
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User3",
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+}...)
+
+// pendingOperationValidator checks the state of the operation in the response and the status it got when it was
+// executed.
+func pendingOperationValidator(state string, resultStatus int) func(t *testing.T, o *treeOp) {
+	return func(t *testing.T, o *treeOp) {
+		var operation server.PendingOperationResponse
+		decodeResponse(t, o, &operation)
+		if operation.State != state || operation.ResultStatus != resultStatus {
+			t.Fatalf("operation - expected %s with result %d got: %s", state, resultStatus, o.ResponseBody)
+		}
+	}
+}
+
+// addUserRequest adds a user with the roles to the organization.
+func addUserRequest(orgName, userName string, roleNames ...string) map[string]interface{} {
+	return map[string]interface{}{"Name": userName, "ParentOrganizationID": "{org:" + orgName + "}", "RoleNames": roleNames}
+}
+
+// dualControlTest puts adding users to RootOrg0 under dual control, RootOrg0Admin2 decides what RootOrg0Admin asks
+// for.
+var dualControlTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0Admin2",
+		SimulateLogin:       true,
+		Roles:               []string{"Organization Admin"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/organizations/{org:RootOrg0}/dualcontrol",
+		Body:                map[string]interface{}{"Permissions": []string{"user.create.execute"}},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/organizations/{org:RootOrg0}/dualcontrol",
+		Body:                map[string]interface{}{"Permissions": []string{"user.create.execute"}},
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/users",
+		Body:                addUserRequest("RootOrg0", "RootOrg0User1", "Organization Admin"),
+		HTTPExpectedStatus:  http.StatusAccepted,
+		StoreID:             "AddUser1",
+		ValidateFunc:        pendingOperationValidator("pending", 0),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/operations/{id:AddUser1}/approve",
+		Body:                map[string]interface{}{"Reason": "self"},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin2",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/api/organizations/{org:RootOrg0}/operations",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc: func(t *testing.T, o *treeOp) {
+			var operations []*server.PendingOperationResponse
+			decodeResponse(t, o, &operations)
+			if len(operations) != 1 || operations[0].State != "pending" || operations[0].Path != "/api/users" {
+				t.Fatalf("operations - expected the captured request got: %s", o.ResponseBody)
+			}
+		},
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin2",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/operations/{id:AddUser1}/approve",
+		Body:                map[string]interface{}{"Reason": "ticket 1"},
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        pendingOperationValidator("approved", http.StatusCreated),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin2",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/operations/{id:AddUser1}/approve",
+		Body:                map[string]interface{}{"Reason": "again"},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/users",
+		Body:                addUserRequest("RootOrg0", "RootOrg0User2", "Organization Admin"),
+		HTTPExpectedStatus:  http.StatusAccepted,
+		StoreID:             "AddUser2",
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin2",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/operations/{id:AddUser2}/deny",
+		Body:                map[string]interface{}{"Reason": "not needed"},
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc:        pendingOperationValidator("denied", 0),
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin2",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/operations/{id:AddUser2}/approve",
+		Body:                map[string]interface{}{"Reason": "changed my mind"},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/api/organizations/{org:RootOrg0}/operations",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc: func(t *testing.T, o *treeOp) {
+			var operations []*server.PendingOperationResponse
+			decodeResponse(t, o, &operations)
+			if len(operations) != 2 {
+				t.Fatalf("operations - expected both requests got: %s", o.ResponseBody)
+			}
+		},
+	},
+}...)
+
+// breakGlassTest breaks the glass as RootOrg0Oncall, which can only do so again once SystemAdmin reviewed the
+// access.
+var breakGlassTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0Oncall",
+		SimulateLogin:       true,
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass",
+		Body:                map[string]interface{}{"Reason": "outage"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/users/{user:RootOrg0Oncall}/breakglass",
+		Body:                map[string]interface{}{"Designated": true},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/users/{user:RootOrg0Oncall}/breakglass",
+		Body:                map[string]interface{}{"Designated": true},
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass",
+		Body:                map[string]interface{}{"Reason": " "},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAddOrg,
+		Name:                "BreakGlassOrg",
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass",
+		Body:                map[string]interface{}{"Reason": "outage"},
+		HTTPExpectedStatus:  http.StatusCreated,
+		StoreID:             "Access1",
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAddOrg,
+		Name:                "BreakGlassOrg",
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass/{id:Access1}/review",
+		Body:                map[string]interface{}{"Notes": "all good"},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass/{id:Access1}/review",
+		Body:                map[string]interface{}{"Notes": "too early"},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass/{id:Access1}/end",
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass/{id:Access1}/end",
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAddOrg,
+		Name:                "BreakGlassOrg2",
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass",
+		Body:                map[string]interface{}{"Reason": "another outage"},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass/{id:Access1}/review",
+		Body:                map[string]interface{}{"Notes": ""},
+		HTTPExpectedStatus:  http.StatusBadRequest,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass/{id:Access1}/review",
+		Body:                map[string]interface{}{"Notes": "postmortem 1"},
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc: func(t *testing.T, o *treeOp) {
+			var access server.BreakGlassResponse
+			decodeResponse(t, o, &access)
+			if access.ReviewerID == 0 || access.Reviewed == nil || access.ReviewNotes != "postmortem 1" {
+				t.Fatalf("break glass - expected the review got: %s", o.ResponseBody)
+			}
+		},
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass/{id:Access1}/review",
+		Body:                map[string]interface{}{"Notes": "postmortem 2"},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Oncall",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass",
+		Body:                map[string]interface{}{"Reason": "another outage"},
+		HTTPExpectedStatus:  http.StatusCreated,
+		StoreID:             "Access2",
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/breakglass/{id:Access2}/end",
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+}...)
+
+// userGroupTest gives RootOrg0User1 Organization Admin in RootOrg0SubOrg0 through Operators, which is nested in
+// Admins.
+var userGroupTest = append(baseTree, []treeOp{
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAddOrg,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0SubOrg0",
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "SystemAdmin",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User1",
+		SimulateLogin:       true,
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/groups",
+		Body:                map[string]interface{}{"DisplayName": "Admins"},
+		HTTPExpectedStatus:  http.StatusCreated,
+		StoreID:             "Admins",
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPost,
+		Path:                "/api/organizations/{org:RootOrg0}/groups",
+		Body:                map[string]interface{}{"DisplayName": "Operators"},
+		HTTPExpectedStatus:  http.StatusCreated,
+		StoreID:             "Operators",
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Admins}/roles",
+		Body:                rolesRequest("RootOrg0SubOrg0", "Organization Admin"),
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0SubOrg0",
+		Name:                "RootOrg0SubOrg0User",
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Operators}/members",
+		Body:                map[string]interface{}{"UserIDs": []string{"{user:RootOrg0User1}"}},
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Admins}/members",
+		Body:                map[string]interface{}{"GroupIDs": []string{"{id:Operators}"}},
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodGet,
+		Path:                "/api/groups/{id:Admins}",
+		HTTPExpectedStatus:  http.StatusOK,
+		ValidateFunc: func(t *testing.T, o *treeOp) {
+			var group server.UserGroupResponse
+			decodeResponse(t, o, &group)
+			if group.Members == nil || len(group.Members.GroupIDs) != 1 || len(group.Members.UserIDs) != 0 || len(group.Roles) != 1 {
+				t.Fatalf("group - expected Operators nested and one role got: %s", o.ResponseBody)
+			}
+		},
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0SubOrg0",
+		Name:                "RootOrg0SubOrg0User",
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusCreated,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0",
+		Name:                "RootOrg0User2",
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Operators}/members",
+		Body:                map[string]interface{}{"UserIDs": []string{"{user:RootOrg0User1}"}, "GroupIDs": []string{"{id:Admins}"}},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Admins}/members",
+		Body:                map[string]interface{}{"GroupIDs": []string{"{id:Operators}", "{id:Admins}"}},
+		HTTPExpectedStatus:  http.StatusConflict,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0Admin",
+		Op:                  TreeOpAPICall,
+		Method:              http.MethodPut,
+		Path:                "/api/groups/{id:Admins}/members",
+		Body:                map[string]interface{}{},
+		HTTPExpectedStatus:  http.StatusOK,
+	},
+	{
+		CallerCredentialJwt: "RootOrg0User1",
+		Op:                  TreeOpAddUser,
+		ParentOrgName:       "RootOrg0SubOrg0",
+		Name:                "RootOrg0SubOrg0User2",
+		Roles:               []string{"AWS Administrator"},
+		HTTPExpectedStatus:  http.StatusUnauthorized,
+	},
+}...)
+
 func TestTree(t *testing.T) {
 	baseServer := server.NewServer()
 	defer baseServer.Shutdown()
@@ -445,4 +1709,14 @@ func TestTree(t *testing.T) {
 	t.Run("deactivate user", testRunner(deactivateUserTest, baseServer, httpServer))
 	t.Run("activate user", testRunner(activateUserTest, baseServer, httpServer))
 	t.Run("user details", testRunner(meDetailsUserTest, baseServer, httpServer))
+	t.Run("service principal", testRunner(servicePrincipalTest, baseServer, httpServer))
+	t.Run("privilege ceiling", testRunner(privilegeCeilingTest, baseServer, httpServer))
+	t.Run("import", testRunner(importTest, baseServer, httpServer))
+	t.Run("scim", testRunner(scimTest, baseServer, httpServer))
+	t.Run("separation of duty", testRunner(separationOfDutyTest, baseServer, httpServer))
+	t.Run("permission deny", testRunner(permissionDenyTest, baseServer, httpServer))
+	t.Run("elevation", testRunner(elevationTest, baseServer, httpServer))
+	t.Run("dual control", testRunner(dualControlTest, baseServer, httpServer))
+	t.Run("break glass", testRunner(breakGlassTest, baseServer, httpServer))
+	t.Run("user groups", testRunner(userGroupTest, baseServer, httpServer))
 }
@@ -12,6 +12,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/genesis32/complianceweb/auth"
 	"github.com/genesis32/complianceweb/dao"
 	"github.com/genesis32/complianceweb/utils"
 )
@@ -31,8 +32,13 @@ func simulateLogin(handler dao.DaoHandler, inviteCode string) string {
 	jwt := generateTestJwt()
 
 	hsKey := make([]byte, 64)
-	claims := utils.ParseTestJwt(jwt, hsKey)
-	handler.InitUserFromInviteCode(inviteCode, claims["sub"].(string))
+	claims, err := utils.ParseTestJwt(jwt, hsKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := handler.InitUserFromInviteCode(inviteCode, auth.TestProviderType, claims["iss"].(string), claims["sub"].(string)); err != nil {
+		log.Fatal(err)
+	}
 	return jwt
 }
 
@@ -0,0 +1,51 @@
+package integrationtests
+
+import (
+	"testing"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+)
+
+// TestOrganizationVisibilityRespectsInheritance grants a role on an organization only and checks its child isn't
+// visible through it.
+func TestOrganizationVisibilityRespectsInheritance(t *testing.T) {
+	handler := dao.NewDaoHandler(nil)
+	handler.Open()
+	defer handler.Close()
+
+	createOrganization := func(name string) *dao.Organization {
+		org := &dao.Organization{ID: utils.GetNextUniqueId(), DisplayName: name}
+		handler.CreateOrganization(org)
+		return org
+	}
+	home := createOrganization("VisibilityHome")
+	parent := createOrganization("VisibilityParent")
+	child := createOrganization("VisibilityChild")
+	handler.AssignOrganizationToParent(parent.ID, child.ID)
+
+	userID, _ := handler.CreateInviteForUser(home.ID, "VisibilityUser")
+	err := handler.SetRoleAssignmentsToUser(userID, []*dao.RoleAssignment{
+		{OrganizationID: parent.ID, RoleNames: []string{"Organization Admin"}, Inheritance: dao.RoleInheritanceOrganization},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !handler.CanUserViewOrg(userID, parent.ID) {
+		t.Errorf("expected the organization of the grant to be visible")
+	}
+	if handler.CanUserViewOrg(userID, child.ID) {
+		t.Errorf("expected the child to not be visible")
+	}
+
+	orgs := handler.LoadOrganizationsForUser(userID)
+	for _, org := range []*dao.Organization{home, parent} {
+		if orgs[org.ID] == nil {
+			t.Errorf("expected %s to be loaded", org.DisplayName)
+		}
+	}
+	if orgs[child.ID] != nil {
+		t.Errorf("expected %s to not be loaded", child.DisplayName)
+	}
+}
@@ -0,0 +1,96 @@
+// Package ladon exports the grants and denies of the organization hierarchy as Ory Ladon policies
+// (https://github.com/ory/ladon). Subjects are users:<id>, resources are organizations:<path> with the ltree path of
+// the organization, or system for roles outside of any organization, and actions are permissions. Parts between < and >
+// are regular expressions as Ladon's default matcher expects them.
+package ladon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/genesis32/complianceweb/dao"
+)
+
+// Effects of a policy.
+const (
+	AllowAccess = "allow"
+	DenyAccess  = "deny"
+)
+
+// SystemResource is the resource of roles outside of any organization.
+const SystemResource = "system"
+
+// Policy has the JSON layout of a Ladon DefaultPolicy.
+type Policy struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	Subjects    []string `json:"subjects"`
+	Effect      string   `json:"effect"`
+	Resources   []string `json:"resources"`
+	Actions     []string `json:"actions"`
+}
+
+// Subject returns the subject of a user.
+func Subject(userID int64) string {
+	return fmt.Sprintf("users:%d", userID)
+}
+
+// Resource returns the resource of the organization at path.
+func Resource(path string) string {
+	return "organizations:" + path
+}
+
+// Action returns the action of a permission, a * in a permission pattern matches one or more segments.
+func Action(permission string) string {
+	return strings.ReplaceAll(permission, "*", `<[^.]+(\.[^.]+)*>`)
+}
+
+// grantResources returns the resources a role assigned at path with inheritance applies to.
+func grantResources(path string, inheritance int) []string {
+	if path == "" {
+		return []string{SystemResource}
+	}
+	prefix := Resource(path)
+	switch inheritance {
+	case dao.RoleInheritanceOrganization:
+		return []string{prefix}
+	case dao.RoleInheritanceDescendants:
+		return []string{prefix + `<(\.[0-9]+)+>`}
+	}
+	return []string{prefix + `<(\.[0-9]+)*>`}
+}
+
+// Policies turns an export into one allow policy per role a user holds and one deny policy per deny applying to a
+// user. Ladon lets a deny override every allow, like the denies of the hierarchy.
+func Policies(export *dao.PolicyExport) []*Policy {
+	ret := make([]*Policy, 0, len(export.Grants)+len(export.Denies))
+	for _, g := range export.Grants {
+		actions := make([]string, 0, len(g.Permissions))
+		for _, p := range g.Permissions {
+			actions = append(actions, Action(p))
+		}
+		where := "outside of any organization"
+		if g.OrganizationID != 0 {
+			where = fmt.Sprintf("in organization %d", g.OrganizationID)
+		}
+		ret = append(ret, &Policy{
+			ID:          fmt.Sprintf("grant:%d:%d:%s:%d", g.OrganizationUserID, g.OrganizationID, g.RoleName, g.Inheritance),
+			Description: fmt.Sprintf("%s of user %d %s", g.RoleName, g.OrganizationUserID, where),
+			Subjects:    []string{Subject(g.OrganizationUserID)},
+			Effect:      AllowAccess,
+			Resources:   grantResources(g.OrganizationPath, g.Inheritance),
+			Actions:     actions,
+		})
+	}
+	for _, d := range export.Denies {
+		ret = append(ret, &Policy{
+			ID:          fmt.Sprintf("deny:%d:%d", d.ID, d.OrganizationUserID),
+			Description: fmt.Sprintf("deny %s to user %d in organization %d", d.Permission, d.OrganizationUserID, d.OrganizationID),
+			Subjects:    []string{Subject(d.OrganizationUserID)},
+			Effect:      DenyAccess,
+			Resources:   grantResources(d.OrganizationPath, dao.RoleInheritanceSubtree),
+			Actions:     []string{Action(d.Permission)},
+		})
+	}
+	return ret
+}
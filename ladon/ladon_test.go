@@ -0,0 +1,90 @@
+package ladon
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/genesis32/complianceweb/dao"
+)
+
+// matches compares like Ladon's default matcher, the parts between < and > are regular expressions.
+func matches(t *testing.T, pattern, value string) bool {
+	if !strings.Contains(pattern, "<") {
+		return pattern == value
+	}
+	expr := "^"
+	for {
+		start := strings.IndexByte(pattern, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(pattern, '>')
+		expr += regexp.QuoteMeta(pattern[:start]) + "(?:" + pattern[start+1:end] + ")"
+		pattern = pattern[end+1:]
+	}
+	re, err := regexp.Compile(expr + regexp.QuoteMeta(pattern) + "$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return re.MatchString(value)
+}
+
+func allowed(t *testing.T, policies []*Policy, subject, resource, action string) bool {
+	matchesAny := func(patterns []string, value string) bool {
+		for _, p := range patterns {
+			if matches(t, p, value) {
+				return true
+			}
+		}
+		return false
+	}
+	ret := false
+	for _, p := range policies {
+		if !matchesAny(p.Subjects, subject) || !matchesAny(p.Resources, resource) || !matchesAny(p.Actions, action) {
+			continue
+		}
+		if p.Effect == DenyAccess {
+			return false
+		}
+		ret = true
+	}
+	return ret
+}
+
+func TestPolicies(t *testing.T) {
+	policies := Policies(&dao.PolicyExport{
+		Grants: []*dao.PolicyGrant{
+			{OrganizationUserID: 7, OrganizationID: 2, OrganizationPath: "1.2", RoleName: "Organization Admin", Permissions: []string{"user.read.execute"}},
+			{OrganizationUserID: 7, OrganizationID: 1, OrganizationPath: "1", RoleName: "GCP Administrator", Inheritance: dao.RoleInheritanceOrganization, Permissions: []string{"gcp.serviceaccount.*"}},
+			{OrganizationUserID: 8, OrganizationID: 1, OrganizationPath: "1", RoleName: "Organization Admin", Inheritance: dao.RoleInheritanceDescendants, Permissions: []string{"user.read.execute"}},
+			{OrganizationUserID: 9, RoleName: "System Admin", Permissions: []string{"system.update.execute"}},
+		},
+		Denies: []*dao.PolicyDeny{
+			{ID: 100, OrganizationUserID: 7, OrganizationID: 3, OrganizationPath: "1.2.3", Permission: "user.read.execute"},
+		},
+	})
+
+	tests := []struct {
+		subject, resource, action string
+		want                      bool
+	}{
+		{"users:7", "organizations:1.2", "user.read.execute", true},
+		{"users:7", "organizations:1.2.4", "user.read.execute", true},
+		{"users:7", "organizations:1.2.3", "user.read.execute", false},
+		{"users:7", "organizations:1.2.3.5", "user.read.execute", false},
+		{"users:7", "organizations:1.22", "user.read.execute", false},
+		{"users:7", "organizations:1", "user.read.execute", false},
+		{"users:7", "organizations:1", "gcp.serviceaccount.read.execute", true},
+		{"users:7", "organizations:1.2", "gcp.serviceaccount.read.execute", false},
+		{"users:8", "organizations:1", "user.read.execute", false},
+		{"users:8", "organizations:1.2", "user.read.execute", true},
+		{"users:9", SystemResource, "system.update.execute", true},
+		{"users:9", "organizations:1", "system.update.execute", false},
+	}
+	for _, test := range tests {
+		if got := allowed(t, policies, test.subject, test.resource, test.action); got != test.want {
+			t.Errorf("%s %s %s: got %v want %v", test.subject, test.resource, test.action, got, test.want)
+		}
+	}
+}
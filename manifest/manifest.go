@@ -0,0 +1,184 @@
+// Package manifest reads the manifests bulk imports apply. A manifest describes a subtree of organizations and the
+// users in it, in YAML or JSON:
+//
+//	organizations:
+//	  - name: Acme
+//	    metadata: {region: eu}
+//	    children:
+//	      - name: Acme Payments
+//	users:
+//	  - name: Alice Smith
+//	    userName: alice@acme.example
+//	    organization: Acme
+//	    identity: {issuer: "https://accounts.google.com", subject: "1234"}
+//	    roles:
+//	      - organization: Acme/Acme Payments
+//	        roleNames: [Organization Admin]
+//
+// Organizations are referred to by the names on the way down from the organization the manifest is imported into,
+// separated by /, the empty path is that organization itself. Users are identified by their userName, those without
+// an identity are invited.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PathSeparator separates the names of the organizations in a path.
+const PathSeparator = "/"
+
+// Manifest is the root of a manifest.
+type Manifest struct {
+	Organizations []*Organization `json:"organizations"`
+	Users         []*User         `json:"users"`
+}
+
+// Organization is an organization and the organizations below it. Metadata, when present, replaces the
+// organization's metadata.
+type Organization struct {
+	Name     string                 `json:"name"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Children []*Organization        `json:"children"`
+}
+
+// User is a user, a member of Organization, and the roles it holds.
+type User struct {
+	Name         string            `json:"name"`
+	UserName     string            `json:"userName"`
+	Organization string            `json:"organization"`
+	Identity     *Identity         `json:"identity"`
+	Roles        []*RoleAssignment `json:"roles"`
+}
+
+// Identity is an identity the user logs in with, linked to it without an invite.
+type Identity struct {
+	Type    string `json:"type"`
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+// RoleAssignment replaces the roles of a user in an organization, like an entry of PUT /api/users/:userID/roles.
+type RoleAssignment struct {
+	Organization string     `json:"organization"`
+	RoleNames    []string   `json:"roleNames"`
+	ValidFrom    *time.Time `json:"validFrom"`
+	ValidUntil   *time.Time `json:"validUntil"`
+	Inheritance  string     `json:"inheritance"`
+	Condition    string     `json:"condition"`
+}
+
+// Parse reads a manifest in YAML or JSON, unknown fields are an error.
+func Parse(data []byte) (*Manifest, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	normalized, err := normalize(raw)
+	if err != nil {
+		return nil, err
+	}
+	// Decoding the JSON of it gives YAML and JSON manifests the same field names and checks.
+	j, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(j))
+	decoder.DisallowUnknownFields()
+	ret := &Manifest{}
+	if err := decoder.Decode(ret); err != nil {
+		return nil, err
+	}
+	return ret, ret.validate()
+}
+
+// normalize turns the maps YAML decodes into ones JSON can encode.
+func normalize(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+			n, err := normalize(v)
+			if err != nil {
+				return nil, err
+			}
+			ret[key] = n
+		}
+		return ret, nil
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i := range t {
+			n, err := normalize(t[i])
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = n
+		}
+		return ret, nil
+	}
+	return v, nil
+}
+
+// Path joins the names of organizations into a path.
+func Path(names ...string) string {
+	return strings.Join(names, PathSeparator)
+}
+
+func (m *Manifest) validate() error {
+	var validateOrganizations func(parent string, orgs []*Organization) error
+	validateOrganizations = func(parent string, orgs []*Organization) error {
+		seen := make(map[string]bool)
+		for _, o := range orgs {
+			if o.Name == "" || strings.Contains(o.Name, PathSeparator) {
+				return fmt.Errorf("organization names in %q can't be empty or contain %s", parent, PathSeparator)
+			}
+			if seen[o.Name] {
+				return fmt.Errorf("organization %q is listed more than once", Path(parent, o.Name))
+			}
+			seen[o.Name] = true
+			path := o.Name
+			if parent != "" {
+				path = Path(parent, o.Name)
+			}
+			if err := validateOrganizations(path, o.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := validateOrganizations("", m.Organizations); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, u := range m.Users {
+		if u.Name == "" || u.UserName == "" {
+			return fmt.Errorf("users need a name and a userName")
+		}
+		key := strings.ToLower(u.UserName)
+		if seen[key] {
+			return fmt.Errorf("user %q is listed more than once", u.UserName)
+		}
+		seen[key] = true
+		if u.Identity != nil && (u.Identity.Issuer == "" || u.Identity.Subject == "") {
+			return fmt.Errorf("identity of user %q needs an issuer and a subject", u.UserName)
+		}
+		orgs := make(map[string]bool)
+		for _, r := range u.Roles {
+			if orgs[r.Organization] {
+				return fmt.Errorf("roles of user %q list organization %q more than once", u.UserName, r.Organization)
+			}
+			orgs[r.Organization] = true
+		}
+	}
+	return nil
+}
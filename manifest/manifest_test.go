@@ -0,0 +1,71 @@
+package manifest
+
+import (
+	"reflect"
+	"testing"
+)
+
+const yamlManifest = `
+organizations:
+  - name: Acme
+    metadata:
+      region: eu
+      limits: {users: 10}
+    children:
+      - name: Acme Payments
+users:
+  - name: Alice Smith
+    userName: alice@acme.example
+    organization: Acme
+    identity: {issuer: "https://accounts.google.com", subject: "1234"}
+    roles:
+      - organization: Acme/Acme Payments
+        roleNames: [Organization Admin]
+        inheritance: organization
+`
+
+const jsonManifest = `{
+  "organizations": [{"name": "Acme", "metadata": {"region": "eu", "limits": {"users": 10}}, "children": [{"name": "Acme Payments"}]}],
+  "users": [{
+    "name": "Alice Smith", "userName": "alice@acme.example", "organization": "Acme",
+    "identity": {"issuer": "https://accounts.google.com", "subject": "1234"},
+    "roles": [{"organization": "Acme/Acme Payments", "roleNames": ["Organization Admin"], "inheritance": "organization"}]
+  }]
+}`
+
+func TestParse(t *testing.T) {
+	fromYAML, err := Parse([]byte(yamlManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := Parse([]byte(jsonManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Fatalf("yaml and json manifests differ: %+v %+v", fromYAML, fromJSON)
+	}
+	if limits := fromYAML.Organizations[0].Metadata["limits"].(map[string]interface{}); limits["users"] != float64(10) {
+		t.Fatalf("nested metadata %v", limits)
+	}
+	if r := fromYAML.Users[0].Roles[0]; r.Organization != Path("Acme", "Acme Payments") || r.RoleNames[0] != "Organization Admin" {
+		t.Fatalf("roles %+v", r)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, m := range []string{
+		"organizations: [{name: Acme, color: red}]",
+		"organizations: [{name: Acme}, {name: Acme}]",
+		"organizations: [{name: Acme/EU}]",
+		"users: [{name: Alice}]",
+		"users: [{name: Alice, userName: alice}, {name: Alice, userName: ALICE}]",
+		"users: [{name: Alice, userName: alice, identity: {issuer: x}}]",
+		"users: [{name: Alice, userName: alice, roles: [{organization: Acme}, {organization: Acme}]}]",
+		"organizations: {1: 2}",
+	} {
+		if _, err := Parse([]byte(m)); err == nil {
+			t.Errorf("%s: expected an error", m)
+		}
+	}
+}
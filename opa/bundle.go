@@ -0,0 +1,60 @@
+package opa
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+type manifest struct {
+	Revision string   `json:"revision"`
+	Roots    []string `json:"roots"`
+}
+
+// Bundle returns the gzipped tarball of a bundle of the data and the policy along with its revision. The same data
+// always gives the same bundle, the revision is a hash of it to be used as its ETag.
+func Bundle(data *Data) ([]byte, string, error) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, "", err
+	}
+	hash := sha256.New()
+	hash.Write(dataJSON)
+	hash.Write([]byte(Policy))
+	revision := hex.EncodeToString(hash.Sum(nil))
+
+	manifestJSON, err := json.Marshal(&manifest{Revision: revision, Roots: []string{Root}})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := []struct {
+		name     string
+		contents []byte
+	}{
+		{"/.manifest", manifestJSON},
+		{"/" + Root + "/data.json", dataJSON},
+		{"/" + Root + "/authz.rego", []byte(Policy)},
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.contents)), Typeflag: tar.TypeReg}); err != nil {
+			return nil, "", err
+		}
+		if _, err := tw.Write(f.contents); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), revision, nil
+}
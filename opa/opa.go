@@ -0,0 +1,163 @@
+// Package opa exports the grants and denies of the organization hierarchy as an Open Policy Agent bundle. The bundle
+// holds the data under data.complianceweb and a Rego policy, package complianceweb.authz, reproducing the decisions of
+// DoesUserHavePermission and DoesUserHaveSystemPermission. Ids are strings as they don't fit in JSON numbers.
+package opa
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/genesis32/complianceweb/dao"
+)
+
+// Root is where the bundle puts its data and policy.
+const Root = "complianceweb"
+
+// Data is the data.json of a bundle.
+type Data struct {
+	Organizations map[string]*Organization `json:"organizations"`
+	Roles         map[string][]string      `json:"roles"`
+	Users         map[string]*User         `json:"users"`
+}
+
+// Organization has the ids from the root of the hierarchy down to the organization itself.
+type Organization struct {
+	Path []string `json:"path"`
+}
+
+// User has the roles a user holds and the denies applying to it.
+type User struct {
+	Grants []*Grant `json:"grants"`
+	Denies []*Deny  `json:"denies"`
+}
+
+// Grant is a role held in an organization, Organization is empty for roles outside of any organization.
+type Grant struct {
+	Organization string `json:"organization"`
+	Role         string `json:"role"`
+	Inheritance  int    `json:"inheritance"`
+}
+
+// Deny takes a permission away from a user in an organization and its descendants.
+type Deny struct {
+	Organization string `json:"organization"`
+	Permission   string `json:"permission"`
+}
+
+func formatID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// NewData returns the data of an export.
+func NewData(export *dao.PolicyExport) *Data {
+	ret := &Data{
+		Organizations: make(map[string]*Organization),
+		Roles:         make(map[string][]string),
+		Users:         make(map[string]*User),
+	}
+	for id, path := range export.Organizations {
+		ret.Organizations[formatID(id)] = &Organization{Path: strings.Split(path, ".")}
+	}
+	for role, permissions := range export.RolePermissions {
+		ret.Roles[role] = permissions
+	}
+	user := func(id int64) *User {
+		u, ok := ret.Users[formatID(id)]
+		if !ok {
+			u = &User{Grants: make([]*Grant, 0), Denies: make([]*Deny, 0)}
+			ret.Users[formatID(id)] = u
+		}
+		return u
+	}
+	for _, g := range export.Grants {
+		u := user(g.OrganizationUserID)
+		u.Grants = append(u.Grants, &Grant{Organization: formatID(g.OrganizationID), Role: g.RoleName, Inheritance: g.Inheritance})
+	}
+	for _, d := range export.Denies {
+		u := user(d.OrganizationUserID)
+		u.Denies = append(u.Denies, &Deny{Organization: formatID(d.OrganizationID), Permission: d.Permission})
+	}
+	return ret
+}
+
+// isPrefix returns true if prefix is an ancestor of, or the same as, path.
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func reaches(inheritance int, assigned, target []string) bool {
+	switch inheritance {
+	case dao.RoleInheritanceOrganization:
+		return len(assigned) == len(target) && isPrefix(assigned, target)
+	case dao.RoleInheritanceDescendants:
+		return len(assigned) < len(target) && isPrefix(assigned, target)
+	}
+	return isPrefix(assigned, target)
+}
+
+// permissionMatches returns true if a permission of a role grants permission, a * matches one or more segments.
+func permissionMatches(pattern, permission string) bool {
+	if pattern == permission {
+		return true
+	}
+	if !strings.Contains(pattern, "*") {
+		return false
+	}
+	expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `[^.]+(\.[^.]+)*`)
+	matched, _ := regexp.MatchString("^"+expr+"$", permission)
+	return matched
+}
+
+func (d *Data) roleGrants(role, permission string) bool {
+	for _, p := range d.Roles[role] {
+		if permissionMatches(p, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed is the decision of the policy's allow rule.
+func (d *Data) Allowed(userID, organizationID, permission string) bool {
+	user, target := d.Users[userID], d.Organizations[organizationID]
+	if user == nil || target == nil {
+		return false
+	}
+	for _, deny := range user.Denies {
+		if o := d.Organizations[deny.Organization]; deny.Permission == permission && o != nil && isPrefix(o.Path, target.Path) {
+			return false
+		}
+	}
+	for _, grant := range user.Grants {
+		if o := d.Organizations[grant.Organization]; o != nil && reaches(grant.Inheritance, o.Path, target.Path) && d.roleGrants(grant.Role, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// SystemAllowed is the decision of the policy's system_allow rule.
+func (d *Data) SystemAllowed(userID, permission string) bool {
+	user := d.Users[userID]
+	if user == nil {
+		return false
+	}
+	for _, grant := range user.Grants {
+		if grant.Organization == "" && d.roleGrants(grant.Role, permission) {
+			return true
+		}
+	}
+	return false
+}
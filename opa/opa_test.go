@@ -0,0 +1,137 @@
+package opa
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/genesis32/complianceweb/dao"
+)
+
+var testPermissions = []string{"user.read.execute", "user.update.execute", "gcp.serviceaccount.read.execute", "gcp.serviceaccount.keys.create.execute"}
+
+var testRoles = map[string][]string{
+	"Reader":             {"user.read.execute"},
+	"Organization Admin": {"user.read.execute", "user.update.execute"},
+	"GCP Administrator":  {"gcp.serviceaccount.*"},
+	"Executor":           {"*.execute"},
+}
+
+// generateTree returns an export of a random hierarchy with random grants and denies.
+func generateTree(r *rand.Rand) *dao.PolicyExport {
+	export := &dao.PolicyExport{Organizations: make(map[int64]string), RolePermissions: testRoles}
+	orgCount := 2 + r.Intn(12)
+	for id := int64(1); id <= int64(orgCount); id++ {
+		path := strconv.FormatInt(id, 10)
+		if id > 1 {
+			path = export.Organizations[1+r.Int63n(id-1)] + "." + path
+		}
+		export.Organizations[id] = path
+	}
+
+	roleNames := make([]string, 0, len(testRoles))
+	for name := range testRoles {
+		roleNames = append(roleNames, name)
+	}
+	sort.Strings(roleNames)
+	userCount := int64(1 + r.Intn(5))
+	for userID := int64(100); userID < 100+userCount; userID++ {
+		for i := r.Intn(4); i > 0; i-- {
+			orgID := 1 + r.Int63n(int64(orgCount))
+			grant := &dao.PolicyGrant{OrganizationUserID: userID, OrganizationID: orgID, OrganizationPath: export.Organizations[orgID], RoleName: roleNames[r.Intn(len(roleNames))], Inheritance: r.Intn(3)}
+			if r.Intn(10) == 0 {
+				grant.OrganizationID, grant.OrganizationPath, grant.Inheritance = 0, "", 0
+			}
+			export.Grants = append(export.Grants, grant)
+		}
+		for i := r.Intn(3); i > 0; i-- {
+			orgID := 1 + r.Int63n(int64(orgCount))
+			export.Denies = append(export.Denies, &dao.PolicyDeny{OrganizationUserID: userID, OrganizationID: orgID, OrganizationPath: export.Organizations[orgID], Permission: testPermissions[r.Intn(len(testPermissions))]})
+		}
+	}
+	return export
+}
+
+func TestAllowed(t *testing.T) {
+	data := NewData(&dao.PolicyExport{
+		Organizations:   map[int64]string{1: "1", 2: "1.2", 3: "1.2.3", 4: "1.4"},
+		RolePermissions: testRoles,
+		Grants: []*dao.PolicyGrant{
+			{OrganizationUserID: 7, OrganizationID: 2, RoleName: "Organization Admin"},
+			{OrganizationUserID: 7, OrganizationID: 1, RoleName: "GCP Administrator", Inheritance: dao.RoleInheritanceOrganization},
+			{OrganizationUserID: 8, OrganizationID: 1, RoleName: "Reader", Inheritance: dao.RoleInheritanceDescendants},
+			{OrganizationUserID: 9, RoleName: "Executor"},
+		},
+		Denies: []*dao.PolicyDeny{{OrganizationUserID: 7, OrganizationID: 3, Permission: "user.update.execute"}},
+	})
+
+	tests := []struct {
+		user, organization, permission string
+		want                           bool
+	}{
+		{"7", "2", "user.update.execute", true},
+		{"7", "3", "user.read.execute", true},
+		{"7", "3", "user.update.execute", false},
+		{"7", "4", "user.read.execute", false},
+		{"7", "1", "gcp.serviceaccount.keys.create.execute", true},
+		{"7", "2", "gcp.serviceaccount.read.execute", false},
+		{"8", "1", "user.read.execute", false},
+		{"8", "4", "user.read.execute", true},
+		{"9", "1", "user.read.execute", false},
+		{"10", "1", "user.read.execute", false},
+	}
+	for _, test := range tests {
+		if got := data.Allowed(test.user, test.organization, test.permission); got != test.want {
+			t.Errorf("%v: got %v", test, got)
+		}
+	}
+	if !data.SystemAllowed("9", "system.update.execute") || data.SystemAllowed("7", "user.read.execute") {
+		t.Error("system decisions")
+	}
+}
+
+func TestBundle(t *testing.T) {
+	data := NewData(generateTree(rand.New(rand.NewSource(1))))
+	bundle, revision, err := Bundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, againRevision, err := Bundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bundle, again) || revision != againRevision {
+		t.Fatal("bundles of the same data differ")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		files[header.Name], _ = ioutil.ReadAll(tr)
+	}
+	var m manifest
+	if err := json.Unmarshal(files["/.manifest"], &m); err != nil || m.Revision != revision {
+		t.Fatalf("manifest %s: %v", files["/.manifest"], err)
+	}
+	var roundTrip Data
+	if err := json.Unmarshal(files["/"+Root+"/data.json"], &roundTrip); err != nil {
+		t.Fatal(err)
+	}
+	if string(files["/"+Root+"/authz.rego"]) != Policy {
+		t.Fatal("policy missing from bundle")
+	}
+}
@@ -0,0 +1,78 @@
+package opa
+
+// Policy is the Rego policy of the bundle. Its input is {"user": ..., "organization": ..., "permission": ...} for
+// allow and {"user": ..., "permission": ...} for system_allow, decision answers a list of queries at once.
+const Policy = `package complianceweb.authz
+
+import rego.v1
+
+default allow := false
+
+# A role assigned to the user in an organization reaching the target organization grants its permissions, unless a
+# deny of the permission in the target organization or one of its ancestors overrides it.
+allow if allowed(input.user, input.organization, input.permission)
+
+default system_allow := false
+
+# Roles outside of any organization grant system permissions.
+system_allow if {
+	some grant in data.complianceweb.users[input.user].grants
+	grant.organization == ""
+	role_grants(grant.role, input.permission)
+}
+
+decision(q) := true if {
+	allowed(q.user, q.organization, q.permission)
+} else := false
+
+allowed(user, organization, permission) if {
+	target := data.complianceweb.organizations[organization].path
+	not denied(user, target, permission)
+	some grant in data.complianceweb.users[user].grants
+	grant.organization != ""
+	reaches(grant.inheritance, data.complianceweb.organizations[grant.organization].path, target)
+	role_grants(grant.role, permission)
+}
+
+denied(user, target, permission) if {
+	some deny in data.complianceweb.users[user].denies
+	deny.permission == permission
+	is_prefix(data.complianceweb.organizations[deny.organization].path, target)
+}
+
+# 0: the organization and its descendants, 1: the organization only, 2: its descendants only.
+reaches(inheritance, assigned, target) if {
+	inheritance == 0
+	is_prefix(assigned, target)
+}
+
+reaches(inheritance, assigned, target) if {
+	inheritance == 1
+	assigned == target
+}
+
+reaches(inheritance, assigned, target) if {
+	inheritance == 2
+	count(assigned) < count(target)
+	is_prefix(assigned, target)
+}
+
+is_prefix(prefix, path) if {
+	count(prefix) <= count(path)
+	array.slice(path, 0, count(prefix)) == prefix
+}
+
+role_grants(role, permission) if {
+	some pattern in data.complianceweb.roles[role]
+	permission_matches(pattern, permission)
+}
+
+permission_matches(pattern, permission) if pattern == permission
+
+# A * matches one or more segments.
+permission_matches(pattern, permission) if {
+	contains(pattern, "*")
+	expr := replace(replace(pattern, ".", "\\."), "*", "[^.]+(\\.[^.]+)*")
+	regex.match(concat("", ["^", expr, "$"]), permission)
+}
+`
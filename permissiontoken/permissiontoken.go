@@ -0,0 +1,160 @@
+// Package permissiontoken verifies the permission tokens complianceweb mints so services can authorize requests
+// locally instead of asking complianceweb for every decision. A token lists the effective permissions of one user
+// in every organization of a subtree and is only valid until it expires, so changes to roles take effect for
+// downstream services at the latest when the tokens minted before them expire.
+package permissiontoken
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// The registered claims of a permission token.
+const (
+	Issuer    = "urn:complianceweb:local"
+	Audience  = "urn:complianceweb:permissions"
+	Algorithm = "RS256"
+)
+
+// The private claims of a permission token.
+const (
+	OrganizationClaim = "org"
+	PermissionsClaim  = "permissions"
+)
+
+// JWKSPath is where complianceweb publishes its signing keys relative to its base url.
+const JWKSPath = "/.well-known/jwks.json"
+
+// minRefreshInterval limits how often an unknown key id makes the verifier fetch the key set again.
+const minRefreshInterval = time.Minute
+
+// Token is a verified permission token.
+type Token struct {
+	Subject        string
+	OrganizationID int64
+	Permissions    map[int64][]string
+	Expires        time.Time
+}
+
+// HasPermission returns true if the user had permission in organizationID when the token was minted.
+// Organizations outside of the subtree the token was minted for are always denied.
+func (t *Token) HasPermission(organizationID int64, permission string) bool {
+	for _, p := range t.Permissions[organizationID] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Verifier checks the signature and claims of permission tokens against the keys complianceweb publishes.
+type Verifier struct {
+	JWKSURL string
+	Client  *http.Client
+
+	mu      sync.Mutex
+	keys    jose.JSONWebKeySet
+	fetched time.Time
+}
+
+// NewVerifier returns a verifier that fetches keys from the complianceweb instance at baseURL.
+func NewVerifier(baseURL string) *Verifier {
+	return &Verifier{JWKSURL: baseURL + JWKSPath, Client: http.DefaultClient}
+}
+
+// NewStaticVerifier returns a verifier that only trusts the keys in keySet and never fetches any.
+func NewStaticVerifier(keySet jose.JSONWebKeySet) *Verifier {
+	return &Verifier{keys: keySet}
+}
+
+func (v *Verifier) fetchKeys() error {
+	resp, err := v.Client.Get(v.JWKSURL)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", v.JWKSURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", v.JWKSURL, resp.Status)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return fmt.Errorf("decoding %s: %w", v.JWKSURL, err)
+	}
+	v.keys = keySet
+	v.fetched = time.Now()
+	return nil
+}
+
+// key returns the public key for kid, fetching the key set again when kid is unknown since complianceweb may
+// have rotated its signing key.
+func (v *Verifier) key(kid string) (interface{}, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keys := v.keys.Key(kid)
+	if len(keys) == 0 && v.JWKSURL != "" && time.Since(v.fetched) > minRefreshInterval {
+		if err := v.fetchKeys(); err != nil {
+			return nil, err
+		}
+		keys = v.keys.Key(kid)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return keys[0].Key, nil
+}
+
+// Verify checks rawToken and returns the permissions it carries.
+func (v *Verifier) Verify(rawToken string) (*Token, error) {
+	parser := &jwt.Parser{ValidMethods: []string{Algorithm}}
+	token, err := parser.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyIssuer(Issuer, true) || !claims.VerifyAudience(Audience, true) {
+		return nil, errors.New("not a permission token")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token does not expire")
+	}
+
+	ret := &Token{Permissions: make(map[int64][]string), Expires: time.Unix(int64(exp), 0)}
+	ret.Subject, _ = claims["sub"].(string)
+	organization, _ := claims[OrganizationClaim].(string)
+	if ret.OrganizationID, err = strconv.ParseInt(organization, 10, 64); err != nil {
+		return nil, errors.New("token has no organization")
+	}
+
+	permissions, ok := claims[PermissionsClaim].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("token has no permissions")
+	}
+	for k, v := range permissions {
+		organizationID, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid organization %q", k)
+		}
+		values, _ := v.([]interface{})
+		for _, p := range values {
+			if s, ok := p.(string); ok {
+				ret.Permissions[organizationID] = append(ret.Permissions[organizationID], s)
+			}
+		}
+	}
+	return ret, nil
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/condition"
 	"github.com/genesis32/complianceweb/utils"
 
 	"github.com/genesis32/complianceweb/dao"
@@ -74,6 +78,9 @@ func OrganizationApiPostHandler(t *dao.OrganizationUser, s *Server, store sessio
 			c.String(http.StatusUnauthorized, "not authorized")
 			return nil
 		}
+		if deferred := deferForDualControl(t, daoHandler, c, createRequest.ParentOrganizationID, OrganizationCreatePermission); deferred != nil {
+			return deferred
+		}
 	} else if createRequest.ParentOrganizationID == 0 {
 		// Only a person with system permission is allowed to create a root of a new tree
 		hasPermission := daoHandler.DoesUserHaveSystemPermission(t.ID, SystemOrganizationCreatePermission)
@@ -188,15 +195,75 @@ func UserAPIPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store
 			return nil
 		}
 	}
+	// System admins create the first users of an organization, everyone else only hands out permissions it holds.
+	if exceedsPrivilegeCeiling(t, daoHandler, c, addRequest.ParentOrganizationID, addRequest.RoleNames) {
+		return nil
+	}
+	if addRequest.ParentOrganizationID != 0 {
+		if deferred := deferForDualControl(t, daoHandler, c, addRequest.ParentOrganizationID, UserCreatePermission); deferred != nil {
+			return deferred
+		}
+	}
+
+	// The new user holds no other roles, so only the requested roles can conflict with each other.
+	if err := daoHandler.CheckSeparationOfDutyForRoles(addRequest.ParentOrganizationID, addRequest.RoleNames); errors.Is(err, dao.ErrSeparationOfDuty) {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
+
+	if addRequest.CreateCredential {
+		// A service principal has no one to accept an invite, it gets an api key straight away.
+		if addRequest.ParentOrganizationID == 0 {
+			c.String(http.StatusBadRequest, "service principals must belong to an organization")
+			return nil
+		}
+		lifetime, err := apiKeyLifetime(addRequest.CredentialExpiresInDays)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return nil
+		}
+		if len(addRequest.CredentialScopes) == 0 {
+			c.String(http.StatusBadRequest, "api keys need at least one scope.")
+			return nil
+		}
+		if !daoHandler.HasValidPermissions(addRequest.CredentialScopes) {
+			c.String(http.StatusBadRequest, "contains at least one invalid scope.")
+			return nil
+		}
+
+		userId := daoHandler.CreateServicePrincipal(addRequest.ParentOrganizationID, addRequest.Name, auth.LocalIssuer)
+		if err := daoHandler.SetRolesToUser(addRequest.ParentOrganizationID, userId, addRequest.RoleNames); err != nil {
+			c.String(http.StatusConflict, err.Error())
+			return nil
+		}
+
+		apiKey, key, err := issueAPIKey(daoHandler, userId, addRequest.CredentialScopes, lifetime)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "error generating key")
+			return nil
+		}
+
+		c.JSON(http.StatusCreated, &AddUserToOrganizationResponse{UserID: userId, Credentials: key})
+		return &WebAppOperationResult{
+			AuditMetadata:      WebappOperationMetadata{"userID": userId, "organizationID": addRequest.ParentOrganizationID, "roleNames": addRequest.RoleNames, "apiKeyID": apiKey.ID, "scopes": addRequest.CredentialScopes},
+			AuditHumanReadable: fmt.Sprintf("created service principal %d with roles %v in organization %d and api key %d", userId, addRequest.RoleNames, addRequest.ParentOrganizationID, apiKey.ID),
+		}
+	}
 
 	userId, inviteCode := daoHandler.CreateInviteForUser(addRequest.ParentOrganizationID, addRequest.Name)
 
-	daoHandler.SetRolesToUser(addRequest.ParentOrganizationID, userId, addRequest.RoleNames)
+	if err := daoHandler.SetRolesToUser(addRequest.ParentOrganizationID, userId, addRequest.RoleNames); err != nil {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
 
 	href := createInviteLink("", inviteCode, daoHandler)
 	r := &AddUserToOrganizationResponse{InviteCode: inviteCode, Href: href, UserID: userId}
 	c.JSON(http.StatusCreated, r)
-	return nil
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": userId, "organizationID": addRequest.ParentOrganizationID, "roleNames": addRequest.RoleNames},
+		AuditHumanReadable: fmt.Sprintf("invited user %d with roles %v in organization %d", userId, addRequest.RoleNames, addRequest.ParentOrganizationID),
+	}
 }
 
 func OrganizationMetadataApiPutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
@@ -215,6 +282,9 @@ func OrganizationMetadataApiPutHandler(t *dao.OrganizationUser, s *Server, store
 		c.String(http.StatusUnauthorized, "not authorized")
 		return nil
 	}
+	if deferred := deferForDualControl(t, handler, c, organizationID, OrganizationCreatePermission); deferred != nil {
+		return deferred
+	}
 
 	handler.UpdateOrganizationMetadata(organizationID, metadataUpdateRequest.Metadata)
 	return nil
@@ -251,6 +321,84 @@ func OrganizationMetadataApiGetHandler(t *dao.OrganizationUser, s *Server, store
 	return auditRecord
 }
 
+// roleInheritances maps the Inheritance of UserOrgRoles to the dao.RoleInheritance values.
+var roleInheritances = map[string]int{
+	"":             dao.RoleInheritanceSubtree,
+	"organization": dao.RoleInheritanceOrganization,
+	"descendants":  dao.RoleInheritanceDescendants,
+}
+
+func roleInheritanceName(inheritance int) string {
+	for name, i := range roleInheritances {
+		if i == inheritance {
+			return name
+		}
+	}
+	return ""
+}
+
+// newUserOrgRoles lists the roles a user has in an organization, roles with different validity windows,
+// inheritance or conditions are listed separately.
+func newUserOrgRoles(organizationID int64, roles []dao.Role) []UserOrgRoles {
+	ret := make([]UserOrgRoles, 0, 1)
+	for _, r := range roles {
+		i := 0
+		for ; i < len(ret); i++ {
+			if timeEqual(ret[i].ValidFrom, r.ValidFrom) && timeEqual(ret[i].ValidUntil, r.ValidUntil) && ret[i].Inheritance == roleInheritanceName(r.Inheritance) &&
+				ret[i].Condition == r.Condition && ret[i].ElevationRequestID == r.ElevationRequestID {
+				break
+			}
+		}
+		if i == len(ret) {
+			ret = append(ret, UserOrgRoles{OrganizationID: organizationID, ValidFrom: optionalTime(r.ValidFrom), ValidUntil: optionalTime(r.ValidUntil), Inheritance: roleInheritanceName(r.Inheritance), Condition: r.Condition, ElevationRequestID: r.ElevationRequestID})
+		}
+		ret[i].RoleNames = append(ret[i].RoleNames, r.DisplayName)
+	}
+	return ret
+}
+
+// optionalTime returns nil for the zero time so it is left out of responses.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeEqual(a *time.Time, b time.Time) bool {
+	if a == nil {
+		return b.IsZero()
+	}
+	return a.Equal(b)
+}
+
+// isBootstrapEnabled returns true while the system is being set up, until a System Admin accepted its invite. The
+// bootstrap setting stays on afterwards, so it isn't enough on its own.
+func isBootstrapEnabled(handler dao.DaoHandler) bool {
+	configKeys := handler.GetSettings(BootstrapConfigurationKey)
+	return len(configKeys) > 0 && configKeys[BootstrapConfigurationKey].Value == "true" &&
+		!handler.DoesAnyActiveUserHaveSystemPermission(SystemUpdatePermission)
+}
+
+// isPrivilegeCeilingExempt returns true if the caller can grant roles with permissions it doesn't hold, i.e. it is a
+// System Admin or the system is being bootstrapped.
+func isPrivilegeCeilingExempt(t *dao.OrganizationUser, handler dao.DaoHandler) bool {
+	return isBootstrapEnabled(handler) || handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission)
+}
+
+// exceedsPrivilegeCeiling writes the error response and returns true if the caller would grant roles with
+// permissions it doesn't hold in the organization, see isPrivilegeCeilingExempt.
+func exceedsPrivilegeCeiling(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context, organizationID int64, roleNames []string) bool {
+	if isPrivilegeCeilingExempt(t, handler) {
+		return false
+	}
+	if exceeding := handler.RolesExceedingUserPermissions(t.ID, organizationID, roleNames); len(exceeding) > 0 {
+		c.String(http.StatusForbidden, fmt.Sprintf("not allowed to grant permissions you don't hold: %s", strings.Join(exceeding, ", ")))
+		return true
+	}
+	return false
+}
+
 func UserRoleApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
 	var rolesUpdateRequest SetRolesForUserRequest
 
@@ -262,39 +410,91 @@ func UserRoleApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.S
 	userIDStr := c.Param("userID")
 	userID, _ := utils.StringToInt64(userIDStr)
 
+	if userID == t.ID && !isBootstrapEnabled(handler) {
+		c.String(http.StatusBadRequest, "not allowed to change your own roles")
+		return nil
+	}
+
+	// Elevated roles are listed when roles are read so the response can be sent back, they are left as they are.
+	roles := make([]UserOrgRoles, 0, len(rolesUpdateRequest.Roles))
 	for _, r := range rolesUpdateRequest.Roles {
+		if r.ElevationRequestID == 0 {
+			roles = append(roles, r)
+		}
+	}
+
+	for _, r := range roles {
+		if r.ValidUntil != nil && (r.ValidUntil.Before(time.Now()) || (r.ValidFrom != nil && !r.ValidUntil.After(*r.ValidFrom))) {
+			c.String(http.StatusBadRequest, "ValidUntil must be in the future and after ValidFrom")
+			return nil
+		}
+		if _, ok := roleInheritances[r.Inheritance]; !ok {
+			c.String(http.StatusBadRequest, "Inheritance must be organization or descendants")
+			return nil
+		}
+		if r.Condition != "" {
+			if _, err := condition.Compile(r.Condition); err != nil {
+				c.String(http.StatusBadRequest, fmt.Sprintf("invalid condition: %s", err.Error()))
+				return nil
+			}
+		}
 		// Make sure the userID has visibility to this org
 		userCanView := handler.CanUserViewOrg(userID, r.OrganizationID)
 		if !userCanView {
 			c.String(http.StatusUnauthorized, "not authorized")
+			return nil
 		}
 		// Make sure the caller has permission to assign the role to this user.
 		hasPermission := handler.DoesUserHavePermission(t.ID, r.OrganizationID, UserUpdatePermission)
 		if !hasPermission {
 			c.String(http.StatusUnauthorized, "not authorized")
+			return nil
 		}
 		// Make sure all roles passed in are valid
 		if !handler.HasValidRoles(r.RoleNames) {
 			c.String(http.StatusBadRequest, "contains at least one invalid role.")
 			return nil
 		}
+		if exceedsPrivilegeCeiling(t, handler, c, r.OrganizationID, r.RoleNames) {
+			return nil
+		}
 	}
 
-	for _, r := range rolesUpdateRequest.Roles {
-		handler.SetRolesToUser(r.OrganizationID, userID, r.RoleNames)
+	deferred := make(map[int64]bool)
+	for _, r := range roles {
+		if deferred[r.OrganizationID] {
+			continue
+		}
+		deferred[r.OrganizationID] = true
+		if operation := deferForDualControl(t, handler, c, r.OrganizationID, UserUpdatePermission); operation != nil {
+			return operation
+		}
+	}
+
+	// The roles of an organization are replaced by all of its entries, so it can hold roles with different windows.
+	assignments := make([]*dao.RoleAssignment, 0, len(roles))
+	for _, r := range roles {
+		a := &dao.RoleAssignment{OrganizationID: r.OrganizationID, RoleNames: r.RoleNames, Inheritance: roleInheritances[r.Inheritance], Condition: r.Condition}
+		if r.ValidFrom != nil {
+			a.ValidFrom = *r.ValidFrom
+		}
+		if r.ValidUntil != nil {
+			a.ValidUntil = *r.ValidUntil
+		}
+		assignments = append(assignments, a)
+	}
+	if err := handler.SetRoleAssignmentsToUser(userID, assignments); errors.Is(err, dao.ErrSeparationOfDuty) {
+		c.String(http.StatusConflict, err.Error())
+		return nil
 	}
 	return nil
 }
 
 func MeApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
 	organizationUser := handler.LoadUserFromID(t.ID)
-	response := GetOrganizationUserResponse{ID: organizationUser.ID, DisplayName: organizationUser.DisplayName, Active: organizationUser.CurrentState == dao.UserActiveState}
+	response := GetOrganizationUserResponse{ID: organizationUser.ID, DisplayName: organizationUser.DisplayName, Active: organizationUser.CurrentState == dao.UserActiveState, ServicePrincipal: organizationUser.UserType == dao.ServiceUserType}
 	for orgID, roles := range organizationUser.UserRoles {
-		var roleNames []string
-		for _, r := range roles {
-			roleNames = append(roleNames, r.DisplayName)
-		}
-		response.Roles = append(response.Roles, UserOrgRoles{OrganizationID: orgID, RoleNames: roleNames})
+		response.Roles = append(response.Roles, newUserOrgRoles(orgID, roles)...)
 	}
 	c.JSON(http.StatusOK, response)
 	return nil
@@ -343,6 +543,11 @@ func UserApiPutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store,
 			return nil
 		}
 	}
+	for _, oid := range organizationUser.Organizations {
+		if deferred := deferForDualControl(t, handler, c, oid, UserUpdatePermission); deferred != nil {
+			return deferred
+		}
+	}
 
 	switch {
 	case userUpdateRequest.Active && (dao.UserDeactiveState == organizationUser.CurrentState):
@@ -371,17 +576,13 @@ func UserApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store,
 	userID, _ := utils.StringToInt64(userIDStr)
 
 	organizationUser := handler.LoadUserFromID(userID)
-	response := GetOrganizationUserResponse{ID: organizationUser.ID, DisplayName: organizationUser.DisplayName, Active: organizationUser.CurrentState == dao.UserActiveState}
+	response := GetOrganizationUserResponse{ID: organizationUser.ID, DisplayName: organizationUser.DisplayName, Active: organizationUser.CurrentState == dao.UserActiveState, ServicePrincipal: organizationUser.UserType == dao.ServiceUserType}
 	for orgID, roles := range organizationUser.UserRoles {
 		// don't return roles belonging to orgs the user isn't part of
 		if !handler.CanUserViewOrg(t.ID, orgID) {
 			continue
 		}
-		var roleNames []string
-		for _, r := range roles {
-			roleNames = append(roleNames, r.DisplayName)
-		}
-		response.Roles = append(response.Roles, UserOrgRoles{OrganizationID: orgID, RoleNames: roleNames})
+		response.Roles = append(response.Roles, newUserOrgRoles(orgID, roles)...)
 	}
 	c.JSON(http.StatusOK, response)
 	return nil
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+)
+
+func TestNewUserOrgRoles(t *testing.T) {
+	until := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	roles := []dao.Role{
+		{DisplayName: "Reader"},
+		{DisplayName: "Writer"},
+		{DisplayName: "Auditor", ValidUntil: until},
+		{DisplayName: "Organization Admin", ValidUntil: until, ElevationRequestID: 7},
+	}
+	got := newUserOrgRoles(10, roles)
+	if len(got) != 3 {
+		t.Fatalf("expected one entry per window and elevation, got %+v", got)
+	}
+	if len(got[0].RoleNames) != 2 || got[0].ValidUntil != nil {
+		t.Errorf("unbounded roles %+v", got[0])
+	}
+	if got[1].RoleNames[0] != "Auditor" || !got[1].ValidUntil.Equal(until) || got[1].ElevationRequestID != 0 {
+		t.Errorf("time bound roles %+v", got[1])
+	}
+	if got[2].RoleNames[0] != "Organization Admin" || got[2].ElevationRequestID != 7 {
+		t.Errorf("elevated roles %+v", got[2])
+	}
+}
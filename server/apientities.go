@@ -1,5 +1,7 @@
 package server
 
+import "time"
+
 // BootstrapRequest contains initial information to make the app ready for use.
 type BootstrapRequest struct {
 	SystemAdminName string
@@ -27,19 +29,22 @@ type OrganizationCreateResponse struct {
 }
 
 type GetOrganizationUserResponse struct {
-	ID          int64 `json:",string,omitempty"`
-	DisplayName string
-	Roles       []UserOrgRoles
-	Active      bool
+	ID               int64 `json:",string,omitempty"`
+	DisplayName      string
+	Roles            []UserOrgRoles
+	Active           bool
+	ServicePrincipal bool
 }
 
 // AddUserToOrganizationRequest adds a user to an organization.
 // TODO(ddmassey): Do we maybe need to break them out by type?
 type AddUserToOrganizationRequest struct {
-	Name                 string
-	ParentOrganizationID int64 `json:",string,omitempty"`
-	RoleNames            []string
-	CreateCredential     bool
+	Name                    string
+	ParentOrganizationID    int64 `json:",string,omitempty"`
+	RoleNames               []string
+	CreateCredential        bool
+	CredentialScopes        []string
+	CredentialExpiresInDays int
 }
 
 // AddUserToOrganizationResponse is the response the server returns.
@@ -50,10 +55,22 @@ type AddUserToOrganizationResponse struct {
 	Credentials string `json:",,omitempty"`
 }
 
-// UserOrgRoles provides the role names a user has an organization.
+// UserOrgRoles provides the role names a user has an organization. Roles with a ValidFrom or ValidUntil are only
+// held within that window.
 type UserOrgRoles struct {
 	OrganizationID int64 `json:",string,omitempty"`
 	RoleNames      []string
+	ValidFrom      *time.Time `json:",omitempty"`
+	ValidUntil     *time.Time `json:",omitempty"`
+	// Inheritance is "organization" or "descendants" for roles that only apply there, by default they apply to
+	// the organization and its descendants.
+	Inheritance string `json:",omitempty"`
+	// Condition is an expression over the request that must hold for the roles to apply, e.g.
+	// organization.metadata.region == 'eu'.
+	Condition string `json:",omitempty"`
+	// ElevationRequestID is set for roles an approved elevation request granted. They end with the request and
+	// are ignored when roles are set.
+	ElevationRequestID int64 `json:",string,omitempty"`
 }
 
 type UserUpdateRequest struct {
@@ -75,3 +92,271 @@ type OrganizationMetadataUpdateRequest struct {
 type OrganizationMetadataResponse struct {
 	Metadata map[string]interface{}
 }
+
+// CreateAPIKeyRequest creates a new api key for a service principal. A key without scopes can use every
+// permission the service principal has.
+type CreateAPIKeyRequest struct {
+	Scopes        []string
+	ExpiresInDays int
+}
+
+// APIKeyResponse describes an api key, Key is only ever returned when the key is created.
+type APIKeyResponse struct {
+	ID       int64  `json:",string,omitempty"`
+	Key      string `json:",omitempty"`
+	Scopes   []string
+	Active   bool
+	Created  time.Time
+	Expires  time.Time
+	LastUsed *time.Time `json:",omitempty"`
+}
+
+// TokenResponse is the successful response of the token endpoint, its field names are fixed by RFC 6749.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// TokenErrorResponse is the error response of the token endpoint.
+type TokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// PermissionTokenRequest mints a permission token for a user, UserID defaults to the caller.
+type PermissionTokenRequest struct {
+	UserID         int64 `json:",string,omitempty"`
+	OrganizationID int64 `json:",string,omitempty"`
+}
+
+// PermissionTokenResponse contains a signed token that can be verified with the permissiontoken package.
+type PermissionTokenResponse struct {
+	Token   string
+	Expires time.Time
+}
+
+// RevokeUserSessionsResponse contains how many sessions were still active when they were revoked.
+type RevokeUserSessionsResponse struct {
+	Revoked int64
+}
+
+// LinkIdentityRequest links the account an id token from Provider was issued to.
+type LinkIdentityRequest struct {
+	Provider string
+	IDToken  string
+}
+
+// UserIdentityResponse describes an account at an identity provider a user can log in with.
+type UserIdentityResponse struct {
+	ID      int64 `json:",string,omitempty"`
+	Type    string
+	Issuer  string
+	Subject string
+	Created time.Time
+}
+
+// CreateUserGroupRequest creates a group in the organization in the path.
+type CreateUserGroupRequest struct {
+	DisplayName string
+}
+
+// UserGroupMembers lists the users and the nested groups that are members of a group.
+type UserGroupMembers struct {
+	UserIDs  []string
+	GroupIDs []string
+}
+
+// UserGroupResponse describes a group, Members and Roles are only returned when a single group is loaded.
+type UserGroupResponse struct {
+	ID             int64 `json:",string,omitempty"`
+	OrganizationID int64 `json:",string,omitempty"`
+	DisplayName    string
+	Members        *UserGroupMembers `json:",omitempty"`
+	Roles          []UserOrgRoles    `json:",omitempty"`
+	Created        time.Time
+}
+
+// SetRolesForUserGroupRequest replaces the roles of a group in each of the organizations.
+type SetRolesForUserGroupRequest struct {
+	Roles []UserOrgRoles
+}
+
+// CreateElevationRequest asks for a role in an organization for a limited time, DurationMinutes defaults to
+// DefaultElevationMinutes.
+type CreateElevationRequest struct {
+	OrganizationID  int64 `json:",string,omitempty"`
+	RoleName        string
+	DurationMinutes int
+	Justification   string
+}
+
+// ElevationDecisionRequest approves or denies an elevation request.
+type ElevationDecisionRequest struct {
+	Reason string
+}
+
+// ElevationResponse describes an elevation request, Expires is set once it is approved.
+type ElevationResponse struct {
+	ID              int64 `json:",string,omitempty"`
+	UserID          int64 `json:",string,omitempty"`
+	OrganizationID  int64 `json:",string,omitempty"`
+	RoleName        string
+	DurationMinutes int
+	Justification   string
+	State           string
+	ApproverID      int64 `json:",string,omitempty"`
+	DecisionReason  string
+	Requested       time.Time
+	Decided         *time.Time `json:",omitempty"`
+	Expires         *time.Time `json:",omitempty"`
+}
+
+// DualControlPoliciesRequest replaces the permissions under dual control in an organization and its descendants.
+type DualControlPoliciesRequest struct {
+	Permissions []string
+}
+
+// DualControlPolicy lists the permissions an organization's policy puts under dual control.
+type DualControlPolicy struct {
+	OrganizationID int64 `json:",string,omitempty"`
+	Permissions    []string
+}
+
+// PendingOperationDecisionRequest approves or denies a pending operation.
+type PendingOperationDecisionRequest struct {
+	Reason string
+}
+
+// PendingOperationResponse describes an operation waiting for, or decided by, a second user. ResultStatus and
+// Result are the response the operation got once it was approved and executed.
+type PendingOperationResponse struct {
+	ID             int64 `json:",string,omitempty"`
+	UserID         int64 `json:",string,omitempty"`
+	OrganizationID int64 `json:",string,omitempty"`
+	Permission     string
+	Method         string
+	Path           string
+	Body           string
+	State          string
+	ApproverID     int64 `json:",string,omitempty"`
+	DecisionReason string
+	Requested      time.Time
+	Decided        *time.Time `json:",omitempty"`
+	ResultStatus   int        `json:",omitempty"`
+	Result         string     `json:",omitempty"`
+}
+
+// BreakGlassAccountRequest designates a user as, or stops it being, a break glass account.
+type BreakGlassAccountRequest struct {
+	Designated bool
+}
+
+// BreakGlassRequest breaks the glass, a reason is required.
+type BreakGlassRequest struct {
+	Reason string
+}
+
+// BreakGlassReviewRequest records the post incident review of a break glass access.
+type BreakGlassReviewRequest struct {
+	Notes string
+}
+
+// BreakGlassResponse describes a break glass access and its review.
+type BreakGlassResponse struct {
+	ID          int64 `json:",string,omitempty"`
+	UserID      int64 `json:",string,omitempty"`
+	Reason      string
+	Activated   time.Time
+	Expires     time.Time
+	ReviewerID  int64 `json:",string,omitempty"`
+	ReviewNotes string
+	Reviewed    *time.Time `json:",omitempty"`
+}
+
+// SeparationOfDutyRequest makes two roles mutually exclusive for the same user in an organization and its
+// descendants.
+type SeparationOfDutyRequest struct {
+	RoleName            string
+	ConflictingRoleName string
+	Dynamic             bool
+}
+
+// SeparationOfDutyResponse describes a separation of duty constraint.
+type SeparationOfDutyResponse struct {
+	ID                  int64 `json:",string,omitempty"`
+	OrganizationID      int64 `json:",string,omitempty"`
+	RoleName            string
+	ConflictingRoleName string
+	Dynamic             bool
+	Created             time.Time
+}
+
+// SeparationOfDutyViolationResponse is a user holding both roles of a constraint, each in the listed organization.
+type SeparationOfDutyViolationResponse struct {
+	SeparationOfDutyID        int64 `json:",string,omitempty"`
+	Dynamic                   bool
+	UserID                    int64 `json:",string,omitempty"`
+	OrganizationID            int64 `json:",string,omitempty"`
+	RoleName                  string
+	ConflictingOrganizationID int64 `json:",string,omitempty"`
+	ConflictingRoleName       string
+}
+
+// PermissionDenyRequest denies a permission to a user or the members of a group, exactly one of UserID and
+// GroupID is set.
+type PermissionDenyRequest struct {
+	UserID     int64 `json:",string,omitempty"`
+	GroupID    int64 `json:",string,omitempty"`
+	Permission string
+}
+
+// PermissionDenyResponse describes a deny.
+type PermissionDenyResponse struct {
+	ID             int64 `json:",string,omitempty"`
+	OrganizationID int64 `json:",string,omitempty"`
+	UserID         int64 `json:",string,omitempty"`
+	GroupID        int64 `json:",string,omitempty"`
+	Permission     string
+	Created        time.Time
+}
+
+// PermissionGrantResponse is a role granting the explained permission in an organization. A grant with a Condition
+// only Applies when the condition holds for the explain request.
+type PermissionGrantResponse struct {
+	OrganizationID int64 `json:",string,omitempty"`
+	RoleName       string
+	Permission     string
+	Condition      string `json:",omitempty"`
+	Applies        bool
+}
+
+// PermissionExplanationResponse explains whether a user has a permission in an organization.
+type PermissionExplanationResponse struct {
+	UserID         int64 `json:",string,omitempty"`
+	OrganizationID int64 `json:",string,omitempty"`
+	Permission     string
+	Allowed        bool
+	Grants         []*PermissionGrantResponse
+	Denies         []*PermissionDenyResponse
+}
+
+// ImportChange is a change a manifest import makes. Kind is organization or user for the ones it creates, and
+// metadata or roles for the ones it updates. InviteCode and Href are set for invited users once imported.
+type ImportChange struct {
+	Action       string
+	Kind         string
+	Organization string
+	UserName     string   `json:",omitempty"`
+	ID           int64    `json:",string,omitempty"`
+	RoleNames    []string `json:",omitempty"`
+	InviteCode   int64    `json:",string,omitempty"`
+	Href         string   `json:",omitempty"`
+}
+
+// ImportResponse lists the changes of an import, or those it would make on a dry run.
+type ImportResponse struct {
+	DryRun  bool
+	Changes []*ImportChange
+}
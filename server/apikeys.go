@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// Bounds on how long an api key is valid for.
+const (
+	DefaultAPIKeyExpiresInDays = 90
+	MaxAPIKeyExpiresInDays     = 365
+)
+
+// issueAPIKey creates a new key for the service principal and returns the key which is never stored.
+func issueAPIKey(handler dao.DaoHandler, userID int64, scopes []string, lifetime time.Duration) (*dao.APIKey, string, error) {
+	apiKey := &dao.APIKey{
+		ID:                 utils.GetNextUniqueId(),
+		OrganizationUserID: userID,
+		Scopes:             scopes,
+		CurrentState:       dao.APIKeyActiveState,
+		CreatedTimestamp:   time.Now().UTC(),
+	}
+	apiKey.ExpirationTimestamp = apiKey.CreatedTimestamp.Add(lifetime)
+
+	key, keyHash, err := auth.GenerateAPIKey(apiKey.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey.KeyHash = keyHash
+
+	handler.CreateAPIKey(apiKey)
+	return apiKey, key, nil
+}
+
+func apiKeyLifetime(expiresInDays int) (time.Duration, error) {
+	if expiresInDays == 0 {
+		expiresInDays = DefaultAPIKeyExpiresInDays
+	}
+	if expiresInDays < 0 || expiresInDays > MaxAPIKeyExpiresInDays {
+		return 0, fmt.Errorf("expiration must be between 1 and %d days", MaxAPIKeyExpiresInDays)
+	}
+	return time.Duration(expiresInDays) * 24 * time.Hour, nil
+}
+
+func newAPIKeyResponse(apiKey *dao.APIKey, key string) *APIKeyResponse {
+	ret := &APIKeyResponse{
+		ID:      apiKey.ID,
+		Key:     key,
+		Scopes:  apiKey.Scopes,
+		Active:  apiKey.CurrentState == dao.APIKeyActiveState && time.Now().UTC().Before(apiKey.ExpirationTimestamp),
+		Created: apiKey.CreatedTimestamp,
+		Expires: apiKey.ExpirationTimestamp,
+	}
+	if !apiKey.LastUsedTimestamp.IsZero() {
+		lastUsed := apiKey.LastUsedTimestamp
+		ret.LastUsed = &lastUsed
+	}
+	return ret
+}
+
+// loadManagedUser loads the user in the userID param if the caller can update it in every organization it
+// belongs to, otherwise it writes the error response and returns nil.
+func loadManagedUser(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context) *dao.OrganizationUser {
+	userID, err := utils.StringToInt64(c.Param("userID"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "user invalid ID")
+		return nil
+	}
+
+	organizationUser := handler.LoadUserFromID(userID)
+	if organizationUser == nil || len(organizationUser.Organizations) == 0 {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	for _, oid := range organizationUser.Organizations {
+		if !handler.DoesUserHavePermission(t.ID, oid, UserUpdatePermission) {
+			c.String(http.StatusUnauthorized, "not authorized")
+			return nil
+		}
+	}
+	return organizationUser
+}
+
+// loadManagedServicePrincipal is loadManagedUser for users that must be service principals.
+func loadManagedServicePrincipal(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context) *dao.OrganizationUser {
+	organizationUser := loadManagedUser(t, handler, c)
+	if organizationUser == nil {
+		return nil
+	}
+
+	if organizationUser.UserType != dao.ServiceUserType {
+		c.String(http.StatusBadRequest, "api keys can only be issued to service principals")
+		return nil
+	}
+	return organizationUser
+}
+
+// APIKeyApiPostHandler issues a new api key to a service principal.
+func APIKeyApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var createRequest CreateAPIKeyRequest
+	if err := c.ShouldBind(&createRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("api key format: %s", err.Error()))
+		return nil
+	}
+
+	lifetime, err := apiKeyLifetime(createRequest.ExpiresInDays)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	if len(createRequest.Scopes) == 0 {
+		c.String(http.StatusBadRequest, "api keys need at least one scope.")
+		return nil
+	}
+	if !handler.HasValidPermissions(createRequest.Scopes) {
+		c.String(http.StatusBadRequest, "contains at least one invalid scope.")
+		return nil
+	}
+
+	servicePrincipal := loadManagedServicePrincipal(t, handler, c)
+	if servicePrincipal == nil {
+		return nil
+	}
+
+	apiKey, key, err := issueAPIKey(handler, servicePrincipal.ID, createRequest.Scopes, lifetime)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "error generating key")
+		return nil
+	}
+
+	c.JSON(http.StatusCreated, newAPIKeyResponse(apiKey, key))
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": servicePrincipal.ID, "apiKeyID": apiKey.ID},
+		AuditHumanReadable: fmt.Sprintf("created api key %d for service principal %d", apiKey.ID, servicePrincipal.ID),
+	}
+}
+
+// APIKeyApiGetHandler lists the api keys of a service principal without their secrets.
+func APIKeyApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	servicePrincipal := loadManagedServicePrincipal(t, handler, c)
+	if servicePrincipal == nil {
+		return nil
+	}
+
+	response := make([]*APIKeyResponse, 0)
+	for _, k := range handler.LoadAPIKeysForUser(servicePrincipal.ID) {
+		response = append(response, newAPIKeyResponse(k, ""))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// APIKeyRotateApiPostHandler replaces an active key with a new one with the same scopes and lifetime.
+func APIKeyRotateApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	servicePrincipal := loadManagedServicePrincipal(t, handler, c)
+	if servicePrincipal == nil {
+		return nil
+	}
+
+	keyID, _ := utils.StringToInt64(c.Param("keyID"))
+	oldKey := handler.LoadAPIKey(keyID)
+	if oldKey == nil || oldKey.OrganizationUserID != servicePrincipal.ID || oldKey.CurrentState != dao.APIKeyActiveState {
+		c.String(http.StatusNotFound, "api key not found")
+		return nil
+	}
+
+	if !handler.RevokeAPIKey(servicePrincipal.ID, oldKey.ID) {
+		c.String(http.StatusConflict, "api key already revoked")
+		return nil
+	}
+
+	apiKey, key, err := issueAPIKey(handler, servicePrincipal.ID, oldKey.Scopes, oldKey.ExpirationTimestamp.Sub(oldKey.CreatedTimestamp))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "error generating key")
+		return nil
+	}
+
+	c.JSON(http.StatusCreated, newAPIKeyResponse(apiKey, key))
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": servicePrincipal.ID, "apiKeyID": apiKey.ID, "revokedApiKeyID": oldKey.ID},
+		AuditHumanReadable: fmt.Sprintf("rotated api key %d to %d for service principal %d", oldKey.ID, apiKey.ID, servicePrincipal.ID),
+	}
+}
+
+// APIKeyApiDeleteHandler revokes an api key.
+func APIKeyApiDeleteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	servicePrincipal := loadManagedServicePrincipal(t, handler, c)
+	if servicePrincipal == nil {
+		return nil
+	}
+
+	keyID, _ := utils.StringToInt64(c.Param("keyID"))
+	if !handler.RevokeAPIKey(servicePrincipal.ID, keyID) {
+		c.String(http.StatusNotFound, "api key not found")
+		return nil
+	}
+
+	c.Status(http.StatusOK)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": servicePrincipal.ID, "revokedApiKeyID": keyID},
+		AuditHumanReadable: fmt.Sprintf("revoked api key %d for service principal %d", keyID, servicePrincipal.ID),
+	}
+}
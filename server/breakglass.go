@@ -0,0 +1,220 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// Break glass accounts hold the BreakGlassRoleName role outside of any organization, breaking the glass gives
+// them BreakGlassGrantedRoleName for BreakGlassWindow.
+const (
+	BreakGlassRoleName        = "Break Glass"
+	BreakGlassGrantedRoleName = "System Admin"
+	BreakGlassWindow          = time.Hour
+)
+
+// BreakGlassAuditInternalKey is the internal key of the audit records that follow a break glass access from start
+// to review, so they can be watched and retained apart from everything else.
+const BreakGlassAuditInternalKey = "breakglass"
+
+func newBreakGlassResponse(access *dao.BreakGlassAccess) *BreakGlassResponse {
+	return &BreakGlassResponse{
+		ID:          access.ID,
+		UserID:      access.OrganizationUserID,
+		Reason:      access.Reason,
+		Activated:   access.ActivatedTimestamp,
+		Expires:     access.ExpirationTimestamp,
+		ReviewerID:  access.ReviewerID,
+		ReviewNotes: access.ReviewNotes,
+		Reviewed:    optionalTime(access.ReviewedTimestamp),
+	}
+}
+
+// announceBreakGlass writes the break glass audit record and logs it, in addition to the request's own record.
+func announceBreakGlass(s *Server, userID int64, method string, metadata WebappOperationMetadata, humanReadable string) *WebAppOperationResult {
+	log.Printf("BREAK GLASS: %s", humanReadable)
+	writeAuditRecord(s, BreakGlassAuditInternalKey, userID, 0, method, metadata, humanReadable)
+	return &WebAppOperationResult{AuditMetadata: metadata, AuditHumanReadable: humanReadable}
+}
+
+func breakGlassAuditMetadata(access *dao.BreakGlassAccess) WebappOperationMetadata {
+	return WebappOperationMetadata{
+		"breakGlassID": access.ID,
+		"userID":       access.OrganizationUserID,
+		"reason":       access.Reason,
+		"activated":    access.ActivatedTimestamp,
+		"expires":      access.ExpirationTimestamp,
+	}
+}
+
+// BreakGlassAccountApiPutHandler designates a user as a break glass account, or stops it being one.
+func BreakGlassAccountApiPutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var accountRequest BreakGlassAccountRequest
+	if err := c.ShouldBind(&accountRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("break glass account format: %s", err.Error()))
+		return nil
+	}
+
+	if !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	userID, _ := utils.StringToInt64(c.Param("userID"))
+	organizationUser := handler.LoadUserFromID(userID)
+	if organizationUser == nil {
+		c.String(http.StatusNotFound, "user not found")
+		return nil
+	}
+	if organizationUser.UserType == dao.ServiceUserType {
+		c.String(http.StatusBadRequest, "service principals can't be break glass accounts")
+		return nil
+	}
+
+	handler.SetSystemRoleToUser(userID, BreakGlassRoleName, accountRequest.Designated)
+	c.Status(http.StatusOK)
+
+	humanReadable := fmt.Sprintf("user %d is no longer a break glass account", userID)
+	if accountRequest.Designated {
+		humanReadable = fmt.Sprintf("designated user %d as a break glass account", userID)
+	}
+	return announceBreakGlass(s, userID, c.Request.Method, WebappOperationMetadata{"userID": userID, "designated": accountRequest.Designated}, humanReadable)
+}
+
+// BreakGlassApiPostHandler gives a break glass account System Admin for BreakGlassWindow. Every access must be
+// reviewed before the same user can break the glass again.
+func BreakGlassApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var breakGlassRequest BreakGlassRequest
+	if err := c.ShouldBind(&breakGlassRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("break glass format: %s", err.Error()))
+		return nil
+	}
+
+	if !handler.DoesUserHaveSystemPermission(t.ID, SystemBreakGlassPermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	breakGlassRequest.Reason = strings.TrimSpace(breakGlassRequest.Reason)
+	if breakGlassRequest.Reason == "" {
+		c.String(http.StatusBadRequest, "a reason is required")
+		return nil
+	}
+
+	now := time.Now().UTC()
+	access := &dao.BreakGlassAccess{
+		ID:                  utils.GetNextUniqueId(),
+		OrganizationUserID:  t.ID,
+		Reason:              breakGlassRequest.Reason,
+		ActivatedTimestamp:  now,
+		ExpirationTimestamp: now.Add(BreakGlassWindow),
+	}
+	if err := handler.ActivateBreakGlass(access, BreakGlassGrantedRoleName); errors.Is(err, dao.ErrBreakGlassUnreviewed) {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
+
+	c.JSON(http.StatusCreated, newBreakGlassResponse(access))
+
+	return announceBreakGlass(s, t.ID, c.Request.Method, breakGlassAuditMetadata(access),
+		fmt.Sprintf("user %d broke the glass and holds %s until %s: %s", t.ID, BreakGlassGrantedRoleName, access.ExpirationTimestamp.Format(time.RFC3339), access.Reason))
+}
+
+// BreakGlassApiGetHandler lists every break glass access.
+func BreakGlassApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	if !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	response := make([]*BreakGlassResponse, 0)
+	for _, access := range handler.LoadBreakGlassAccesses() {
+		response = append(response, newBreakGlassResponse(access))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// BreakGlassEndApiPostHandler ends an access before its window is over, either by the user that broke the glass
+// or a system admin.
+func BreakGlassEndApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	accessID, _ := utils.StringToInt64(c.Param("accessID"))
+	access := handler.LoadBreakGlassAccess(accessID)
+	if access == nil || (access.OrganizationUserID != t.ID && !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission)) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	if !handler.EndBreakGlassAccess(access, time.Now().UTC()) {
+		c.String(http.StatusConflict, "break glass access already ended")
+		return nil
+	}
+	c.JSON(http.StatusOK, newBreakGlassResponse(access))
+
+	return announceBreakGlass(s, access.OrganizationUserID, c.Request.Method, breakGlassAuditMetadata(access),
+		fmt.Sprintf("user %d ended break glass access %d of user %d", t.ID, access.ID, access.OrganizationUserID))
+}
+
+// BreakGlassReviewApiPostHandler records the post incident review of an access that ended. The reviewer needs
+// system.update.execute and can't review its own access.
+func BreakGlassReviewApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var reviewRequest BreakGlassReviewRequest
+	if err := c.ShouldBind(&reviewRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("review format: %s", err.Error()))
+		return nil
+	}
+
+	accessID, _ := utils.StringToInt64(c.Param("accessID"))
+	access := handler.LoadBreakGlassAccess(accessID)
+	if access == nil || !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	if access.OrganizationUserID == t.ID {
+		c.String(http.StatusBadRequest, "not allowed to review your own break glass access")
+		return nil
+	}
+	reviewRequest.Notes = strings.TrimSpace(reviewRequest.Notes)
+	if reviewRequest.Notes == "" {
+		c.String(http.StatusBadRequest, "review notes are required")
+		return nil
+	}
+
+	now := time.Now().UTC()
+	if access.ExpirationTimestamp.After(now) {
+		c.String(http.StatusConflict, "break glass access has not ended yet")
+		return nil
+	}
+
+	access.ReviewerID = t.ID
+	access.ReviewNotes = reviewRequest.Notes
+	access.ReviewedTimestamp = now
+	if err := handler.ReviewBreakGlassAccess(access); errors.Is(err, dao.ErrBreakGlassReviewed) {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
+	c.JSON(http.StatusOK, newBreakGlassResponse(access))
+
+	metadata := breakGlassAuditMetadata(access)
+	metadata["reviewerID"] = access.ReviewerID
+	metadata["reviewNotes"] = access.ReviewNotes
+	return announceBreakGlass(s, access.OrganizationUserID, c.Request.Method, metadata,
+		fmt.Sprintf("user %d reviewed break glass access %d of user %d: %s", t.ID, access.ID, access.OrganizationUserID, access.ReviewNotes))
+}
+
+// RemindBreakGlassReviewsJob logs every access that ended and is still waiting for its review.
+func RemindBreakGlassReviewsJob(s *Server, now time.Time) {
+	for _, access := range s.Dao.LoadBreakGlassAccesses() {
+		if access.ReviewedTimestamp.IsZero() && !access.ExpirationTimestamp.After(now) {
+			log.Printf("BREAK GLASS: access %d of user %d ended at %s and has not been reviewed", access.ID, access.OrganizationUserID, access.ExpirationTimestamp.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/genesis32/complianceweb/condition"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/gin-gonic/gin"
+)
+
+// conditionalDaoHandler also grants the permissions of conditional role assignments whose condition holds for the
+// request being handled. A condition that fails to compile or evaluate doesn't hold.
+type conditionalDaoHandler struct {
+	dao.DaoHandler
+	c *gin.Context
+}
+
+func newConditionalDaoHandler(daoHandler dao.DaoHandler, c *gin.Context) dao.DaoHandler {
+	return &conditionalDaoHandler{DaoHandler: daoHandler, c: c}
+}
+
+// remoteIP returns the address the request's connection comes from. Unlike ClientIP it ignores X-Forwarded-For and
+// X-Real-Ip, which any client can set.
+func remoteIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// conditionAttributes returns what a condition can refer to when userID acts on organizationID in the request.
+func conditionAttributes(handler dao.DaoHandler, c *gin.Context, userID, organizationID int64) map[string]interface{} {
+	now := time.Now().UTC()
+	metadata := map[string]interface{}(handler.LoadOrganizationMetadata(organizationID))
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	return map[string]interface{}{
+		"request": map[string]interface{}{
+			"ip":      remoteIP(c),
+			"method":  c.Request.Method,
+			"hour":    now.Hour(),
+			"weekday": int(now.Weekday()),
+		},
+		"organization": map[string]interface{}{
+			"id":       organizationID,
+			"metadata": metadata,
+		},
+		"user": map[string]interface{}{
+			"id": userID,
+		},
+	}
+}
+
+// anyConditionHolds returns true if one of the conditions holds when userID acts on organizationID in the request.
+func anyConditionHolds(handler dao.DaoHandler, c *gin.Context, userID, organizationID int64, conditions []string) bool {
+	if len(conditions) == 0 {
+		return false
+	}
+
+	attributes := conditionAttributes(handler, c, userID, organizationID)
+	for _, source := range conditions {
+		expression, err := condition.Compile(source)
+		if err != nil {
+			log.Printf("condition %q of user %d: %v", source, userID, err)
+			continue
+		}
+		if holds, err := expression.Evaluate(attributes); err != nil {
+			log.Printf("condition %q of user %d: %v", source, userID, err)
+		} else if holds {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *conditionalDaoHandler) DoesUserHavePermission(userID, organizationID int64, permission string) bool {
+	return d.DaoHandler.DoesUserHavePermission(userID, organizationID, permission) ||
+		anyConditionHolds(d.DaoHandler, d.c, userID, organizationID, d.DaoHandler.LoadPermissionGrantConditions(userID, organizationID, permission))
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/gin-gonic/gin"
+)
+
+// conditionDao grants user 2 its permissions in organization 10 only under the condition, every other method panics.
+type conditionDao struct {
+	dao.DaoHandler
+	condition string
+}
+
+func (d *conditionDao) DoesUserHavePermission(userID, organizationID int64, permission string) bool {
+	return false
+}
+
+func (d *conditionDao) LoadPermissionGrantConditions(userID, organizationID int64, permission string) []string {
+	return []string{d.condition}
+}
+
+func (d *conditionDao) LoadOrganizationMetadata(organizationID int64) dao.OrganizationMetadata {
+	return nil
+}
+
+func TestConditionIPIgnoresForwardingHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/organizations/10", nil)
+	c.Request.RemoteAddr = "192.0.2.1:4242"
+	c.Request.Header.Set("X-Forwarded-For", "10.0.0.1")
+	c.Request.Header.Set("X-Real-Ip", "10.0.0.1")
+
+	handler := newConditionalDaoHandler(&conditionDao{condition: "ipInRange(request.ip, '10.0.0.0/8')"}, c)
+	if handler.DoesUserHavePermission(2, 10, UserReadPermission) {
+		t.Error("forwarded address satisfied the condition")
+	}
+	handler = newConditionalDaoHandler(&conditionDao{condition: "ipInRange(request.ip, '192.0.2.0/24')"}, c)
+	if !handler.DoesUserHavePermission(2, 10, UserReadPermission) {
+		t.Error("remote address didn't satisfy the condition")
+	}
+}
@@ -1,22 +1,59 @@
 package server
 
+import (
+	"time"
+
+	"github.com/genesis32/complianceweb/auth"
+)
+
 // The keys in the settings table that corresponse to configuration.
 const (
 	BootstrapConfigurationKey               = "bootstrap.enabled"
 	CookieAuthenticationKeyConfigurationKey = "cookie.authentication.key"
 	CookieEncryptionKeyConfigurationKey     = "cookie.encryption.key"
-	OIDCIssuerBaseURLConfigurationKey       = "oidc.issuer.baseurl"
-	Auth0ClientIDConfigurationKey           = "oidc.auth0.clientid"
-	Auth0ClientSecretConfigurationKey       = "oidc.auth0.clientsecret"
+	OIDCIssuerBaseURLConfigurationKey       = "oidc.issuer.baseurl"     // Deprecated: use OIDCProviderConfigurationKeyPrefix
+	Auth0ClientIDConfigurationKey           = "oidc.auth0.clientid"     // Deprecated: use OIDCProviderConfigurationKeyPrefix
+	Auth0ClientSecretConfigurationKey       = "oidc.auth0.clientsecret" // Deprecated: use OIDCProviderConfigurationKeyPrefix
 	SystemBaseURLConfigurationKey           = "system.baseurl"
+	AuditArchiveDirectoryConfigurationKey   = "audit.archive.directory"
+	TokenLifetimeConfigurationKey           = "token.lifetime.minutes"
+	SigningKeyRotationConfigurationKey      = "token.signingkey.rotation.days"
+	SessionLifetimeConfigurationKey         = "session.lifetime.hours"
+	ScimProviderConfigurationKey            = "scim.provider"
 )
 
+// OIDCProviderConfigurationKeyPrefix is followed by a provider name and one of the OIDCProvider*ConfigurationKeySuffix
+// values, e.g. oidc.provider.okta.issuer. Any number of providers can be configured at once.
+const OIDCProviderConfigurationKeyPrefix = "oidc.provider."
+
+// The per provider keys in the settings table.
+const (
+	OIDCProviderTypeConfigurationKeySuffix         = ".type"
+	OIDCProviderIssuerConfigurationKeySuffix       = ".issuer"
+	OIDCProviderClientIDConfigurationKeySuffix     = ".clientid"
+	OIDCProviderClientSecretConfigurationKeySuffix = ".clientsecret"
+	OIDCProviderScopesConfigurationKeySuffix       = ".scopes"
+	OIDCProviderAlgorithmsConfigurationKeySuffix   = ".algorithms"
+)
+
+// AuditRetentionConfigurationKeyPrefix is followed by an audit internal key, its value is the
+// number of days records are kept before they are archived.
+const AuditRetentionConfigurationKeyPrefix = "audit.retention."
+
 // ServerConfiguration contains all the database configuration.
 type Configuration struct {
-	CookieAuthenticationKey []byte // TODO: Encrypt in database
-	CookieEncryptionKey     []byte // TODO: Encrypt in database
-	OIDCIssuer              string // TODO: Encrypt in database
-	Auth0ClientID           string // TODO: Encrypt in database
-	Auth0ClientSecret       string // TODO: Encrypt in database
+	CookieAuthenticationKey []byte                           // TODO: Encrypt in database
+	CookieEncryptionKey     []byte                           // TODO: Encrypt in database
+	OIDCProviders           []auth.OIDCProviderConfiguration // TODO: Encrypt in database
 	SystemBaseUrl           string
+	TokenLifetime           time.Duration
+	SigningKeyRotation      time.Duration
+	SessionLifetime         time.Duration
 }
+
+// Defaults for the access tokens the token endpoint issues.
+const (
+	DefaultTokenLifetimeMinutes     = 15
+	DefaultSigningKeyRotationInDays = 30
+	DefaultSessionLifetimeInHours   = 8
+)
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// Keys of values registerAPIA keeps in the gin context for deferForDualControl.
+const (
+	credentialScopeKey = "credential_scope"
+	requestBodyKey     = "request_body"
+)
+
+// pendingOperationLifetime is how long an operation can wait for approval.
+const pendingOperationLifetime = 7 * 24 * time.Hour
+
+// approvedOperationKey marks a request as the execution of an approved operation. It is only ever set on requests
+// the server makes to itself, so it can't be forged by a client.
+type approvedOperationKey struct{}
+
+var operationStateNames = map[int]string{
+	dao.OperationPendingState:  "pending",
+	dao.OperationApprovedState: "approved",
+	dao.OperationDeniedState:   "denied",
+	dao.OperationExpiredState:  "expired",
+}
+
+// approvedOperation returns the operation the request executes, or nil for requests made by clients.
+func approvedOperation(c *gin.Context) *dao.PendingOperation {
+	operation, _ := c.Request.Context().Value(approvedOperationKey{}).(*dao.PendingOperation)
+	return operation
+}
+
+func newPendingOperationResponse(operation *dao.PendingOperation) *PendingOperationResponse {
+	return &PendingOperationResponse{
+		ID:             operation.ID,
+		UserID:         operation.OrganizationUserID,
+		OrganizationID: operation.OrganizationID,
+		Permission:     operation.Permission,
+		Method:         operation.Method,
+		Path:           operation.RequestURI,
+		Body:           string(operation.Body),
+		State:          operationStateNames[operation.CurrentState],
+		ApproverID:     operation.ApproverID,
+		DecisionReason: operation.DecisionReason,
+		Requested:      operation.RequestedTimestamp,
+		Decided:        optionalTime(operation.DecidedTimestamp),
+		ResultStatus:   operation.ResultStatus,
+		Result:         operation.ResultBody,
+	}
+}
+
+func pendingOperationAuditResult(operation *dao.PendingOperation, action string) *WebAppOperationResult {
+	return &WebAppOperationResult{
+		AuditMetadata: WebappOperationMetadata{
+			"operationID":    operation.ID,
+			"userID":         operation.OrganizationUserID,
+			"organizationID": operation.OrganizationID,
+			"permission":     operation.Permission,
+			"method":         operation.Method,
+			"path":           operation.RequestURI,
+			"state":          operationStateNames[operation.CurrentState],
+		},
+		AuditHumanReadable: fmt.Sprintf("%s operation %d (%s %s) of user %d needing %s in organization %d",
+			action, operation.ID, operation.Method, operation.RequestURI, operation.OrganizationUserID, operation.Permission, operation.OrganizationID),
+	}
+}
+
+// deferForDualControl is called by a handler once the caller is authorized to use permission in organizationID.
+// If a dual control policy covers it the request is captured as a pending operation, the handler must return the
+// audit result without acting on the request. It returns nil when the handler can go ahead.
+func deferForDualControl(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context, organizationID int64, permission string) *WebAppOperationResult {
+	if !handler.RequiresDualControl(organizationID, permission) {
+		return nil
+	}
+	// The approver of the operation being executed only vouches for what it could have done itself.
+	if approved := approvedOperation(c); approved != nil && handler.DoesUserHavePermission(approved.ApproverID, organizationID, permission) {
+		return nil
+	}
+
+	var body []byte
+	if v, ok := c.Get(requestBodyKey); ok {
+		body = v.([]byte)
+	}
+	operation := &dao.PendingOperation{
+		ID:                 utils.GetNextUniqueId(),
+		OrganizationUserID: t.ID,
+		OrganizationID:     organizationID,
+		Permission:         permission,
+		Method:             c.Request.Method,
+		RequestURI:         c.Request.URL.RequestURI(),
+		ContentType:        c.GetHeader("Content-Type"),
+		Body:               body,
+		Scope:              c.GetString(credentialScopeKey),
+		RequestedTimestamp: time.Now().UTC(),
+	}
+	if approved := approvedOperation(c); approved != nil {
+		operation.Scope = approved.Scope
+	}
+	handler.CreatePendingOperation(operation)
+
+	c.JSON(http.StatusAccepted, newPendingOperationResponse(operation))
+	return pendingOperationAuditResult(operation, "captured")
+}
+
+// executePendingOperation replays an approved operation through the router as the user that requested it.
+func executePendingOperation(s *Server, operation *dao.PendingOperation) (int, string) {
+	req, err := http.NewRequest(operation.Method, operation.RequestURI, bytes.NewReader(operation.Body))
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	if operation.ContentType != "" {
+		req.Header.Set("Content-Type", operation.ContentType)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), approvedOperationKey{}, operation))
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+// DualControlPoliciesApiPutHandler replaces the permissions under dual control in an organization's subtree.
+func DualControlPoliciesApiPutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var policiesRequest DualControlPoliciesRequest
+	if err := c.ShouldBind(&policiesRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("policies format: %s", err.Error()))
+		return nil
+	}
+
+	if !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	if !handler.HasValidPermissions(policiesRequest.Permissions) {
+		c.String(http.StatusBadRequest, "contains at least one invalid permission.")
+		return nil
+	}
+
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	handler.SetDualControlPolicies(organizationID, policiesRequest.Permissions)
+	c.Status(http.StatusOK)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"organizationID": organizationID, "permissions": policiesRequest.Permissions},
+		AuditHumanReadable: fmt.Sprintf("set dual control permissions of organization %d to %v", organizationID, policiesRequest.Permissions),
+	}
+}
+
+// DualControlPoliciesApiGetHandler lists the permissions under dual control in an organization, including those
+// put there by the policies of its ancestors.
+func DualControlPoliciesApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.CanUserViewOrg(t.ID, organizationID) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	response := make([]DualControlPolicy, 0)
+	for orgID, permissions := range handler.LoadDualControlPolicies(organizationID) {
+		response = append(response, DualControlPolicy{OrganizationID: orgID, Permissions: permissions})
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// PendingOperationsApiGetHandler lists the operations in an organization and its descendants.
+func PendingOperationsApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.DoesUserHavePermission(t.ID, organizationID, UserReadPermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	response := make([]*PendingOperationResponse, 0)
+	for _, operation := range handler.LoadPendingOperationsInTree(organizationID) {
+		response = append(response, newPendingOperationResponse(operation))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// loadDecidablePendingOperation loads the operation in the operationID param if the caller could approve it.
+func loadDecidablePendingOperation(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context) *dao.PendingOperation {
+	operationID, _ := utils.StringToInt64(c.Param("operationID"))
+	operation := handler.LoadPendingOperation(operationID)
+	if operation == nil || !handler.DoesUserHavePermission(t.ID, operation.OrganizationID, operation.Permission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	return operation
+}
+
+// PendingOperationApiGetHandler returns an operation to the user that requested it and the users that can decide it.
+func PendingOperationApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	operationID, _ := utils.StringToInt64(c.Param("operationID"))
+	operation := handler.LoadPendingOperation(operationID)
+	if operation == nil || (operation.OrganizationUserID != t.ID && !handler.DoesUserHavePermission(t.ID, operation.OrganizationID, operation.Permission)) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	c.JSON(http.StatusOK, newPendingOperationResponse(operation))
+	return nil
+}
+
+// decidePendingOperation records the decision of a second user that holds the operation's permission, it writes
+// the error response and returns false if the operation can't be decided.
+func decidePendingOperation(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context, operation *dao.PendingOperation, state int) bool {
+	var decisionRequest PendingOperationDecisionRequest
+	if err := c.ShouldBind(&decisionRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("decision format: %s", err.Error()))
+		return false
+	}
+	if operation.OrganizationUserID == t.ID {
+		c.String(http.StatusBadRequest, "operations must be decided by a second user")
+		return false
+	}
+
+	now := time.Now().UTC()
+	if operation.CurrentState == dao.OperationPendingState && now.Sub(operation.RequestedTimestamp) > pendingOperationLifetime {
+		operation.CurrentState, operation.DecidedTimestamp = dao.OperationExpiredState, now
+		handler.DecidePendingOperation(operation)
+		c.String(http.StatusConflict, "operation expired")
+		return false
+	}
+
+	operation.CurrentState = state
+	operation.ApproverID = t.ID
+	operation.DecisionReason = decisionRequest.Reason
+	operation.DecidedTimestamp = now
+	if err := handler.DecidePendingOperation(operation); errors.Is(err, dao.ErrOperationNotPending) {
+		c.String(http.StatusConflict, err.Error())
+		return false
+	} else if err != nil {
+		c.String(http.StatusInternalServerError, "error recording decision")
+		return false
+	}
+	return true
+}
+
+// PendingOperationApproveApiPostHandler approves an operation and executes it as the user that requested it.
+func PendingOperationApproveApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	operation := loadDecidablePendingOperation(t, handler, c)
+	if operation == nil || !decidePendingOperation(t, handler, c, operation, dao.OperationApprovedState) {
+		return nil
+	}
+
+	operation.ResultStatus, operation.ResultBody = executePendingOperation(s, operation)
+	handler.RecordPendingOperationResult(operation.ID, operation.ResultStatus, operation.ResultBody)
+
+	c.JSON(http.StatusOK, newPendingOperationResponse(operation))
+
+	result := pendingOperationAuditResult(operation, "approved and executed")
+	result.AuditMetadata["resultStatus"] = operation.ResultStatus
+	return result
+}
+
+// PendingOperationDenyApiPostHandler denies an operation, it is never executed.
+func PendingOperationDenyApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	operation := loadDecidablePendingOperation(t, handler, c)
+	if operation == nil || !decidePendingOperation(t, handler, c, operation, dao.OperationDeniedState) {
+		return nil
+	}
+
+	c.JSON(http.StatusOK, newPendingOperationResponse(operation))
+	return pendingOperationAuditResult(operation, "denied")
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// Bounds on how long an elevated role is held for.
+const (
+	DefaultElevationMinutes = 60
+	MaxElevationMinutes     = 8 * 60
+)
+
+// elevationRequestLifetime is how long a request waits for a decision before it expires.
+const elevationRequestLifetime = 24 * time.Hour
+
+var elevationStateNames = map[int]string{
+	dao.ElevationPendingState:  "pending",
+	dao.ElevationApprovedState: "approved",
+	dao.ElevationDeniedState:   "denied",
+	dao.ElevationExpiredState:  "expired",
+}
+
+func newElevationResponse(request *dao.ElevationRequest) *ElevationResponse {
+	return &ElevationResponse{
+		ID:              request.ID,
+		UserID:          request.OrganizationUserID,
+		OrganizationID:  request.OrganizationID,
+		RoleName:        request.RoleName,
+		DurationMinutes: int(request.Duration / time.Minute),
+		Justification:   request.Justification,
+		State:           elevationStateNames[request.CurrentState],
+		ApproverID:      request.ApproverID,
+		DecisionReason:  request.DecisionReason,
+		Requested:       request.RequestedTimestamp,
+		Decided:         optionalTime(request.DecidedTimestamp),
+		Expires:         optionalTime(request.ExpirationTimestamp),
+	}
+}
+
+func elevationAuditMetadata(request *dao.ElevationRequest) WebappOperationMetadata {
+	return WebappOperationMetadata{
+		"elevationID":    request.ID,
+		"userID":         request.OrganizationUserID,
+		"organizationID": request.OrganizationID,
+		"role":           request.RoleName,
+		"state":          elevationStateNames[request.CurrentState],
+	}
+}
+
+// ElevationApiPostHandler requests a role in an organization the caller can view for a limited time.
+func ElevationApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var createRequest CreateElevationRequest
+	if err := c.ShouldBind(&createRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("elevation format: %s", err.Error()))
+		return nil
+	}
+
+	if createRequest.DurationMinutes == 0 {
+		createRequest.DurationMinutes = DefaultElevationMinutes
+	}
+	if createRequest.DurationMinutes < 0 || createRequest.DurationMinutes > MaxElevationMinutes {
+		c.String(http.StatusBadRequest, fmt.Sprintf("duration must be between 1 and %d minutes", MaxElevationMinutes))
+		return nil
+	}
+	createRequest.Justification = strings.TrimSpace(createRequest.Justification)
+	if createRequest.Justification == "" {
+		c.String(http.StatusBadRequest, "a justification is required")
+		return nil
+	}
+	if !handler.HasValidRoles([]string{createRequest.RoleName}) {
+		c.String(http.StatusBadRequest, "invalid role")
+		return nil
+	}
+	if !handler.CanUserViewOrg(t.ID, createRequest.OrganizationID) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	request := &dao.ElevationRequest{
+		ID:                 utils.GetNextUniqueId(),
+		OrganizationUserID: t.ID,
+		OrganizationID:     createRequest.OrganizationID,
+		RoleName:           createRequest.RoleName,
+		Duration:           time.Duration(createRequest.DurationMinutes) * time.Minute,
+		Justification:      createRequest.Justification,
+		RequestedTimestamp: time.Now().UTC(),
+	}
+	handler.CreateElevationRequest(request)
+
+	c.JSON(http.StatusCreated, newElevationResponse(request))
+
+	metadata := elevationAuditMetadata(request)
+	metadata["justification"] = request.Justification
+	return &WebAppOperationResult{
+		AuditMetadata: metadata,
+		AuditHumanReadable: fmt.Sprintf("user %d requested %s in organization %d for %s: %s",
+			t.ID, request.RoleName, request.OrganizationID, request.Duration, request.Justification),
+	}
+}
+
+// ElevationsApiGetHandler lists the elevation requests of the caller.
+func ElevationsApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	response := make([]*ElevationResponse, 0)
+	for _, request := range handler.LoadElevationRequestsForUser(t.ID) {
+		response = append(response, newElevationResponse(request))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// OrganizationElevationsApiGetHandler lists the elevation requests in an organization and its descendants for
+// the users that can decide them.
+func OrganizationElevationsApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.DoesUserHavePermission(t.ID, organizationID, UserUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	response := make([]*ElevationResponse, 0)
+	for _, request := range handler.LoadElevationRequestsInTree(organizationID) {
+		response = append(response, newElevationResponse(request))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// decideElevation approves or denies the request in the elevationID param. The approver needs
+// user.update.execute on the request's organization or an ancestor and can't decide its own requests.
+func decideElevation(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context, approve bool) *WebAppOperationResult {
+	var decisionRequest ElevationDecisionRequest
+	if err := c.ShouldBind(&decisionRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("decision format: %s", err.Error()))
+		return nil
+	}
+
+	elevationID, _ := utils.StringToInt64(c.Param("elevationID"))
+	request := handler.LoadElevationRequest(elevationID)
+	if request == nil || !handler.DoesUserHavePermission(t.ID, request.OrganizationID, UserUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	if request.OrganizationUserID == t.ID {
+		c.String(http.StatusBadRequest, "not allowed to decide your own elevation request")
+		return nil
+	}
+
+	request.ApproverID = t.ID
+	request.DecisionReason = decisionRequest.Reason
+	request.DecidedTimestamp = time.Now().UTC()
+	request.CurrentState = dao.ElevationDeniedState
+	if approve {
+		if requester := handler.LoadUserFromID(request.OrganizationUserID); requester == nil || requester.CurrentState != dao.UserActiveState {
+			c.String(http.StatusBadRequest, "the requester is not active")
+			return nil
+		}
+		if exceedsPrivilegeCeiling(t, handler, c, request.OrganizationID, []string{request.RoleName}) {
+			return nil
+		}
+		request.CurrentState = dao.ElevationApprovedState
+		request.ExpirationTimestamp = request.DecidedTimestamp.Add(request.Duration)
+	}
+
+	if err := handler.DecideElevationRequest(request); errors.Is(err, dao.ErrElevationNotPending) || errors.Is(err, dao.ErrSeparationOfDuty) {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
+
+	c.JSON(http.StatusOK, newElevationResponse(request))
+
+	metadata := elevationAuditMetadata(request)
+	metadata["reason"] = request.DecisionReason
+	humanReadable := fmt.Sprintf("denied elevation %d of user %d to %s in organization %d", request.ID, request.OrganizationUserID, request.RoleName, request.OrganizationID)
+	if approve {
+		metadata["expires"] = request.ExpirationTimestamp
+		humanReadable = fmt.Sprintf("approved elevation %d of user %d to %s in organization %d until %s",
+			request.ID, request.OrganizationUserID, request.RoleName, request.OrganizationID, request.ExpirationTimestamp.Format(time.RFC3339))
+	}
+	return &WebAppOperationResult{AuditMetadata: metadata, AuditHumanReadable: humanReadable}
+}
+
+// ElevationApproveApiPostHandler approves an elevation request, the role is assigned straight away.
+func ElevationApproveApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	return decideElevation(t, handler, c, true)
+}
+
+// ElevationDenyApiPostHandler denies an elevation request.
+func ElevationDenyApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	return decideElevation(t, handler, c, false)
+}
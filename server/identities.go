@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+func identityAlreadyLinkedMessage(providerName string) string {
+	return fmt.Sprintf("This %s account is already linked to a user. Log in with it, or unlink it from that user first.", providerName)
+}
+
+// loadSelfOrManagedUser loads the user in the userID param if it is the caller or a user the caller can update.
+func loadSelfOrManagedUser(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context) *dao.OrganizationUser {
+	if userID, err := utils.StringToInt64(c.Param("userID")); err == nil && userID == t.ID {
+		return t
+	}
+	return loadManagedUser(t, handler, c)
+}
+
+// UserIdentitiesApiGetHandler lists the identities a user can log in with.
+func UserIdentitiesApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationUser := loadSelfOrManagedUser(t, handler, c)
+	if organizationUser == nil {
+		return nil
+	}
+
+	response := make([]*UserIdentityResponse, 0)
+	for _, identity := range handler.LoadUserIdentities(organizationUser.ID) {
+		response = append(response, &UserIdentityResponse{
+			ID:      identity.ID,
+			Type:    identity.IdpType,
+			Issuer:  identity.IdpIssuer,
+			Subject: identity.IdpCredentialValue,
+			Created: identity.CreatedTimestamp,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// UserIdentityApiPostHandler links another identity to the caller. The caller proves it owns the identity with
+// an id token the provider issued to this service.
+func UserIdentityApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var linkRequest LinkIdentityRequest
+	if err := c.ShouldBind(&linkRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("identity format: %s", err.Error()))
+		return nil
+	}
+
+	if userID, _ := utils.StringToInt64(c.Param("userID")); userID != t.ID {
+		c.String(http.StatusUnauthorized, "identities can only be linked to yourself")
+		return nil
+	}
+
+	oidcAuthenticator, ok := s.Authenticator.(*auth.OIDCAuthenticator)
+	if !ok {
+		c.String(http.StatusNotImplemented, "linking identities requires the OIDCAuthenticator")
+		return nil
+	}
+	provider := oidcAuthenticator.ProviderByName(linkRequest.Provider)
+	if provider == nil {
+		c.String(http.StatusBadRequest, "unknown provider")
+		return nil
+	}
+	profile, err := provider.VerifyIDToken(context.TODO(), linkRequest.IDToken)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id token")
+		return nil
+	}
+
+	identity := &dao.UserIdentity{
+		ID:                 utils.GetNextUniqueId(),
+		OrganizationUserID: t.ID,
+		IdpType:            provider.Type,
+		IdpIssuer:          provider.Issuer,
+		IdpCredentialValue: fmt.Sprintf("%v", profile["sub"]),
+		CreatedTimestamp:   time.Now().UTC(),
+	}
+	if err := handler.LinkUserIdentity(identity); errors.Is(err, dao.ErrIdentityAlreadyLinked) {
+		c.String(http.StatusConflict, identityAlreadyLinkedMessage(provider.Name))
+		return nil
+	}
+
+	c.JSON(http.StatusCreated, &UserIdentityResponse{
+		ID:      identity.ID,
+		Type:    identity.IdpType,
+		Issuer:  identity.IdpIssuer,
+		Subject: identity.IdpCredentialValue,
+		Created: identity.CreatedTimestamp,
+	})
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": t.ID, "identityID": identity.ID, "provider": provider.Name},
+		AuditHumanReadable: fmt.Sprintf("linked %s identity %d to user %d", provider.Name, identity.ID, t.ID),
+	}
+}
+
+// UserIdentityApiDeleteHandler unlinks an identity, a user always keeps at least one.
+func UserIdentityApiDeleteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationUser := loadSelfOrManagedUser(t, handler, c)
+	if organizationUser == nil {
+		return nil
+	}
+
+	identityID, _ := utils.StringToInt64(c.Param("identityID"))
+	switch err := handler.UnlinkUserIdentity(organizationUser.ID, identityID); {
+	case errors.Is(err, dao.ErrIdentityNotFound):
+		c.String(http.StatusNotFound, "identity not found")
+		return nil
+	case errors.Is(err, dao.ErrLastIdentity):
+		c.String(http.StatusConflict, "cannot unlink the only identity a user can log in with")
+		return nil
+	}
+
+	c.Status(http.StatusOK)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": organizationUser.ID, "identityID": identityID},
+		AuditHumanReadable: fmt.Sprintf("unlinked identity %d from user %d", identityID, organizationUser.ID),
+	}
+}
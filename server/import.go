@@ -0,0 +1,339 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/condition"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/manifest"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// errImportInvalid is wrapped by the errors of manifests that can't be imported as they are.
+var errImportInvalid = errors.New("invalid manifest")
+
+// importPlanner turns a manifest into the plan of what importing it changes.
+type importPlanner struct {
+	handler dao.DaoHandler
+	plan    *dao.ImportPlan
+	changes []*ImportChange
+	// organizations maps the paths of the manifest to organization ids, created is the organizations it creates.
+	organizations map[string]int64
+	created       map[int64]bool
+}
+
+func (p *importPlanner) planOrganizations(parentID int64, parentPath string, orgs []*manifest.Organization) error {
+	existing := make(map[string]*dao.Organization)
+	if !p.created[parentID] {
+		for _, o := range p.handler.LoadChildOrganizations(parentID) {
+			existing[o.DisplayName] = o
+		}
+	}
+
+	for _, o := range orgs {
+		path := o.Name
+		if parentPath != "" {
+			path = manifest.Path(parentPath, o.Name)
+		}
+		planned := &dao.ImportOrganization{ParentID: parentID, DisplayName: o.Name}
+		if e, ok := existing[o.Name]; ok {
+			planned.ID = e.ID
+			if o.Metadata != nil {
+				current, _ := json.Marshal(p.handler.LoadOrganizationMetadata(e.ID))
+				wanted, _ := json.Marshal(o.Metadata)
+				if !bytes.Equal(current, wanted) {
+					planned.Metadata = o.Metadata
+					p.changes = append(p.changes, &ImportChange{Action: "update", Kind: "metadata", Organization: path, ID: e.ID})
+				}
+			}
+		} else {
+			planned.ID, planned.Create, planned.Metadata = utils.GetNextUniqueId(), true, o.Metadata
+			p.created[planned.ID] = true
+			p.changes = append(p.changes, &ImportChange{Action: "create", Kind: "organization", Organization: path, ID: planned.ID})
+		}
+		if planned.Create || planned.Metadata != nil {
+			p.plan.Organizations = append(p.plan.Organizations, planned)
+		}
+		p.organizations[path] = planned.ID
+
+		if err := p.planOrganizations(planned.ID, path, o.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *importPlanner) organization(path string) (int64, error) {
+	id, ok := p.organizations[path]
+	if !ok {
+		return 0, fmt.Errorf("%w: organization %q isn't in the manifest", errImportInvalid, path)
+	}
+	return id, nil
+}
+
+// sameRoles returns true if a user already holds exactly the roles of the assignment in its organization.
+func sameRoles(current []UserOrgRoles, wanted *manifest.RoleAssignment) bool {
+	// Elevated roles are left alone by the import.
+	assigned := make([]UserOrgRoles, 0, len(current))
+	for _, c := range current {
+		if c.ElevationRequestID == 0 {
+			assigned = append(assigned, c)
+		}
+	}
+	if len(assigned) != 1 || len(assigned[0].RoleNames) != len(wanted.RoleNames) {
+		return false
+	}
+	c := assigned[0]
+	currentNames := append([]string(nil), c.RoleNames...)
+	wantedNames := append([]string(nil), wanted.RoleNames...)
+	sort.Strings(currentNames)
+	sort.Strings(wantedNames)
+	for i := range currentNames {
+		if currentNames[i] != wantedNames[i] {
+			return false
+		}
+	}
+	sameTime := func(a, b *time.Time) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Equal(*b)
+	}
+	return sameTime(c.ValidFrom, wanted.ValidFrom) && sameTime(c.ValidUntil, wanted.ValidUntil) &&
+		c.Inheritance == wanted.Inheritance && c.Condition == wanted.Condition
+}
+
+func (p *importPlanner) planUser(u *manifest.User) error {
+	organizationID, err := p.organization(u.Organization)
+	if err != nil {
+		return err
+	}
+	if organizationID == 0 {
+		return fmt.Errorf("%w: user %q must belong to an organization", errImportInvalid, u.UserName)
+	}
+
+	planned := &dao.ImportUser{OrganizationID: organizationID, DisplayName: u.Name, UserName: u.UserName}
+	var current *dao.OrganizationUser
+	if !p.created[organizationID] {
+		if members := p.handler.LoadOrganizationMembers(organizationID, u.UserName); len(members) > 0 {
+			current = p.handler.LoadUserFromID(members[0].ID)
+		}
+	}
+	if current != nil {
+		planned.ID = current.ID
+	} else {
+		planned.ID, planned.Create = utils.GetNextUniqueId(), true
+		if u.Identity == nil {
+			planned.InviteCode = utils.GetNextUniqueId()
+		}
+		p.changes = append(p.changes, &ImportChange{Action: "create", Kind: "user", Organization: u.Organization, UserName: u.UserName, ID: planned.ID})
+	}
+
+	if u.Identity != nil && current != nil {
+		// Linking an identity to a user that exists lets whoever holds it log in as the user, only the user itself can
+		// do that, see UserIdentityApiPostHandler.
+		linked := false
+		for _, identity := range p.handler.LoadUserIdentities(current.ID) {
+			linked = linked || (identity.IdpIssuer == u.Identity.Issuer && identity.IdpCredentialValue == u.Identity.Subject)
+		}
+		if !linked {
+			return fmt.Errorf("%w: user %q exists, identities can only be given to the users the import creates", errImportInvalid, u.UserName)
+		}
+	} else if u.Identity != nil {
+		idpType := u.Identity.Type
+		if idpType == "" {
+			idpType = auth.GenericProviderType
+		}
+		planned.Identity = &dao.UserIdentity{ID: utils.GetNextUniqueId(), IdpType: idpType, IdpIssuer: u.Identity.Issuer, IdpCredentialValue: u.Identity.Subject, CreatedTimestamp: time.Now().UTC()}
+	}
+
+	for _, r := range u.Roles {
+		roleOrganizationID, err := p.organization(r.Organization)
+		if err != nil {
+			return err
+		}
+		inheritance, ok := roleInheritances[r.Inheritance]
+		if !ok {
+			return fmt.Errorf("%w: inheritance of user %q must be organization or descendants", errImportInvalid, u.UserName)
+		}
+		if !p.handler.HasValidRoles(r.RoleNames) {
+			return fmt.Errorf("%w: roles of user %q contain at least one invalid role", errImportInvalid, u.UserName)
+		}
+		if r.Condition != "" {
+			if _, err := condition.Compile(r.Condition); err != nil {
+				return fmt.Errorf("%w: condition of user %q: %s", errImportInvalid, u.UserName, err.Error())
+			}
+		}
+		if current != nil && sameRoles(newUserOrgRoles(roleOrganizationID, current.UserRoles[roleOrganizationID]), r) {
+			continue
+		}
+
+		a := &dao.RoleAssignment{OrganizationID: roleOrganizationID, RoleNames: r.RoleNames, Inheritance: inheritance, Condition: r.Condition}
+		if r.ValidFrom != nil {
+			a.ValidFrom = *r.ValidFrom
+		}
+		if r.ValidUntil != nil {
+			a.ValidUntil = *r.ValidUntil
+		}
+		planned.Assignments = append(planned.Assignments, a)
+		p.changes = append(p.changes, &ImportChange{Action: "update", Kind: "roles", Organization: r.Organization, UserName: u.UserName, ID: planned.ID, RoleNames: r.RoleNames})
+	}
+
+	if planned.Create || planned.Identity != nil || len(planned.Assignments) > 0 {
+		p.plan.Users = append(p.plan.Users, planned)
+	}
+	return nil
+}
+
+func planImport(handler dao.DaoHandler, parentID int64, m *manifest.Manifest) (*importPlanner, error) {
+	if parentID != 0 && handler.LoadOrganizationDetails(parentID, 0) == nil {
+		return nil, fmt.Errorf("%w: organization %d does not exist", errImportInvalid, parentID)
+	}
+	p := &importPlanner{
+		handler:       handler,
+		plan:          &dao.ImportPlan{},
+		changes:       make([]*ImportChange, 0),
+		organizations: map[string]int64{"": parentID},
+		created:       make(map[int64]bool),
+	}
+	if err := p.planOrganizations(parentID, "", m.Organizations); err != nil {
+		return nil, err
+	}
+	for _, u := range m.Users {
+		if err := p.planUser(u); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// apply makes the planned changes, or only checks they can be made without commit, and returns them.
+func (p *importPlanner) apply(commit bool) ([]*ImportChange, error) {
+	if err := p.handler.ApplyImportPlan(p.plan, commit); err != nil {
+		return nil, err
+	}
+	if commit {
+		invites := make(map[int64]int64)
+		for _, u := range p.plan.Users {
+			invites[u.ID] = u.InviteCode
+		}
+		for _, change := range p.changes {
+			if change.Kind == "user" && invites[change.ID] != 0 {
+				change.InviteCode = invites[change.ID]
+				change.Href = createInviteLink("", change.InviteCode, p.handler)
+			}
+		}
+	}
+	return p.changes, nil
+}
+
+// ImportManifest imports a manifest into the organization parentID, or as a new root of the hierarchy when it is
+// zero, and returns what it changed. Organizations are matched by name among their siblings and users by userName
+// among the members of their organization, what already matches the manifest is left alone. All changes are made
+// at once or not at all, unless commit is false when nothing is changed and the changes importing would make are
+// returned.
+func ImportManifest(handler dao.DaoHandler, parentID int64, m *manifest.Manifest, commit bool) ([]*ImportChange, error) {
+	p, err := planImport(handler, parentID, m)
+	if err != nil {
+		return nil, err
+	}
+	return p.apply(commit)
+}
+
+// manifestRoleNames returns the names of all the roles a manifest assigns.
+func manifestRoleNames(m *manifest.Manifest) []string {
+	seen := make(map[string]bool)
+	ret := make([]string, 0)
+	for _, u := range m.Users {
+		for _, r := range u.Roles {
+			for _, name := range r.RoleNames {
+				if !seen[name] {
+					seen[name] = true
+					ret = append(ret, name)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// ImportApiPostHandler imports the YAML or JSON manifest in the body into an organization, or as a new root of the
+// hierarchy for system admins when organizationID is 0. With dryRun=true it only returns the changes importing
+// would make.
+func ImportApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.String(http.StatusBadRequest, "error reading request")
+		return nil
+	}
+	m, err := manifest.Parse(body)
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("manifest format: %s", err.Error()))
+		return nil
+	}
+	dryRun := c.Query("dryRun") == "true"
+
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	permissions := []string{OrganizationCreatePermission, UserCreatePermission, UserUpdatePermission}
+	if organizationID == 0 {
+		if !handler.DoesUserHaveSystemPermission(t.ID, SystemOrganizationCreatePermission) || !handler.DoesUserHaveSystemPermission(t.ID, SystemUserCreatePermission) {
+			c.String(http.StatusUnauthorized, "not authorized")
+			return nil
+		}
+	} else {
+		for _, permission := range permissions {
+			if !handler.DoesUserHavePermission(t.ID, organizationID, permission) {
+				c.String(http.StatusUnauthorized, "not authorized")
+				return nil
+			}
+		}
+		// Roles granted in the subtree can't exceed what the caller holds at its top.
+		if exceedsPrivilegeCeiling(t, handler, c, organizationID, manifestRoleNames(m)) {
+			return nil
+		}
+		if !dryRun {
+			for _, permission := range permissions {
+				if deferred := deferForDualControl(t, handler, c, organizationID, permission); deferred != nil {
+					return deferred
+				}
+			}
+		}
+	}
+
+	p, err := planImport(handler, organizationID, m)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return nil
+	}
+	if !isBootstrapEnabled(handler) {
+		for _, change := range p.changes {
+			if change.Kind == "roles" && change.ID == t.ID {
+				c.String(http.StatusBadRequest, "not allowed to change your own roles")
+				return nil
+			}
+		}
+	}
+	changes, err := p.apply(!dryRun)
+	if err != nil {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
+
+	c.JSON(http.StatusOK, &ImportResponse{DryRun: dryRun, Changes: changes})
+	if dryRun {
+		return nil
+	}
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"organizationID": organizationID, "changes": len(changes)},
+		AuditHumanReadable: fmt.Sprintf("imported a manifest into organization %d making %d changes", organizationID, len(changes)),
+	}
+}
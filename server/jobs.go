@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/genesis32/complianceweb/audit"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+)
+
+const (
+	auditArchiveInterval    = time.Hour
+	signingKeyCheckInterval = time.Hour
+	// userSessionPurgeInterval is also how long revoked and expired sessions are kept around.
+	userSessionPurgeInterval = 24 * time.Hour
+	roleExpiryInterval       = 5 * time.Minute
+	breakGlassReviewInterval = time.Hour
+)
+
+// SystemAuditInternalKey is the internal key of audit records written by the server itself instead of a request.
+const SystemAuditInternalKey = "system"
+
+// startBackgroundJob runs fn every interval until the server is shut down.
+func (s *Server) startBackgroundJob(interval time.Duration, fn func(s *Server, now time.Time)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopBackgroundJobs:
+				return
+			case now := <-ticker.C:
+				fn(s, now)
+			}
+		}
+	}()
+}
+
+func loadAuditRetentionPolicies(daoHandler dao.DaoHandler) map[string]time.Duration {
+	ret := make(map[string]time.Duration)
+	for k, v := range daoHandler.GetSettingsWithPrefix(AuditRetentionConfigurationKeyPrefix) {
+		days, err := utils.StringToInt64(v.Value)
+		if err != nil || days <= 0 {
+			log.Printf("ignoring invalid audit retention %s=%s", k, v.Value)
+			continue
+		}
+		ret[strings.TrimPrefix(k, AuditRetentionConfigurationKeyPrefix)] = time.Duration(days) * 24 * time.Hour
+	}
+	return ret
+}
+
+// ArchiveAuditRecordsJob moves aged audit records into archive files when an archive directory is configured.
+func ArchiveAuditRecordsJob(s *Server, now time.Time) {
+	settings := s.Dao.GetSettings(AuditArchiveDirectoryConfigurationKey)
+	if len(settings) == 0 || settings[AuditArchiveDirectoryConfigurationKey].Value == "" {
+		return
+	}
+
+	archiver := audit.NewArchiver(s.Dao, settings[AuditArchiveDirectoryConfigurationKey].Value)
+	manifests, err := archiver.Run(now, loadAuditRetentionPolicies(s.Dao))
+	for _, m := range manifests {
+		log.Printf("archived %d %s audit records to %s", m.RecordCount, m.InternalKey, m.ArchiveFile)
+	}
+	if err != nil {
+		log.Printf("error archiving audit records: %v", err)
+	}
+}
+
+// PurgeLoginStatesJob removes logins that were started but never completed.
+func PurgeLoginStatesJob(s *Server, now time.Time) {
+	s.Dao.PurgeLoginStates(now.UTC().Add(-loginStateLifetime))
+}
+
+// RotateSigningKeysJob replaces the token signing key once it is older than the rotation period.
+func RotateSigningKeysJob(s *Server, now time.Time) {
+	if err := s.TokenIssuer.EnsureSigningKey(s.Config.SigningKeyRotation); err != nil {
+		log.Printf("error rotating token signing key: %v", err)
+	}
+}
+
+// PurgeUserSessionsJob removes sessions that can no longer be used.
+func PurgeUserSessionsJob(s *Server, now time.Time) {
+	s.Dao.PurgeUserSessions(now.UTC().Add(-userSessionPurgeInterval))
+}
+
+// writeSystemAuditRecord records an action the server took on its own.
+func writeSystemAuditRecord(s *Server, organizationID int64, method string, metadata WebappOperationMetadata, humanReadable string) {
+	writeAuditRecord(s, SystemAuditInternalKey, 0, organizationID, method, metadata, humanReadable)
+}
+
+// writeAuditRecord records an action outside of the audit record registerAPIA writes for each request.
+func writeAuditRecord(s *Server, internalKey string, userID, organizationID int64, method string, metadata WebappOperationMetadata, humanReadable string) {
+	auditRecord := dao.NewAuditRecord(internalKey, method)
+	auditRecord.OrganizationUserID = userID
+	auditRecord.OrganizationID = organizationID
+	s.Dao.CreateAuditRecord(auditRecord)
+
+	auditRecord.Metadata = newWebappAuditMetadata(metadata)
+	auditRecord.HumanReadable = humanReadable
+	s.Dao.SealAuditRecord(auditRecord)
+}
+
+// ExpireRoleAssignmentsJob removes time bound role assignments once they expire and records each in the audit log.
+func ExpireRoleAssignmentsJob(s *Server, now time.Time) {
+	for _, a := range s.Dao.ExpireRoleAssignments(now) {
+		writeSystemAuditRecord(s, a.OrganizationID, "EXPIRE", WebappOperationMetadata{
+			"userID":         a.OrganizationUserID,
+			"organizationID": a.OrganizationID,
+			"roleID":         a.RoleID,
+			"validUntil":     a.ValidUntil,
+		}, fmt.Sprintf("role %s of user %d in organization %d expired at %s", a.RoleName, a.OrganizationUserID, a.OrganizationID, a.ValidUntil.Format(time.RFC3339)))
+	}
+}
+
+// ExpireElevationRequestsJob ends elevations whose role expired and requests nobody decided in time.
+func ExpireElevationRequestsJob(s *Server, now time.Time) {
+	for _, request := range s.Dao.ExpireElevationRequests(now, now.Add(-elevationRequestLifetime)) {
+		humanReadable := fmt.Sprintf("elevation %d of user %d to %s in organization %d expired", request.ID, request.OrganizationUserID, request.RoleName, request.OrganizationID)
+		if request.ApproverID == 0 {
+			humanReadable = fmt.Sprintf("elevation request %d of user %d to %s in organization %d expired without a decision", request.ID, request.OrganizationUserID, request.RoleName, request.OrganizationID)
+		}
+		writeSystemAuditRecord(s, request.OrganizationID, "EXPIRE", elevationAuditMetadata(request), humanReadable)
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/ladon"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// LadonPoliciesApiGetHandler exports the grants and denies applying in an organization's subtree as Ladon policies.
+func LadonPoliciesApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.DoesUserHavePermission(t.ID, organizationID, UserReadPermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	c.JSON(http.StatusOK, ladon.Policies(handler.LoadPolicyExport(organizationID)))
+	return nil
+}
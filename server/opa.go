@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/opa"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// OpaBundleApiGetHandler serves the grants and denies applying in an organization's subtree as an OPA bundle. It
+// answers 304 when the If-None-Match header has the ETag of the current bundle so OPA can poll it cheaply.
+func OpaBundleApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.DoesUserHavePermission(t.ID, organizationID, UserReadPermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	bundle, revision, err := opa.Bundle(opa.NewData(handler.LoadPolicyExport(organizationID)))
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil
+	}
+	etag := `"` + revision + `"`
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return nil
+	}
+	c.Data(http.StatusOK, "application/gzip", bundle)
+	return nil
+}
@@ -1,5 +1,7 @@
 package server
 
+import "strings"
+
 // A list of permissions the system supports.
 const (
 	UserCreatePermission               = "user.create.execute"
@@ -9,4 +11,12 @@ const (
 	OrganizationCreatePermission       = "organization.create.execute"
 	SystemOrganizationCreatePermission = "system.organization.create.execute"
 	SystemUserCreatePermission         = "system.user.create.execute"
+	SystemUpdatePermission             = "system.update.execute"
+	SystemBreakGlassPermission         = "system.breakglass.execute"
 )
+
+// isPermissionPattern returns true for the permission patterns roles can hold, e.g. gcp.serviceaccount.*, which
+// only make sense in roles.
+func isPermissionPattern(permission string) bool {
+	return strings.Contains(permission, "*")
+}
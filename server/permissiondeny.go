@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+func newPermissionDenyResponse(deny *dao.PermissionDeny) *PermissionDenyResponse {
+	return &PermissionDenyResponse{
+		ID:             deny.ID,
+		OrganizationID: deny.OrganizationID,
+		UserID:         deny.OrganizationUserID,
+		GroupID:        deny.UserGroupID,
+		Permission:     deny.Permission,
+		Created:        deny.CreatedTimestamp,
+	}
+}
+
+// PermissionDenyApiPostHandler denies a permission to a user or group in an organization and its descendants.
+func PermissionDenyApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var denyRequest PermissionDenyRequest
+	if err := c.ShouldBind(&denyRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("deny format: %s", err.Error()))
+		return nil
+	}
+
+	if !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	if (denyRequest.UserID == 0) == (denyRequest.GroupID == 0) {
+		c.String(http.StatusBadRequest, "exactly one of UserID and GroupID is required")
+		return nil
+	}
+	if denyRequest.UserID != 0 && handler.LoadUserFromID(denyRequest.UserID) == nil {
+		c.String(http.StatusBadRequest, "user not found")
+		return nil
+	}
+	if denyRequest.GroupID != 0 && handler.LoadUserGroup(denyRequest.GroupID) == nil {
+		c.String(http.StatusBadRequest, "group not found")
+		return nil
+	}
+	// Denies match permissions exactly, a pattern would be stored and never deny anything.
+	if isPermissionPattern(denyRequest.Permission) {
+		c.String(http.StatusBadRequest, "permission patterns can't be denied")
+		return nil
+	}
+	if !handler.HasValidPermissions([]string{denyRequest.Permission}) {
+		c.String(http.StatusBadRequest, "invalid permission")
+		return nil
+	}
+
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	deny := &dao.PermissionDeny{
+		ID:                 utils.GetNextUniqueId(),
+		OrganizationID:     organizationID,
+		OrganizationUserID: denyRequest.UserID,
+		UserGroupID:        denyRequest.GroupID,
+		Permission:         denyRequest.Permission,
+		CreatedTimestamp:   time.Now().UTC(),
+	}
+	handler.CreatePermissionDeny(deny)
+	c.JSON(http.StatusCreated, newPermissionDenyResponse(deny))
+
+	return &WebAppOperationResult{
+		AuditMetadata: WebappOperationMetadata{
+			"denyID":         deny.ID,
+			"organizationID": organizationID,
+			"userID":         deny.OrganizationUserID,
+			"groupID":        deny.UserGroupID,
+			"permission":     deny.Permission,
+		},
+		AuditHumanReadable: fmt.Sprintf("denied %s to user %d group %d in organization %d", deny.Permission, deny.OrganizationUserID, deny.UserGroupID, organizationID),
+	}
+}
+
+// PermissionDeniesApiGetHandler lists the denies in an organization and its descendants.
+func PermissionDeniesApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.DoesUserHavePermission(t.ID, organizationID, UserReadPermission) && !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	response := make([]*PermissionDenyResponse, 0)
+	for _, deny := range handler.LoadPermissionDeniesInTree(organizationID) {
+		response = append(response, newPermissionDenyResponse(deny))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// PermissionDenyApiDeleteHandler removes a deny.
+func PermissionDenyApiDeleteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	if !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	denyID, _ := utils.StringToInt64(c.Param("denyID"))
+	deny := handler.LoadPermissionDeny(denyID)
+	if deny == nil {
+		c.String(http.StatusNotFound, "deny not found")
+		return nil
+	}
+	handler.DeletePermissionDeny(deny.ID)
+	c.Status(http.StatusOK)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"denyID": deny.ID, "organizationID": deny.OrganizationID, "permission": deny.Permission},
+		AuditHumanReadable: fmt.Sprintf("removed deny of %s to user %d group %d in organization %d", deny.Permission, deny.OrganizationUserID, deny.UserGroupID, deny.OrganizationID),
+	}
+}
+
+// PermissionExplainApiGetHandler explains whether a user has the permission query param in the organizationID
+// query param, listing the roles granting it and the denies overriding them. Users can explain their own
+// permissions, anyone else needs user.read.execute on the organization.
+func PermissionExplainApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	userID, _ := utils.StringToInt64(c.Param("userID"))
+	organizationID, err := utils.StringToInt64(c.Query("organizationID"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "organization invalid ID")
+		return nil
+	}
+	permission := c.Query("permission")
+	if !handler.HasValidPermissions([]string{permission}) {
+		c.String(http.StatusBadRequest, "invalid permission")
+		return nil
+	}
+	if userID != t.ID && !handler.DoesUserHavePermission(t.ID, organizationID, UserReadPermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	explanation := handler.ExplainPermission(userID, organizationID, permission)
+	response := &PermissionExplanationResponse{
+		UserID:         userID,
+		OrganizationID: organizationID,
+		Permission:     permission,
+		Grants:         make([]*PermissionGrantResponse, 0, len(explanation.Grants)),
+		Denies:         make([]*PermissionDenyResponse, 0, len(explanation.Denies)),
+	}
+	for _, grant := range explanation.Grants {
+		grantResponse := &PermissionGrantResponse{OrganizationID: grant.OrganizationID, RoleName: grant.RoleName, Permission: grant.Permission, Condition: grant.Condition, Applies: true}
+		if grant.Condition != "" {
+			// Conditions are evaluated against this request, as if the user made it.
+			grantResponse.Applies = anyConditionHolds(handler, c, userID, organizationID, []string{grant.Condition})
+		}
+		response.Allowed = response.Allowed || (grantResponse.Applies && len(explanation.Denies) == 0)
+		response.Grants = append(response.Grants, grantResponse)
+	}
+	for _, deny := range explanation.Denies {
+		response.Denies = append(response.Denies, newPermissionDenyResponse(deny))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/gin-gonic/gin"
+)
+
+// denyDao lets a system admin deny any permission to user 2 and records the denies, every other method panics.
+type denyDao struct {
+	dao.DaoHandler
+	denies []*dao.PermissionDeny
+}
+
+func (d *denyDao) DoesUserHaveSystemPermission(userID int64, permission string) bool {
+	return true
+}
+
+func (d *denyDao) LoadUserFromID(id int64) *dao.OrganizationUser {
+	return &dao.OrganizationUser{ID: id}
+}
+
+func (d *denyDao) HasValidPermissions(permissions []string) bool {
+	return true
+}
+
+func (d *denyDao) CreatePermissionDeny(deny *dao.PermissionDeny) {
+	d.denies = append(d.denies, deny)
+}
+
+func TestPermissionDenyRejectsPatterns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for permission, want := range map[string]int{"gcp.serviceaccount.*": http.StatusBadRequest, "gcp.serviceaccount.read.execute": http.StatusCreated} {
+		handler := &denyDao{}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "organizationID", Value: "10"}}
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/organizations/10/denies", bytes.NewBufferString(`{"UserID": "2", "Permission": "`+permission+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		result := PermissionDenyApiPostHandler(&dao.OrganizationUser{ID: 1}, nil, nil, handler, c)
+		if w.Code != want {
+			t.Errorf("%s: status %d want %d", permission, w.Code, want)
+		}
+		if created := len(handler.denies) == 1 && result != nil; created != (want == http.StatusCreated) {
+			t.Errorf("%s: deny created %v", permission, created)
+		}
+	}
+}
@@ -0,0 +1,790 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// The SCIM 2.0 schemas the endpoints read and write.
+const (
+	ScimUserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimGroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimPatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ScimServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+const scimContentType = "application/scim+json"
+
+// ScimMeta is the meta attribute of every resource.
+type ScimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+// ScimName is the name of a user, only used to derive a display name.
+type ScimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// ScimReference points at a group from a user or at a user from a group.
+type ScimReference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// ScimUser is an organization user that is a member of the SCIM client's organization.
+type ScimUser struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	UserName    string          `json:"userName"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Name        *ScimName       `json:"name,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Groups      []ScimReference `json:"groups,omitempty"`
+	Meta        *ScimMeta       `json:"meta,omitempty"`
+}
+
+// ScimGroup is a role, its members are the users that have the role in the SCIM client's organization.
+type ScimGroup struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	DisplayName string          `json:"displayName"`
+	Members     []ScimReference `json:"members,omitempty"`
+	Meta        *ScimMeta       `json:"meta,omitempty"`
+}
+
+// ScimListResponse is a page of query results.
+type ScimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// ScimPatchOperation is a single change of a PATCH request.
+type ScimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ScimPatchRequest is the body of a PATCH request.
+type ScimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []ScimPatchOperation `json:"Operations"`
+}
+
+// ScimErrorResponse is returned for every failed request.
+type ScimErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+var scimFilterRegex = regexp.MustCompile(`^\s*(\w+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// scimMemberFilterRegex matches the path Azure AD uses to remove a single member.
+var scimMemberFilterRegex = regexp.MustCompile(`^members\[\s*value\s+eq\s+"([^"]*)"\s*\]$`)
+
+func scimJSON(c *gin.Context, status int, v interface{}) {
+	c.Header("Content-Type", scimContentType)
+	c.Status(status)
+	json.NewEncoder(c.Writer).Encode(v)
+}
+
+func scimError(c *gin.Context, status int, scimType, detail string) {
+	scimJSON(c, status, &ScimErrorResponse{Schemas: []string{ScimErrorSchema}, Status: strconv.Itoa(status), ScimType: scimType, Detail: detail})
+}
+
+// parseScimFilter supports the single equality filters identity providers use to look resources up.
+func parseScimFilter(filter string) (string, string, bool) {
+	if filter == "" {
+		return "", "", true
+	}
+	m := scimFilterRegex.FindStringSubmatch(filter)
+	if m == nil {
+		return "", "", false
+	}
+	return strings.ToLower(m[1]), strings.ReplaceAll(m[2], `\"`, `"`), true
+}
+
+func scimListResponse(c *gin.Context, resources []interface{}) *ScimListResponse {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(len(resources))))
+	if err != nil || count < 0 {
+		count = len(resources)
+	}
+
+	page := make([]interface{}, 0)
+	if startIndex <= len(resources) {
+		end := startIndex - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[startIndex-1 : end]
+	}
+	return &ScimListResponse{
+		Schemas:      []string{ScimListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// scimOrganization returns the organization the SCIM client provisions into, the one organization its service
+// principal is a member of, as long as it has permission there.
+func scimOrganization(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context, permission string) int64 {
+	if t.UserType != dao.ServiceUserType || len(t.Organizations) != 1 {
+		scimError(c, http.StatusForbidden, "", "SCIM clients must be service principals that belong to a single organization")
+		return 0
+	}
+	if !handler.DoesUserHavePermission(t.ID, t.Organizations[0], permission) {
+		scimError(c, http.StatusForbidden, "", "not authorized")
+		return 0
+	}
+	return t.Organizations[0]
+}
+
+// loadScimUser loads the user in the id param if it is a member of organizationID.
+func loadScimUser(handler dao.DaoHandler, c *gin.Context, organizationID int64) *dao.OrganizationUser {
+	userID, err := utils.StringToInt64(c.Param("id"))
+	if err == nil && handler.IsOrganizationMember(organizationID, userID) {
+		if u := handler.LoadUserFromID(userID); u != nil && u.UserType == dao.HumanUserType {
+			return u
+		}
+	}
+	scimError(c, http.StatusNotFound, "", "user not found")
+	return nil
+}
+
+func newScimUser(s *Server, u *dao.OrganizationUser, organizationID int64) *ScimUser {
+	active := u.CurrentState == dao.UserActiveState
+	ret := &ScimUser{
+		Schemas:     []string{ScimUserSchema},
+		ID:          fmt.Sprintf("%d", u.ID),
+		ExternalID:  u.ExternalID,
+		UserName:    u.UserName,
+		DisplayName: u.DisplayName,
+		Active:      &active,
+		Meta:        &ScimMeta{ResourceType: "User", Location: fmt.Sprintf("%s/scim/v2/Users/%d", s.Config.SystemBaseUrl, u.ID)},
+	}
+	for _, r := range u.UserRoles[organizationID] {
+		ret.Groups = append(ret.Groups, ScimReference{Value: fmt.Sprintf("%d", r.ID), Display: r.DisplayName})
+	}
+	return ret
+}
+
+func (u *ScimUser) displayName() string {
+	switch {
+	case u.DisplayName != "":
+		return u.DisplayName
+	case u.Name != nil && u.Name.Formatted != "":
+		return u.Name.Formatted
+	case u.Name != nil && (u.Name.GivenName != "" || u.Name.FamilyName != ""):
+		return strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+	}
+	return u.UserName
+}
+
+// scimBool accepts the "True" and "False" strings some identity providers send instead of booleans.
+func scimBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(s)
+}
+
+// setScimUserAttribute applies a change from a PATCH request, attributes the service doesn't store are ignored.
+func setScimUserAttribute(u *ScimUser, attribute string, raw json.RawMessage) error {
+	var err error
+	switch strings.ToLower(attribute) {
+	case "active":
+		var active bool
+		active, err = scimBool(raw)
+		u.Active = &active
+	case "username":
+		err = json.Unmarshal(raw, &u.UserName)
+	case "displayname":
+		err = json.Unmarshal(raw, &u.DisplayName)
+	case "externalid":
+		err = json.Unmarshal(raw, &u.ExternalID)
+	}
+	return err
+}
+
+// saveScimUser writes the attributes of request to the user and deactivates or reactivates it.
+func saveScimUser(handler dao.DaoHandler, u *dao.OrganizationUser, request *ScimUser) {
+	u.UserName = request.UserName
+	u.ExternalID = request.ExternalID
+	u.DisplayName = request.displayName()
+	handler.UpdateProvisionedUser(u)
+
+	if request.Active == nil {
+		return
+	}
+	switch {
+	case *request.Active && u.CurrentState == dao.UserDeactiveState:
+		handler.UpdateUserState(u.ID, dao.UserActiveState)
+		u.CurrentState = dao.UserActiveState
+	case !*request.Active && u.CurrentState == dao.UserActiveState:
+		handler.UpdateUserState(u.ID, dao.UserDeactiveState)
+		u.CurrentState = dao.UserDeactiveState
+	}
+}
+
+func scimUserAuditResult(u *dao.OrganizationUser, action string) *WebAppOperationResult {
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": u.ID, "userName": u.UserName, "active": u.CurrentState == dao.UserActiveState},
+		AuditHumanReadable: fmt.Sprintf("scim %s user %d (%s)", action, u.ID, u.UserName),
+	}
+}
+
+// scimIdentityProvider is the provider users provisioned over SCIM log in with.
+func scimIdentityProvider(s *Server, handler dao.DaoHandler) *auth.OIDCProvider {
+	oidcAuthenticator, ok := s.Authenticator.(*auth.OIDCAuthenticator)
+	if !ok {
+		return nil
+	}
+	setting, ok := handler.GetSettings(ScimProviderConfigurationKey)[ScimProviderConfigurationKey]
+	if !ok {
+		return nil
+	}
+	return oidcAuthenticator.ProviderByName(setting.Value)
+}
+
+// ScimServiceProviderConfigHandler describes which parts of SCIM are supported.
+func ScimServiceProviderConfigHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{ScimServiceProviderConfigSchema},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": 1000},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Service principal api key",
+			"description": "An api key or access token of a service principal",
+		}},
+	})
+	return nil
+}
+
+// ScimUsersGetHandler lists the users of the organization.
+func ScimUsersGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserReadPermission)
+	if organizationID == 0 {
+		return nil
+	}
+
+	attribute, value, ok := parseScimFilter(c.Query("filter"))
+	if !ok || (attribute != "" && attribute != "username" && attribute != "externalid") {
+		scimError(c, http.StatusBadRequest, "invalidFilter", "only userName eq and externalId eq filters are supported")
+		return nil
+	}
+
+	userName := ""
+	if attribute == "username" {
+		userName = value
+	}
+	resources := make([]interface{}, 0)
+	for _, u := range handler.LoadOrganizationMembers(organizationID, userName) {
+		if attribute == "externalid" && u.ExternalID != value {
+			continue
+		}
+		resources = append(resources, newScimUser(s, u, organizationID))
+	}
+	scimJSON(c, http.StatusOK, scimListResponse(c, resources))
+	return nil
+}
+
+// ScimUserGetHandler returns a single user.
+func ScimUserGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserReadPermission)
+	if organizationID == 0 {
+		return nil
+	}
+	if u := loadScimUser(handler, c, organizationID); u != nil {
+		scimJSON(c, http.StatusOK, newScimUser(s, u, organizationID))
+	}
+	return nil
+}
+
+// ScimUserPostHandler provisions an active user that logs in with the identity provider in the scim.provider
+// setting. Its subject there is the externalId, or the userName if there is none.
+func ScimUserPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserCreatePermission)
+	if organizationID == 0 {
+		return nil
+	}
+
+	var request ScimUser
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || request.UserName == "" {
+		scimError(c, http.StatusBadRequest, "invalidValue", "a user requires a userName")
+		return nil
+	}
+	if len(handler.LoadOrganizationMembers(organizationID, request.UserName)) > 0 {
+		scimError(c, http.StatusConflict, "uniqueness", fmt.Sprintf("user %s already exists", request.UserName))
+		return nil
+	}
+
+	provider := scimIdentityProvider(s, handler)
+	if provider == nil {
+		scimError(c, http.StatusInternalServerError, "", fmt.Sprintf("%s does not name a configured identity provider", ScimProviderConfigurationKey))
+		return nil
+	}
+	subject := request.ExternalID
+	if subject == "" {
+		subject = request.UserName
+	}
+
+	u := &dao.OrganizationUser{ID: utils.GetNextUniqueId(), DisplayName: request.displayName(), UserType: dao.HumanUserType, UserName: request.UserName, ExternalID: request.ExternalID}
+	err := handler.CreateProvisionedUser(organizationID, u, &dao.UserIdentity{
+		ID:                 utils.GetNextUniqueId(),
+		IdpType:            provider.Type,
+		IdpIssuer:          provider.Issuer,
+		IdpCredentialValue: subject,
+		CreatedTimestamp:   time.Now().UTC(),
+	})
+	if err != nil {
+		scimError(c, http.StatusConflict, "uniqueness", identityAlreadyLinkedMessage(provider.Name))
+		return nil
+	}
+	if request.Active != nil && !*request.Active {
+		handler.UpdateUserState(u.ID, dao.UserDeactiveState)
+		u.CurrentState = dao.UserDeactiveState
+	}
+
+	scimJSON(c, http.StatusCreated, newScimUser(s, u, organizationID))
+	return scimUserAuditResult(u, "created")
+}
+
+// ScimUserPutHandler replaces the attributes of a user.
+func ScimUserPutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserUpdatePermission)
+	if organizationID == 0 {
+		return nil
+	}
+	u := loadScimUser(handler, c, organizationID)
+	if u == nil {
+		return nil
+	}
+
+	var request ScimUser
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || request.UserName == "" {
+		scimError(c, http.StatusBadRequest, "invalidValue", "a user requires a userName")
+		return nil
+	}
+
+	saveScimUser(handler, u, &request)
+	scimJSON(c, http.StatusOK, newScimUser(s, u, organizationID))
+	return scimUserAuditResult(u, "replaced")
+}
+
+// ScimUserPatchHandler changes some attributes of a user, setting active to false deprovisions it.
+func ScimUserPatchHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserUpdatePermission)
+	if organizationID == 0 {
+		return nil
+	}
+	u := loadScimUser(handler, c, organizationID)
+	if u == nil {
+		return nil
+	}
+
+	var request ScimPatchRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return nil
+	}
+
+	updated := newScimUser(s, u, organizationID)
+	for _, op := range request.Operations {
+		if strings.ToLower(op.Op) == "remove" {
+			continue
+		}
+		attributes := map[string]json.RawMessage{op.Path: op.Value}
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &attributes); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				return nil
+			}
+		}
+		for k, v := range attributes {
+			if err := setScimUserAttribute(updated, k, v); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", fmt.Sprintf("%s: %v", k, err))
+				return nil
+			}
+		}
+	}
+
+	saveScimUser(handler, u, updated)
+	scimJSON(c, http.StatusOK, newScimUser(s, u, organizationID))
+	return scimUserAuditResult(u, "updated")
+}
+
+// ScimUserDeleteHandler deprovisions a user by deactivating it, the user and its audit trail are kept.
+func ScimUserDeleteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserUpdatePermission)
+	if organizationID == 0 {
+		return nil
+	}
+	u := loadScimUser(handler, c, organizationID)
+	if u == nil {
+		return nil
+	}
+
+	if u.CurrentState != dao.UserDeactiveState {
+		handler.UpdateUserState(u.ID, dao.UserDeactiveState)
+		u.CurrentState = dao.UserDeactiveState
+	}
+	c.Status(http.StatusNoContent)
+	return scimUserAuditResult(u, "deprovisioned")
+}
+
+func loadScimRole(handler dao.DaoHandler, roleID string) *dao.Role {
+	for _, r := range handler.LoadRoles() {
+		if fmt.Sprintf("%d", r.ID) == roleID {
+			return r
+		}
+	}
+	return nil
+}
+
+func newScimGroup(s *Server, handler dao.DaoHandler, r *dao.Role, organizationID int64, withMembers bool) *ScimGroup {
+	ret := &ScimGroup{
+		Schemas:     []string{ScimGroupSchema},
+		ID:          fmt.Sprintf("%d", r.ID),
+		DisplayName: r.DisplayName,
+		Meta:        &ScimMeta{ResourceType: "Group", Location: fmt.Sprintf("%s/scim/v2/Groups/%d", s.Config.SystemBaseUrl, r.ID)},
+	}
+	if withMembers {
+		for _, id := range handler.LoadRoleMembers(organizationID, r.ID) {
+			ret.Members = append(ret.Members, ScimReference{Value: fmt.Sprintf("%d", id)})
+		}
+	}
+	return ret
+}
+
+// scimMemberIDs converts member references to users that are members of organizationID. The SCIM client's roles
+// are its own, it can't change them.
+func scimMemberIDs(t *dao.OrganizationUser, handler dao.DaoHandler, organizationID int64, members []ScimReference) ([]int64, error) {
+	ret := make([]int64, 0, len(members))
+	for _, m := range members {
+		id, err := utils.StringToInt64(m.Value)
+		if err != nil || !handler.IsOrganizationMember(organizationID, id) {
+			return nil, fmt.Errorf("user %s is not a member of the organization", m.Value)
+		}
+		if id == t.ID {
+			return nil, errors.New("SCIM clients can't change their own roles")
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+// scimGroupMembers returns the users that have the role in organizationID, leaving out the SCIM client.
+func scimGroupMembers(t *dao.OrganizationUser, handler dao.DaoHandler, organizationID, roleID int64) map[int64]bool {
+	ret := make(map[int64]bool)
+	for _, id := range handler.LoadRoleMembers(organizationID, roleID) {
+		if id != t.ID {
+			ret[id] = true
+		}
+	}
+	return ret
+}
+
+// setScimGroupMembers makes members the users that have the role in organizationID. Like any other change of roles
+// it can't grant permissions the SCIM client doesn't hold or break a static separation of duty constraint, and dual
+// control policies apply. It returns true, with the result of the captured operation if any, when the handler must
+// return without going on, having written the response.
+func setScimGroupMembers(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context, organizationID int64, r *dao.Role, members map[int64]bool) (*WebAppOperationResult, bool) {
+	current := scimGroupMembers(t, handler, organizationID, r.ID)
+	added, removed := make([]int64, 0), make([]int64, 0)
+	for id := range members {
+		if !current[id] {
+			added = append(added, id)
+		}
+	}
+	for id := range current {
+		if !members[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, false
+	}
+
+	if len(added) > 0 && !isPrivilegeCeilingExempt(t, handler) && len(handler.RolesExceedingUserPermissions(t.ID, organizationID, []string{r.DisplayName})) > 0 {
+		scimError(c, http.StatusForbidden, "", fmt.Sprintf("not allowed to grant permissions you don't hold: %s", r.DisplayName))
+		return nil, true
+	}
+	if deferred := deferForDualControl(t, handler, c, organizationID, UserUpdatePermission); deferred != nil {
+		return deferred, true
+	}
+	if err := handler.ChangeRoleMembers(organizationID, r.ID, added, removed); errors.Is(err, dao.ErrSeparationOfDuty) {
+		scimError(c, http.StatusConflict, "", err.Error())
+		return nil, true
+	}
+	return nil, false
+}
+
+func scimGroupAuditResult(r *dao.Role, organizationID int64, action string) *WebAppOperationResult {
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"roleID": r.ID, "organizationID": organizationID},
+		AuditHumanReadable: fmt.Sprintf("scim %s members of %s in organization %d", action, r.DisplayName, organizationID),
+	}
+}
+
+// ScimGroupsGetHandler lists the roles as groups.
+func ScimGroupsGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserReadPermission)
+	if organizationID == 0 {
+		return nil
+	}
+
+	attribute, value, ok := parseScimFilter(c.Query("filter"))
+	if !ok || (attribute != "" && attribute != "displayname") {
+		scimError(c, http.StatusBadRequest, "invalidFilter", "only displayName eq filters are supported")
+		return nil
+	}
+
+	withMembers := !strings.Contains(c.Query("excludedAttributes"), "members")
+	resources := make([]interface{}, 0)
+	for _, r := range handler.LoadRoles() {
+		if attribute != "" && !strings.EqualFold(r.DisplayName, value) {
+			continue
+		}
+		resources = append(resources, newScimGroup(s, handler, r, organizationID, withMembers))
+	}
+	scimJSON(c, http.StatusOK, scimListResponse(c, resources))
+	return nil
+}
+
+// ScimGroupGetHandler returns a single role as a group.
+func ScimGroupGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserReadPermission)
+	if organizationID == 0 {
+		return nil
+	}
+	r := loadScimRole(handler, c.Param("id"))
+	if r == nil {
+		scimError(c, http.StatusNotFound, "", "group not found")
+		return nil
+	}
+	scimJSON(c, http.StatusOK, newScimGroup(s, handler, r, organizationID, true))
+	return nil
+}
+
+// ScimGroupPostHandler maps a group onto the existing role with the same name, roles can't be created over SCIM.
+func ScimGroupPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserUpdatePermission)
+	if organizationID == 0 {
+		return nil
+	}
+
+	var request ScimGroup
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return nil
+	}
+	var role *dao.Role
+	for _, r := range handler.LoadRoles() {
+		if strings.EqualFold(r.DisplayName, request.DisplayName) {
+			role = r
+		}
+	}
+	if role == nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", fmt.Sprintf("groups are roles, there is no role named %s", request.DisplayName))
+		return nil
+	}
+
+	members, err := scimMemberIDs(t, handler, organizationID, request.Members)
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return nil
+	}
+	wanted := scimGroupMembers(t, handler, organizationID, role.ID)
+	for _, id := range members {
+		wanted[id] = true
+	}
+	if result, done := setScimGroupMembers(t, handler, c, organizationID, role, wanted); done {
+		return result
+	}
+
+	scimJSON(c, http.StatusCreated, newScimGroup(s, handler, role, organizationID, true))
+	return scimGroupAuditResult(role, organizationID, "added")
+}
+
+// ScimGroupPutHandler replaces the members of a group.
+func ScimGroupPutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserUpdatePermission)
+	if organizationID == 0 {
+		return nil
+	}
+	r := loadScimRole(handler, c.Param("id"))
+	if r == nil {
+		scimError(c, http.StatusNotFound, "", "group not found")
+		return nil
+	}
+
+	var request ScimGroup
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return nil
+	}
+	if request.DisplayName != "" && !strings.EqualFold(request.DisplayName, r.DisplayName) {
+		scimError(c, http.StatusBadRequest, "mutability", "groups are roles and can't be renamed")
+		return nil
+	}
+	members, err := scimMemberIDs(t, handler, organizationID, request.Members)
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return nil
+	}
+
+	wanted := make(map[int64]bool)
+	for _, id := range members {
+		wanted[id] = true
+	}
+	if result, done := setScimGroupMembers(t, handler, c, organizationID, r, wanted); done {
+		return result
+	}
+	scimJSON(c, http.StatusOK, newScimGroup(s, handler, r, organizationID, true))
+	return scimGroupAuditResult(r, organizationID, "replaced")
+}
+
+// ScimGroupPatchHandler adds and removes members of a group.
+func ScimGroupPatchHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserUpdatePermission)
+	if organizationID == 0 {
+		return nil
+	}
+	r := loadScimRole(handler, c.Param("id"))
+	if r == nil {
+		scimError(c, http.StatusNotFound, "", "group not found")
+		return nil
+	}
+
+	var request ScimPatchRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return nil
+	}
+
+	// The operations are applied to the members first, the roles change at once when they all could be applied.
+	wanted := scimGroupMembers(t, handler, organizationID, r.ID)
+	for _, op := range request.Operations {
+		var references []ScimReference
+		path := op.Path
+		if path == "" {
+			// The whole group was sent, only its members can change.
+			var group ScimGroup
+			if err := json.Unmarshal(op.Value, &group); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				return nil
+			}
+			if group.DisplayName != "" && !strings.EqualFold(group.DisplayName, r.DisplayName) {
+				scimError(c, http.StatusBadRequest, "mutability", "groups are roles and can't be renamed")
+				return nil
+			}
+			if group.Members == nil {
+				continue
+			}
+			path, references = "members", group.Members
+		} else if m := scimMemberFilterRegex.FindStringSubmatch(path); m != nil {
+			path, references = "members", []ScimReference{{Value: m[1]}}
+		} else if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &references); err != nil {
+				scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				return nil
+			}
+		}
+		if !strings.EqualFold(path, "members") {
+			scimError(c, http.StatusBadRequest, "invalidPath", fmt.Sprintf("%s can't be changed", path))
+			return nil
+		}
+
+		members, err := scimMemberIDs(t, handler, organizationID, references)
+		if err != nil {
+			scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return nil
+		}
+		switch strings.ToLower(op.Op) {
+		case "add":
+			for _, id := range members {
+				wanted[id] = true
+			}
+		case "remove":
+			if op.Path == "members" && len(op.Value) == 0 {
+				wanted = make(map[int64]bool)
+			}
+			for _, id := range members {
+				delete(wanted, id)
+			}
+		case "replace":
+			wanted = make(map[int64]bool)
+			for _, id := range members {
+				wanted[id] = true
+			}
+		default:
+			scimError(c, http.StatusBadRequest, "invalidSyntax", fmt.Sprintf("unknown op %s", op.Op))
+			return nil
+		}
+	}
+	if result, done := setScimGroupMembers(t, handler, c, organizationID, r, wanted); done {
+		return result
+	}
+
+	scimJSON(c, http.StatusOK, newScimGroup(s, handler, r, organizationID, true))
+	return scimGroupAuditResult(r, organizationID, "updated")
+}
+
+// ScimGroupDeleteHandler removes the role from every user that has it in the organization but the SCIM client, the
+// role stays.
+func ScimGroupDeleteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID := scimOrganization(t, handler, c, UserUpdatePermission)
+	if organizationID == 0 {
+		return nil
+	}
+	r := loadScimRole(handler, c.Param("id"))
+	if r == nil {
+		scimError(c, http.StatusNotFound, "", "group not found")
+		return nil
+	}
+
+	if result, done := setScimGroupMembers(t, handler, c, organizationID, r, nil); done {
+		return result
+	}
+	c.Status(http.StatusNoContent)
+	return scimGroupAuditResult(r, organizationID, "removed all")
+}
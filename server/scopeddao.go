@@ -0,0 +1,49 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/genesis32/complianceweb/dao"
+)
+
+// scopedDaoHandler restricts the permissions of a caller that authenticated with a scoped credential,
+// the caller can only use permissions that are both in its scope and granted through its roles.
+type scopedDaoHandler struct {
+	dao.DaoHandler
+	callerID int64
+	scopes   map[string]bool
+}
+
+func newScopedDaoHandler(daoHandler dao.DaoHandler, callerID int64, scope string) dao.DaoHandler {
+	scopes := make(map[string]bool)
+	for _, s := range strings.Fields(scope) {
+		scopes[s] = true
+	}
+	return &scopedDaoHandler{DaoHandler: daoHandler, callerID: callerID, scopes: scopes}
+}
+
+func (d *scopedDaoHandler) inScope(userID int64, permission string) bool {
+	return userID != d.callerID || d.scopes[permission]
+}
+
+func (d *scopedDaoHandler) DoesUserHavePermission(userID, organizationID int64, permission string) bool {
+	return d.inScope(userID, permission) && d.DaoHandler.DoesUserHavePermission(userID, organizationID, permission)
+}
+
+func (d *scopedDaoHandler) DoesUserHaveSystemPermission(userID int64, permission string) bool {
+	return d.inScope(userID, permission) && d.DaoHandler.DoesUserHaveSystemPermission(userID, permission)
+}
+
+func (d *scopedDaoHandler) LoadEffectivePermissions(userID, organizationID int64) map[int64][]string {
+	ret := d.DaoHandler.LoadEffectivePermissions(userID, organizationID)
+	for orgID, permissions := range ret {
+		inScope := make([]string, 0, len(permissions))
+		for _, p := range permissions {
+			if d.inScope(userID, p) {
+				inScope = append(inScope, p)
+			}
+		}
+		ret[orgID] = inScope
+	}
+	return ret
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+func newSeparationOfDutyResponse(constraint *dao.SeparationOfDuty) *SeparationOfDutyResponse {
+	return &SeparationOfDutyResponse{
+		ID:                  constraint.ID,
+		OrganizationID:      constraint.OrganizationID,
+		RoleName:            constraint.RoleName,
+		ConflictingRoleName: constraint.ConflictingRoleName,
+		Dynamic:             constraint.Dynamic,
+		Created:             constraint.CreatedTimestamp,
+	}
+}
+
+// SeparationOfDutyApiPostHandler adds a separation of duty constraint to an organization.
+func SeparationOfDutyApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var constraintRequest SeparationOfDutyRequest
+	if err := c.ShouldBind(&constraintRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("separation of duty format: %s", err.Error()))
+		return nil
+	}
+
+	if !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	if constraintRequest.RoleName == constraintRequest.ConflictingRoleName {
+		c.String(http.StatusBadRequest, "a role can't conflict with itself")
+		return nil
+	}
+	if !handler.HasValidRoles([]string{constraintRequest.RoleName, constraintRequest.ConflictingRoleName}) {
+		c.String(http.StatusBadRequest, "contains at least one invalid role.")
+		return nil
+	}
+
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	constraint := &dao.SeparationOfDuty{
+		ID:                  utils.GetNextUniqueId(),
+		OrganizationID:      organizationID,
+		RoleName:            constraintRequest.RoleName,
+		ConflictingRoleName: constraintRequest.ConflictingRoleName,
+		Dynamic:             constraintRequest.Dynamic,
+		CreatedTimestamp:    time.Now().UTC(),
+	}
+	handler.CreateSeparationOfDuty(constraint)
+	c.JSON(http.StatusCreated, newSeparationOfDutyResponse(constraint))
+
+	return &WebAppOperationResult{
+		AuditMetadata: WebappOperationMetadata{
+			"separationOfDutyID": constraint.ID,
+			"organizationID":     organizationID,
+			"roles":              []string{constraint.RoleName, constraint.ConflictingRoleName},
+			"dynamic":            constraint.Dynamic,
+		},
+		AuditHumanReadable: fmt.Sprintf("made %s and %s mutually exclusive in organization %d", constraint.RoleName, constraint.ConflictingRoleName, organizationID),
+	}
+}
+
+// SeparationOfDutyApiGetHandler lists the constraints that apply in an organization's ancestors and subtree.
+func SeparationOfDutyApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.CanUserViewOrg(t.ID, organizationID) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	response := make([]*SeparationOfDutyResponse, 0)
+	for _, constraint := range handler.LoadSeparationOfDutiesForOrganization(organizationID) {
+		response = append(response, newSeparationOfDutyResponse(constraint))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// SeparationOfDutyApiDeleteHandler removes a separation of duty constraint.
+func SeparationOfDutyApiDeleteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	if !handler.DoesUserHaveSystemPermission(t.ID, SystemUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	constraintID, _ := utils.StringToInt64(c.Param("constraintID"))
+	constraint := handler.LoadSeparationOfDuty(constraintID)
+	if constraint == nil {
+		c.String(http.StatusNotFound, "separation of duty not found")
+		return nil
+	}
+	handler.DeleteSeparationOfDuty(constraint.ID)
+	c.Status(http.StatusOK)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"separationOfDutyID": constraint.ID, "organizationID": constraint.OrganizationID},
+		AuditHumanReadable: fmt.Sprintf("removed separation of %s and %s in organization %d", constraint.RoleName, constraint.ConflictingRoleName, constraint.OrganizationID),
+	}
+}
+
+// SeparationOfDutyViolationsApiGetHandler reports the users that hold both roles of a constraint that applies in
+// the organization.
+func SeparationOfDutyViolationsApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.DoesUserHavePermission(t.ID, organizationID, UserReadPermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	response := make([]*SeparationOfDutyViolationResponse, 0)
+	for _, v := range handler.LoadSeparationOfDutyViolations(organizationID) {
+		response = append(response, &SeparationOfDutyViolationResponse{
+			SeparationOfDutyID:        v.SeparationOfDutyID,
+			Dynamic:                   v.Dynamic,
+			UserID:                    v.OrganizationUserID,
+			OrganizationID:            v.OrganizationID,
+			RoleName:                  v.RoleName,
+			ConflictingOrganizationID: v.ConflictingOrganizationID,
+			ConflictingRoleName:       v.ConflictingRoleName,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
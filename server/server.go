@@ -1,12 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"encoding/gob"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/genesis32/complianceweb/auth"
@@ -22,8 +26,11 @@ type Server struct {
 	Dao                 dao.DaoHandler
 	SessionStore        sessions.Store
 	Authenticator       auth.Authenticator
+	APIKeyAuthenticator *auth.APIKeyAuthenticator
+	TokenIssuer         *auth.LocalTokenIssuer
 	router              *gin.Engine
 	registeredResources dao.RegisteredResourcesStore
+	stopBackgroundJobs  chan struct{}
 }
 
 type WebappOperationMetadata map[string]interface{}
@@ -67,16 +74,106 @@ func loadConfiguration(daoHandler dao.DaoHandler) *Configuration {
 	}
 
 	{
-		dbSettings := daoHandler.GetSettings(OIDCIssuerBaseURLConfigurationKey, Auth0ClientIDConfigurationKey, Auth0ClientSecretConfigurationKey, SystemBaseURLConfigurationKey)
-		if len(dbSettings) != 4 {
-			log.Fatal("parameters not loaded. Do all oidc configuration parameters exist in the db?")
+		dbSettings := daoHandler.GetSettings(SystemBaseURLConfigurationKey)
+		if len(dbSettings) != 1 {
+			log.Fatal("parameters not loaded. Does the system base url exist in the db?")
 		}
-		ret.OIDCIssuer = dbSettings[OIDCIssuerBaseURLConfigurationKey].Value
-		ret.Auth0ClientID = dbSettings[Auth0ClientIDConfigurationKey].Value
-		ret.Auth0ClientSecret = dbSettings[Auth0ClientSecretConfigurationKey].Value
 		ret.SystemBaseUrl = dbSettings[SystemBaseURLConfigurationKey].Value
 	}
 
+	ret.OIDCProviders = loadOIDCProviderConfigurations(daoHandler)
+
+	ret.TokenLifetime = loadDurationSetting(daoHandler, TokenLifetimeConfigurationKey, DefaultTokenLifetimeMinutes, time.Minute)
+	ret.SigningKeyRotation = loadDurationSetting(daoHandler, SigningKeyRotationConfigurationKey, DefaultSigningKeyRotationInDays, 24*time.Hour)
+	ret.SessionLifetime = loadDurationSetting(daoHandler, SessionLifetimeConfigurationKey, DefaultSessionLifetimeInHours, time.Hour)
+
+	return ret
+}
+
+// loadDurationSetting reads a setting holding a positive number of units, falling back to defaultValue.
+func loadDurationSetting(daoHandler dao.DaoHandler, key string, defaultValue int64, unit time.Duration) time.Duration {
+	value := defaultValue
+	if setting, ok := daoHandler.GetSettings(key)[key]; ok {
+		v, err := utils.StringToInt64(setting.Value)
+		if err != nil || v <= 0 {
+			log.Fatalf("%s must be a positive number", key)
+		}
+		value = v
+	}
+	return time.Duration(value) * unit
+}
+
+// LegacyOIDCProviderName is the name of the provider of installs from before multiple providers were supported.
+const LegacyOIDCProviderName = "auth0"
+
+// assignLegacyIdentityIssuer gives the identities linked before issuers were recorded, which all came from the
+// legacy auth0 tenant, that provider's issuer so their users can still log in.
+func assignLegacyIdentityIssuer(daoHandler dao.DaoHandler, authenticator auth.Authenticator) {
+	oidcAuthenticator, ok := authenticator.(*auth.OIDCAuthenticator)
+	if !ok {
+		return
+	}
+	p := oidcAuthenticator.ProviderByName(LegacyOIDCProviderName)
+	if p == nil || p.Type != auth.Auth0ProviderType {
+		return
+	}
+	if n := daoHandler.AssignLegacyIdentityIssuer(auth.Auth0ProviderType, p.Issuer); n > 0 {
+		log.Printf("assigned issuer %s to %d identities linked before issuers were recorded", p.Issuer, n)
+	}
+}
+
+func loadOIDCProviderConfigurations(daoHandler dao.DaoHandler) []auth.OIDCProviderConfiguration {
+	providers := make(map[string]*auth.OIDCProviderConfiguration)
+	for k, v := range daoHandler.GetSettingsWithPrefix(OIDCProviderConfigurationKeyPrefix) {
+		i := strings.LastIndex(k, ".")
+		name := strings.TrimPrefix(k[:i], OIDCProviderConfigurationKeyPrefix)
+		if name == "" {
+			log.Printf("ignoring oidc provider setting %s with no name", k)
+			continue
+		}
+		p, ok := providers[name]
+		if !ok {
+			p = &auth.OIDCProviderConfiguration{Name: name}
+			providers[name] = p
+		}
+		switch k[i:] {
+		case OIDCProviderTypeConfigurationKeySuffix:
+			p.Type = strings.ToUpper(v.Value)
+		case OIDCProviderIssuerConfigurationKeySuffix:
+			p.IssuerURL = v.Value
+		case OIDCProviderClientIDConfigurationKeySuffix:
+			p.ClientID = v.Value
+		case OIDCProviderClientSecretConfigurationKeySuffix:
+			p.ClientSecret = v.Value
+		case OIDCProviderScopesConfigurationKeySuffix:
+			p.Scopes = strings.Fields(v.Value)
+		case OIDCProviderAlgorithmsConfigurationKeySuffix:
+			p.SigningAlgorithms = strings.Fields(v.Value)
+		default:
+			log.Printf("ignoring unknown oidc provider setting %s", k)
+		}
+	}
+
+	// Installs from before multiple providers were supported only have a single auth0 tenant.
+	legacySettings := daoHandler.GetSettings(OIDCIssuerBaseURLConfigurationKey, Auth0ClientIDConfigurationKey, Auth0ClientSecretConfigurationKey)
+	if _, exists := providers[LegacyOIDCProviderName]; !exists && len(legacySettings) == 3 {
+		providers[LegacyOIDCProviderName] = &auth.OIDCProviderConfiguration{
+			Name:         LegacyOIDCProviderName,
+			Type:         auth.Auth0ProviderType,
+			IssuerURL:    legacySettings[OIDCIssuerBaseURLConfigurationKey].Value,
+			ClientID:     legacySettings[Auth0ClientIDConfigurationKey].Value,
+			ClientSecret: legacySettings[Auth0ClientSecretConfigurationKey].Value,
+		}
+	}
+
+	ret := make([]auth.OIDCProviderConfiguration, 0, len(providers))
+	for _, p := range providers {
+		if p.Type == "" || p.IssuerURL == "" || p.ClientID == "" {
+			log.Fatalf("oidc provider %s requires a type, issuer and clientid", p.Name)
+		}
+		ret = append(ret, *p)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
 	return ret
 }
 
@@ -90,24 +187,41 @@ func NewServer() *Server {
 
 	config := loadConfiguration(daoHandler)
 
-	// We aren't even using this anymore but we'll keep it around just incase
+	// The cookie only carries the login state and the session id, sessions themselves are kept in the database.
 	sessionStore := sessions.NewCookieStore(config.CookieAuthenticationKey, config.CookieEncryptionKey)
 	sessionStore.Options.MaxAge = 0
+	sessionStore.Options.HttpOnly = true
+	sessionStore.Options.SameSite = http.SameSiteLaxMode
+	sessionStore.Options.Secure = strings.HasPrefix(config.SystemBaseUrl, "https://")
 
 	callbackUrl := fmt.Sprintf("%s/webapp/callback", config.SystemBaseUrl)
 
 	var authenticator auth.Authenticator
 	if v, ok := os.LookupEnv("ENV"); ok && v == "test" {
-		authenticator = auth.NewTestAuthenticator()
+		var err error
+		authenticator, err = auth.NewTestAuthenticator()
+		if err != nil {
+			log.Fatal(err)
+		}
 	} else {
-		authenticator = auth.NewAuth0Authenticator(callbackUrl, config.OIDCIssuer, config.Auth0ClientID, config.Auth0ClientSecret)
+		if len(config.OIDCProviders) == 0 {
+			log.Fatal("no oidc providers configured")
+		}
+		authenticator = auth.NewOIDCAuthenticator(callbackUrl, config.OIDCProviders...)
+		assignLegacyIdentityIssuer(daoHandler, authenticator)
+	}
+
+	tokenIssuer := auth.NewLocalTokenIssuer(daoHandler, config.TokenLifetime)
+	if err := tokenIssuer.EnsureSigningKey(config.SigningKeyRotation); err != nil {
+		log.Fatal(err)
 	}
 
-	return &Server{Config: config, SessionStore: sessionStore, Dao: daoHandler, Authenticator: authenticator}
+	return &Server{Config: config, SessionStore: sessionStore, Dao: daoHandler, Authenticator: authenticator, APIKeyAuthenticator: auth.NewAPIKeyAuthenticator(daoHandler), TokenIssuer: tokenIssuer, stopBackgroundJobs: make(chan struct{})}
 }
 
 // Shutdown the server
 func (s *Server) Shutdown() error {
+	close(s.stopBackgroundJobs)
 	err := s.Dao.Close()
 	return err
 }
@@ -119,18 +233,56 @@ func (s *Server) registerAPI(fn webAppFunc) func(c *gin.Context) {
 func (s *Server) registerAPIA(authenticationRequired bool, fn webAppFunc) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		var userInfo *dao.OrganizationUser
-		if authenticationRequired {
+		conditionalHandler := newConditionalDaoHandler(s.Dao, c)
+		daoHandler := conditionalHandler
+		if operation := approvedOperation(c); authenticationRequired && operation != nil {
+			// An approved operation runs as the user that requested it, with the scope it had then.
+			userInfo = s.Dao.LoadUserFromID(operation.OrganizationUserID)
+			if userInfo == nil || userInfo.CurrentState != dao.UserActiveState {
+				c.String(http.StatusForbidden, "User does not exist")
+				return
+			}
+			if operation.Scope != "" {
+				daoHandler = newScopedDaoHandler(conditionalHandler, userInfo.ID, operation.Scope)
+			}
+		} else if userSession, ok := c.Get("authenticated_user_session"); authenticationRequired && ok {
+			userInfo = s.Dao.LoadUserFromID(userSession.(*dao.UserSession).OrganizationUserID)
+			if userInfo == nil || userInfo.CurrentState != dao.UserActiveState {
+				c.String(http.StatusForbidden, "User does not exist")
+				return
+			}
+		} else if authenticationRequired {
 			subject, ok := c.Get("authenticated_user_profile")
 			if !ok {
 				c.String(http.StatusForbidden, "User credential not supplied.")
 				return
 			}
 
-			userInfo = s.Dao.LoadUserFromCredential(subject.(utils.OpenIDClaims)["sub"].(string), dao.UserActiveState)
+			claims := subject.(utils.OpenIDClaims)
+			issuer, _ := claims["iss"].(string)
+			sub, _ := claims["sub"].(string)
+			userInfo = s.Dao.LoadUserFromCredential(issuer, sub, dao.UserActiveState)
 			if userInfo == nil {
 				c.String(http.StatusForbidden, "User does not exist")
 				return
 			}
+
+			// Credentials the service issued itself are always scoped, without scopes they grant nothing.
+			if scope, _ := claims[auth.ScopeClaim].(string); scope != "" || issuer == auth.LocalIssuer {
+				daoHandler = newScopedDaoHandler(conditionalHandler, userInfo.ID, scope)
+				c.Set(credentialScopeKey, scope)
+			}
+		}
+
+		// Keep the body so the request can be captured for dual control after the handler bound it.
+		if c.Request.Method != http.MethodGet && c.Request.Body != nil {
+			body, err := ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				c.String(http.StatusBadRequest, "error reading request")
+				return
+			}
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			c.Set(requestBodyKey, body)
 		}
 
 		auditRecord := dao.NewAuditRecord("webapp", c.Request.Method)
@@ -142,7 +294,7 @@ func (s *Server) registerAPIA(authenticationRequired bool, fn webAppFunc) func(c
 
 		s.Dao.CreateAuditRecord(auditRecord)
 
-		operationResult := fn(userInfo, s, s.SessionStore, s.Dao, c)
+		operationResult := fn(userInfo, s, s.SessionStore, daoHandler, c)
 
 		// TODO: Fix this so it's required in the future
 		if operationResult != nil {
@@ -156,15 +308,38 @@ func (s *Server) registerAPIA(authenticationRequired bool, fn webAppFunc) func(c
 
 func validOIDCTokenRequired(s *Server) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if approvedOperation(c) != nil {
+			c.Next()
+			return
+		}
+
 		authorizationHeader := c.GetHeader("Authorization")
 
 		if authorizationHeader != "" {
-			profile, err := s.Authenticator.ValidateAuthorizationHeader(authorizationHeader)
+			var authenticator auth.Authenticator
+			switch {
+			case auth.IsAPIKeyHeader(authorizationHeader):
+				authenticator = s.APIKeyAuthenticator
+			case auth.IsLocalTokenHeader(authorizationHeader):
+				authenticator = s.TokenIssuer
+			default:
+				authenticator = s.Authenticator
+			}
+			profile, err := authenticator.ValidateAuthorizationHeader(authorizationHeader)
 			if err == nil && profile != nil {
 				c.Set("authenticated_user_profile", profile)
 				c.Next()
 				return
 			}
+		} else if userSession := loadUserSession(s, s.SessionStore, c); userSession != nil {
+			if !isSafeMethod(c.Request.Method) && c.GetHeader(SessionRequestHeader) == "" {
+				c.String(http.StatusForbidden, fmt.Sprintf("%s header required", SessionRequestHeader))
+				c.Abort()
+				return
+			}
+			c.Set("authenticated_user_session", userSession)
+			c.Next()
+			return
 		}
 		c.String(http.StatusUnauthorized, "Not authorized")
 		c.Abort()
@@ -194,6 +369,13 @@ func (s *Server) Initialize() *gin.Engine {
 		c.Redirect(301, "/webapp/")
 	})
 
+	s.router.GET("/.well-known/jwks.json", s.registerAPIA(false, JWKSHandler))
+
+	oauth := s.router.Group("/oauth")
+	{
+		oauth.POST("/token", s.registerAPIA(false, TokenHandler))
+	}
+
 	system := s.router.Group("/system")
 	{
 		system.POST("/bootstrap", s.registerAPIA(false, BootstrapApiPostHandler))
@@ -205,6 +387,7 @@ func (s *Server) Initialize() *gin.Engine {
 		webapp.GET("/invite/:inviteCode", s.registerAPIA(false, InviteHandler))
 		webapp.GET("/login", s.registerAPIA(false, LoginHandler))
 		webapp.GET("/callback", s.registerAPIA(false, CallbackHandler))
+		webapp.POST("/logout", s.registerAPIA(false, LogoutHandler))
 	}
 
 	apiRoutes := s.router.Group("/api")
@@ -217,12 +400,82 @@ func (s *Server) Initialize() *gin.Engine {
 
 		apiRoutes.PUT("/organizations/:organizationID/metadata", s.registerAPI(OrganizationMetadataApiPutHandler))
 		apiRoutes.GET("/organizations/:organizationID/metadata", s.registerAPI(OrganizationMetadataApiGetHandler))
+		apiRoutes.POST("/organizations/:organizationID/groups", s.registerAPI(UserGroupApiPostHandler))
+		apiRoutes.GET("/organizations/:organizationID/groups", s.registerAPI(UserGroupsApiGetHandler))
+		apiRoutes.GET("/groups/:groupID", s.registerAPI(UserGroupApiGetHandler))
+		apiRoutes.DELETE("/groups/:groupID", s.registerAPI(UserGroupApiDeleteHandler))
+		apiRoutes.PUT("/groups/:groupID/members", s.registerAPI(UserGroupMembersApiPutHandler))
+		apiRoutes.PUT("/groups/:groupID/roles", s.registerAPI(UserGroupRolesApiPutHandler))
 
 		apiRoutes.POST("/users", s.registerAPI(UserAPIPostHandler))
 		apiRoutes.GET("/users/:userID", s.registerAPI(UserApiGetHandler))
 		apiRoutes.GET("/me", s.registerAPI(MeApiGetHandler))
 		apiRoutes.PUT("/users/:userID", s.registerAPI(UserApiPutHandler))
 		apiRoutes.PUT("/users/:userID/roles", s.registerAPI(UserRoleApiPostHandler))
+		apiRoutes.POST("/users/:userID/apikeys", s.registerAPI(APIKeyApiPostHandler))
+		apiRoutes.GET("/users/:userID/apikeys", s.registerAPI(APIKeyApiGetHandler))
+		apiRoutes.POST("/users/:userID/apikeys/:keyID/rotate", s.registerAPI(APIKeyRotateApiPostHandler))
+		apiRoutes.DELETE("/users/:userID/apikeys/:keyID", s.registerAPI(APIKeyApiDeleteHandler))
+
+		apiRoutes.DELETE("/users/:userID/sessions", s.registerAPI(UserSessionsApiDeleteHandler))
+		apiRoutes.GET("/users/:userID/identities", s.registerAPI(UserIdentitiesApiGetHandler))
+		apiRoutes.POST("/users/:userID/identities", s.registerAPI(UserIdentityApiPostHandler))
+		apiRoutes.DELETE("/users/:userID/identities/:identityID", s.registerAPI(UserIdentityApiDeleteHandler))
+
+		apiRoutes.POST("/permissiontokens", s.registerAPI(PermissionTokenApiPostHandler))
+
+		apiRoutes.POST("/elevations", s.registerAPI(ElevationApiPostHandler))
+		apiRoutes.GET("/elevations", s.registerAPI(ElevationsApiGetHandler))
+		apiRoutes.GET("/organizations/:organizationID/elevations", s.registerAPI(OrganizationElevationsApiGetHandler))
+		apiRoutes.POST("/elevations/:elevationID/approve", s.registerAPI(ElevationApproveApiPostHandler))
+		apiRoutes.POST("/elevations/:elevationID/deny", s.registerAPI(ElevationDenyApiPostHandler))
+
+		apiRoutes.PUT("/organizations/:organizationID/dualcontrol", s.registerAPI(DualControlPoliciesApiPutHandler))
+		apiRoutes.GET("/organizations/:organizationID/dualcontrol", s.registerAPI(DualControlPoliciesApiGetHandler))
+		apiRoutes.GET("/organizations/:organizationID/operations", s.registerAPI(PendingOperationsApiGetHandler))
+		apiRoutes.GET("/operations/:operationID", s.registerAPI(PendingOperationApiGetHandler))
+		apiRoutes.POST("/operations/:operationID/approve", s.registerAPI(PendingOperationApproveApiPostHandler))
+		apiRoutes.POST("/operations/:operationID/deny", s.registerAPI(PendingOperationDenyApiPostHandler))
+
+		apiRoutes.PUT("/users/:userID/breakglass", s.registerAPI(BreakGlassAccountApiPutHandler))
+		apiRoutes.POST("/breakglass", s.registerAPI(BreakGlassApiPostHandler))
+		apiRoutes.GET("/breakglass", s.registerAPI(BreakGlassApiGetHandler))
+		apiRoutes.POST("/breakglass/:accessID/end", s.registerAPI(BreakGlassEndApiPostHandler))
+		apiRoutes.POST("/breakglass/:accessID/review", s.registerAPI(BreakGlassReviewApiPostHandler))
+
+		apiRoutes.POST("/organizations/:organizationID/separationofduty", s.registerAPI(SeparationOfDutyApiPostHandler))
+		apiRoutes.GET("/organizations/:organizationID/separationofduty", s.registerAPI(SeparationOfDutyApiGetHandler))
+		apiRoutes.GET("/organizations/:organizationID/separationofduty/violations", s.registerAPI(SeparationOfDutyViolationsApiGetHandler))
+		apiRoutes.DELETE("/separationofduty/:constraintID", s.registerAPI(SeparationOfDutyApiDeleteHandler))
+
+		apiRoutes.POST("/organizations/:organizationID/denies", s.registerAPI(PermissionDenyApiPostHandler))
+		apiRoutes.GET("/organizations/:organizationID/denies", s.registerAPI(PermissionDeniesApiGetHandler))
+		apiRoutes.DELETE("/denies/:denyID", s.registerAPI(PermissionDenyApiDeleteHandler))
+		apiRoutes.GET("/users/:userID/permissions/explain", s.registerAPI(PermissionExplainApiGetHandler))
+
+		apiRoutes.GET("/organizations/:organizationID/ladon", s.registerAPI(LadonPoliciesApiGetHandler))
+		apiRoutes.GET("/organizations/:organizationID/opa/bundle.tar.gz", s.registerAPI(OpaBundleApiGetHandler))
+		apiRoutes.POST("/organizations/:organizationID/import", s.registerAPI(ImportApiPostHandler))
+	}
+
+	scimRoutes := s.router.Group("/scim/v2")
+	scimRoutes.Use(validOIDCTokenRequired(s))
+	{
+		scimRoutes.GET("/ServiceProviderConfig", s.registerAPI(ScimServiceProviderConfigHandler))
+
+		scimRoutes.GET("/Users", s.registerAPI(ScimUsersGetHandler))
+		scimRoutes.POST("/Users", s.registerAPI(ScimUserPostHandler))
+		scimRoutes.GET("/Users/:id", s.registerAPI(ScimUserGetHandler))
+		scimRoutes.PUT("/Users/:id", s.registerAPI(ScimUserPutHandler))
+		scimRoutes.PATCH("/Users/:id", s.registerAPI(ScimUserPatchHandler))
+		scimRoutes.DELETE("/Users/:id", s.registerAPI(ScimUserDeleteHandler))
+
+		scimRoutes.GET("/Groups", s.registerAPI(ScimGroupsGetHandler))
+		scimRoutes.POST("/Groups", s.registerAPI(ScimGroupPostHandler))
+		scimRoutes.GET("/Groups/:id", s.registerAPI(ScimGroupGetHandler))
+		scimRoutes.PUT("/Groups/:id", s.registerAPI(ScimGroupPutHandler))
+		scimRoutes.PATCH("/Groups/:id", s.registerAPI(ScimGroupPatchHandler))
+		scimRoutes.DELETE("/Groups/:id", s.registerAPI(ScimGroupDeleteHandler))
 	}
 
 	return s.router
@@ -230,6 +483,14 @@ func (s *Server) Initialize() *gin.Engine {
 
 // Serve the traffic
 func (s *Server) Serve() {
+	s.startBackgroundJob(auditArchiveInterval, ArchiveAuditRecordsJob)
+	s.startBackgroundJob(loginStateLifetime, PurgeLoginStatesJob)
+	s.startBackgroundJob(signingKeyCheckInterval, RotateSigningKeysJob)
+	s.startBackgroundJob(userSessionPurgeInterval, PurgeUserSessionsJob)
+	s.startBackgroundJob(roleExpiryInterval, ExpireRoleAssignmentsJob)
+	s.startBackgroundJob(roleExpiryInterval, ExpireElevationRequestsJob)
+	s.startBackgroundJob(breakGlassReviewInterval, RemindBreakGlassReviewsJob)
+
 	err := s.router.Run()
 	if err != nil {
 		log.Fatal(err)
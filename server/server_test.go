@@ -0,0 +1,108 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/auth/mockoidc"
+	"github.com/genesis32/complianceweb/dao"
+)
+
+// settingsDao keeps settings in memory and records the legacy issuer assigned, every other method panics.
+type settingsDao struct {
+	dao.DaoHandler
+	settings       dao.SettingsStore
+	assignedIssuer map[string]string
+}
+
+func newSettingsDao(settings map[string]string) *settingsDao {
+	ret := &settingsDao{settings: make(dao.SettingsStore), assignedIssuer: make(map[string]string)}
+	for k, v := range settings {
+		ret.settings[k] = &dao.Setting{Key: k, Value: v}
+	}
+	return ret
+}
+
+func (d *settingsDao) GetSettings(keys ...string) dao.SettingsStore {
+	ret := make(dao.SettingsStore)
+	for _, k := range keys {
+		if s, ok := d.settings[k]; ok {
+			ret[k] = s
+		}
+	}
+	return ret
+}
+
+func (d *settingsDao) GetSettingsWithPrefix(prefix string) dao.SettingsStore {
+	ret := make(dao.SettingsStore)
+	for k, s := range d.settings {
+		if len(k) >= len(prefix) && k[:len(prefix)] == prefix {
+			ret[k] = s
+		}
+	}
+	return ret
+}
+
+func (d *settingsDao) AssignLegacyIdentityIssuer(idpType, idpIssuer string) int64 {
+	d.assignedIssuer[idpType] = idpIssuer
+	return 1
+}
+
+func TestLoadOIDCProviderConfigurations(t *testing.T) {
+	legacy := map[string]string{
+		OIDCIssuerBaseURLConfigurationKey: "https://tenant.auth0.com/",
+		Auth0ClientIDConfigurationKey:     "legacyclient",
+		Auth0ClientSecretConfigurationKey: "legacysecret",
+	}
+	settings := map[string]string{
+		"oidc.provider.okta.type":         "okta",
+		"oidc.provider.okta.issuer":       "https://acme.okta.com",
+		"oidc.provider.okta.clientid":     "oktaclient",
+		"oidc.provider.okta.scopes":       "openid  email",
+		"oidc.provider.okta.unknownfield": "ignored",
+	}
+	for k, v := range legacy {
+		settings[k] = v
+	}
+
+	got := loadOIDCProviderConfigurations(newSettingsDao(settings))
+	want := []auth.OIDCProviderConfiguration{
+		{Name: LegacyOIDCProviderName, Type: auth.Auth0ProviderType, IssuerURL: "https://tenant.auth0.com/", ClientID: "legacyclient", ClientSecret: "legacysecret"},
+		{Name: "okta", Type: "OKTA", IssuerURL: "https://acme.okta.com", ClientID: "oktaclient", Scopes: []string{"openid", "email"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+
+	// A configured auth0 provider takes the place of the legacy settings.
+	settings["oidc.provider.auth0.type"] = auth.Auth0ProviderType
+	settings["oidc.provider.auth0.issuer"] = "https://other.auth0.com/"
+	settings["oidc.provider.auth0.clientid"] = "newclient"
+	got = loadOIDCProviderConfigurations(newSettingsDao(settings))
+	if len(got) != 2 || got[0].IssuerURL != "https://other.auth0.com/" || got[0].ClientID != "newclient" {
+		t.Fatalf("legacy settings used over the configured provider: %+v", got)
+	}
+}
+
+func TestAssignLegacyIdentityIssuer(t *testing.T) {
+	_, providerServer, err := mockoidc.NewTestServer("client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer providerServer.Close()
+
+	for name, want := range map[string]string{LegacyOIDCProviderName: providerServer.URL, "other": ""} {
+		d := newSettingsDao(nil)
+		authenticator := auth.NewOIDCAuthenticator("http://localhost/webapp/callback", auth.OIDCProviderConfiguration{
+			Name:      name,
+			Type:      auth.Auth0ProviderType,
+			IssuerURL: providerServer.URL,
+			ClientID:  "client",
+		})
+		assignLegacyIdentityIssuer(d, authenticator)
+		if got := d.assignedIssuer[auth.Auth0ProviderType]; got != want {
+			t.Errorf("provider %s: assigned issuer %q want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// ClientCredentialsGrantType is the only grant the token endpoint supports.
+const ClientCredentialsGrantType = "client_credentials"
+
+func tokenError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, &TokenErrorResponse{Error: code, ErrorDescription: description})
+}
+
+// tokenScopes narrows the scopes of the api key to the ones requested. A key without scopes grants nothing, so
+// there is nothing to request.
+func tokenScopes(keyScopes, requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		return keyScopes, true
+	}
+	allowed := make(map[string]bool, len(keyScopes))
+	for _, k := range keyScopes {
+		allowed[k] = true
+	}
+	for _, r := range requested {
+		if !allowed[r] {
+			return nil, false
+		}
+	}
+	return requested, true
+}
+
+// TokenHandler implements the OAuth2 client credentials grant for service principals. The client id is the
+// service principal id and the client secret is one of its api keys.
+func TokenHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	if c.PostForm("grant_type") != ClientCredentialsGrantType {
+		tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+		return nil
+	}
+
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, clientSecret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	userID, err := utils.StringToInt64(clientID)
+	if err != nil {
+		tokenError(c, http.StatusUnauthorized, "invalid_client", "")
+		return nil
+	}
+	apiKey, err := s.APIKeyAuthenticator.ValidateAPIKey(clientSecret)
+	if err != nil || apiKey.OrganizationUserID != userID {
+		tokenError(c, http.StatusUnauthorized, "invalid_client", "")
+		return nil
+	}
+	servicePrincipal := handler.LoadUserFromID(userID)
+	if servicePrincipal == nil || servicePrincipal.UserType != dao.ServiceUserType || servicePrincipal.CurrentState != dao.UserActiveState {
+		tokenError(c, http.StatusUnauthorized, "invalid_client", "")
+		return nil
+	}
+
+	scopes, ok := tokenScopes(apiKey.Scopes, strings.Fields(c.PostForm("scope")))
+	if !ok {
+		tokenError(c, http.StatusBadRequest, "invalid_scope", "scope must be a subset of the api key scopes")
+		return nil
+	}
+
+	accessToken, expires, err := s.TokenIssuer.IssueToken(clientID, scopes, map[string]interface{}{
+		"client_id":  clientID,
+		"api_key_id": fmt.Sprintf("%d", apiKey.ID),
+	})
+	if err != nil {
+		tokenError(c, http.StatusInternalServerError, "server_error", "")
+		return nil
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(expires).Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	})
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": servicePrincipal.ID, "apiKeyID": apiKey.ID, "scopes": scopes},
+		AuditHumanReadable: fmt.Sprintf("issued access token to service principal %d with api key %d", servicePrincipal.ID, apiKey.ID),
+	}
+}
+
+// JWKSHandler publishes the keys access tokens from the token endpoint can be verified with.
+func JWKSHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	keySet, err := s.TokenIssuer.JSONWebKeySet()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "error loading signing keys")
+		return nil
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keySet)
+	return nil
+}
+
+// PermissionTokenApiPostHandler mints a token with the effective permissions of a user in an organization subtree
+// so downstream services can authorize that user without calling back. Minting one for another user requires
+// permission to read users in the organization.
+func PermissionTokenApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var tokenRequest PermissionTokenRequest
+	if err := c.ShouldBind(&tokenRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("permission token format: %s", err.Error()))
+		return nil
+	}
+	if tokenRequest.UserID == 0 {
+		tokenRequest.UserID = t.ID
+	}
+
+	if !handler.CanUserViewOrg(t.ID, tokenRequest.OrganizationID) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	if tokenRequest.UserID != t.ID && !handler.DoesUserHavePermission(t.ID, tokenRequest.OrganizationID, UserReadPermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	// Inactive users keep their roles, a token would still carry their permissions.
+	if user := handler.LoadUserFromID(tokenRequest.UserID); user == nil || user.CurrentState != dao.UserActiveState {
+		c.String(http.StatusBadRequest, "the user is not active")
+		return nil
+	}
+
+	permissions := handler.LoadEffectivePermissions(tokenRequest.UserID, tokenRequest.OrganizationID)
+	token, expires, err := s.TokenIssuer.IssuePermissionToken(fmt.Sprintf("%d", tokenRequest.UserID), tokenRequest.OrganizationID, permissions)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "error signing token")
+		return nil
+	}
+
+	c.JSON(http.StatusCreated, &PermissionTokenResponse{Token: token, Expires: expires})
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": tokenRequest.UserID, "organizationID": tokenRequest.OrganizationID},
+		AuditHumanReadable: fmt.Sprintf("issued permission token for user %d in organization %d", tokenRequest.UserID, tokenRequest.OrganizationID),
+	}
+}
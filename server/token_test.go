@@ -0,0 +1,25 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenScopes(t *testing.T) {
+	tests := []struct {
+		keyScopes, requested, want []string
+		ok                         bool
+	}{
+		{[]string{"user.read.execute", "user.update.execute"}, nil, []string{"user.read.execute", "user.update.execute"}, true},
+		{[]string{"user.read.execute", "user.update.execute"}, []string{"user.read.execute"}, []string{"user.read.execute"}, true},
+		{[]string{"user.read.execute"}, []string{"user.update.execute"}, nil, false},
+		{nil, []string{"user.read.execute"}, nil, false},
+		{nil, nil, nil, true},
+	}
+	for _, test := range tests {
+		got, ok := tokenScopes(test.keyScopes, test.requested)
+		if ok != test.ok || (ok && !reflect.DeepEqual(got, test.want)) {
+			t.Errorf("key %v requested %v: got %v %v", test.keyScopes, test.requested, got, ok)
+		}
+	}
+}
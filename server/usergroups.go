@@ -0,0 +1,295 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/genesis32/complianceweb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+func newUserGroupResponse(group *dao.UserGroup) *UserGroupResponse {
+	ret := &UserGroupResponse{
+		ID:             group.ID,
+		OrganizationID: group.OrganizationID,
+		DisplayName:    group.DisplayName,
+		Created:        group.CreatedTimestamp,
+	}
+	if group.Roles == nil {
+		return ret
+	}
+
+	ret.Members = &UserGroupMembers{UserIDs: make([]string, 0), GroupIDs: make([]string, 0)}
+	for _, id := range group.MemberUserIDs {
+		ret.Members.UserIDs = append(ret.Members.UserIDs, fmt.Sprintf("%d", id))
+	}
+	for _, id := range group.MemberGroupIDs {
+		ret.Members.GroupIDs = append(ret.Members.GroupIDs, fmt.Sprintf("%d", id))
+	}
+	for orgID, roles := range group.Roles {
+		ret.Roles = append(ret.Roles, newUserOrgRoles(orgID, roles)...)
+	}
+	return ret
+}
+
+// loadAuthorizedUserGroup loads the group in the groupID param if the caller has permission in its organization,
+// otherwise it writes the error response and returns nil.
+func loadAuthorizedUserGroup(t *dao.OrganizationUser, handler dao.DaoHandler, c *gin.Context, permission string) *dao.UserGroup {
+	groupID, err := utils.StringToInt64(c.Param("groupID"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "group invalid ID")
+		return nil
+	}
+
+	group := handler.LoadUserGroup(groupID)
+	if group == nil || !handler.DoesUserHavePermission(t.ID, group.OrganizationID, permission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+	return group
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// UserGroupApiPostHandler creates a group in an organization.
+func UserGroupApiPostHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var createRequest CreateUserGroupRequest
+	if err := c.ShouldBind(&createRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("group format: %s", err.Error()))
+		return nil
+	}
+	createRequest.DisplayName = strings.TrimSpace(createRequest.DisplayName)
+	if createRequest.DisplayName == "" {
+		c.String(http.StatusBadRequest, "group name is required")
+		return nil
+	}
+
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.DoesUserHavePermission(t.ID, organizationID, UserUpdatePermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	group := &dao.UserGroup{
+		ID:               utils.GetNextUniqueId(),
+		OrganizationID:   organizationID,
+		DisplayName:      createRequest.DisplayName,
+		CreatedTimestamp: time.Now().UTC(),
+	}
+	if err := handler.CreateUserGroup(group); errors.Is(err, dao.ErrUserGroupExists) {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
+
+	c.JSON(http.StatusCreated, newUserGroupResponse(group))
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"groupID": group.ID, "organizationID": organizationID, "name": group.DisplayName},
+		AuditHumanReadable: fmt.Sprintf("created group %d (%s) in organization %d", group.ID, group.DisplayName, organizationID),
+	}
+}
+
+// UserGroupsApiGetHandler lists the groups of an organization.
+func UserGroupsApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationID, _ := utils.StringToInt64(c.Param("organizationID"))
+	if !handler.DoesUserHavePermission(t.ID, organizationID, UserReadPermission) {
+		c.String(http.StatusUnauthorized, "not authorized")
+		return nil
+	}
+
+	response := make([]*UserGroupResponse, 0)
+	for _, group := range handler.LoadUserGroups(organizationID) {
+		response = append(response, newUserGroupResponse(group))
+	}
+	c.JSON(http.StatusOK, response)
+	return nil
+}
+
+// UserGroupApiGetHandler returns a group with its members and roles.
+func UserGroupApiGetHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	group := loadAuthorizedUserGroup(t, handler, c, UserReadPermission)
+	if group == nil {
+		return nil
+	}
+	c.JSON(http.StatusOK, newUserGroupResponse(group))
+	return nil
+}
+
+// UserGroupApiDeleteHandler deletes a group, its members lose the roles they held through it.
+func UserGroupApiDeleteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	group := loadAuthorizedUserGroup(t, handler, c, UserUpdatePermission)
+	if group == nil {
+		return nil
+	}
+
+	handler.DeleteUserGroup(group.ID)
+	c.Status(http.StatusOK)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"groupID": group.ID, "organizationID": group.OrganizationID, "name": group.DisplayName},
+		AuditHumanReadable: fmt.Sprintf("deleted group %d (%s) in organization %d", group.ID, group.DisplayName, group.OrganizationID),
+	}
+}
+
+// UserGroupMembersApiPutHandler replaces the members of a group. Users must be able to view the group's
+// organization, and nested groups must belong to it or one of its descendants.
+func UserGroupMembersApiPutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var membersRequest UserGroupMembers
+	if err := c.ShouldBind(&membersRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("members format: %s", err.Error()))
+		return nil
+	}
+
+	group := loadAuthorizedUserGroup(t, handler, c, UserUpdatePermission)
+	if group == nil {
+		return nil
+	}
+
+	userIDs := make([]int64, 0, len(membersRequest.UserIDs))
+	for _, idStr := range membersRequest.UserIDs {
+		userID, err := utils.StringToInt64(idStr)
+		if err != nil || !handler.CanUserViewOrg(userID, group.OrganizationID) {
+			c.String(http.StatusBadRequest, fmt.Sprintf("user %s can't be a member of the group", idStr))
+			return nil
+		}
+		// Joining a group is taking on its roles, so it's held to the same rule as changing your own roles.
+		if userID == t.ID && !containsID(group.MemberUserIDs, userID) && !isBootstrapEnabled(handler) {
+			c.String(http.StatusBadRequest, "not allowed to change your own group memberships")
+			return nil
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	groupIDs := make([]int64, 0, len(membersRequest.GroupIDs))
+	for _, idStr := range membersRequest.GroupIDs {
+		groupID, _ := utils.StringToInt64(idStr)
+		member := handler.LoadUserGroup(groupID)
+		if member == nil || !handler.IsOrganizationInSubtree(group.OrganizationID, member.OrganizationID) {
+			c.String(http.StatusBadRequest, fmt.Sprintf("group %s can't be nested in the group", idStr))
+			return nil
+		}
+		if !handler.DoesUserHavePermission(t.ID, member.OrganizationID, UserUpdatePermission) {
+			c.String(http.StatusUnauthorized, "not authorized")
+			return nil
+		}
+		// Nesting a group you are in, directly or not, is joining the group too.
+		if !containsID(group.MemberGroupIDs, groupID) && !isBootstrapEnabled(handler) && handler.IsUserInUserGroup(t.ID, groupID) {
+			c.String(http.StatusBadRequest, "not allowed to change your own group memberships")
+			return nil
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+
+	// New members take on the roles of the group and the groups it is nested in, which the caller must hold.
+	added := false
+	for _, id := range userIDs {
+		added = added || !containsID(group.MemberUserIDs, id)
+	}
+	for _, id := range groupIDs {
+		added = added || !containsID(group.MemberGroupIDs, id)
+	}
+	if added {
+		for organizationID, roleNames := range handler.LoadUserGroupGrantedRoles(group.ID) {
+			if exceedsPrivilegeCeiling(t, handler, c, organizationID, roleNames) {
+				return nil
+			}
+		}
+	}
+	if deferred := deferForDualControl(t, handler, c, group.OrganizationID, UserUpdatePermission); deferred != nil {
+		return deferred
+	}
+
+	if err := handler.SetUserGroupMembers(group.ID, userIDs, groupIDs); errors.Is(err, dao.ErrUserGroupCycle) || errors.Is(err, dao.ErrSeparationOfDuty) {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
+	c.Status(http.StatusOK)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"groupID": group.ID, "userIDs": userIDs, "groupIDs": groupIDs},
+		AuditHumanReadable: fmt.Sprintf("set %d users and %d groups as members of group %d", len(userIDs), len(groupIDs), group.ID),
+	}
+}
+
+// UserGroupRolesApiPutHandler replaces the roles of a group in the organizations of the request, which must be
+// the group's organization or its descendants.
+func UserGroupRolesApiPutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var rolesUpdateRequest SetRolesForUserGroupRequest
+	if err := c.ShouldBind(&rolesUpdateRequest); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("roles update format: %s", err.Error()))
+		return nil
+	}
+
+	group := loadAuthorizedUserGroup(t, handler, c, UserUpdatePermission)
+	if group == nil {
+		return nil
+	}
+	// The roles of a group you are in, directly or not, are your own roles, and unlike yours they never expire.
+	if !isBootstrapEnabled(handler) && handler.IsUserInUserGroup(t.ID, group.ID) {
+		c.String(http.StatusBadRequest, "not allowed to change the roles of your own groups")
+		return nil
+	}
+
+	for _, r := range rolesUpdateRequest.Roles {
+		if r.ValidFrom != nil || r.ValidUntil != nil {
+			c.String(http.StatusBadRequest, "roles of groups can't be time bound")
+			return nil
+		}
+		if r.Inheritance != "" {
+			c.String(http.StatusBadRequest, "roles of groups always apply to the organization and its descendants")
+			return nil
+		}
+		if r.Condition != "" {
+			c.String(http.StatusBadRequest, "roles of groups can't be conditional")
+			return nil
+		}
+		if !handler.IsOrganizationInSubtree(group.OrganizationID, r.OrganizationID) {
+			c.String(http.StatusBadRequest, fmt.Sprintf("organization %d is outside of the group's organization", r.OrganizationID))
+			return nil
+		}
+		if !handler.DoesUserHavePermission(t.ID, r.OrganizationID, UserUpdatePermission) {
+			c.String(http.StatusUnauthorized, "not authorized")
+			return nil
+		}
+		if !handler.HasValidRoles(r.RoleNames) {
+			c.String(http.StatusBadRequest, "contains at least one invalid role.")
+			return nil
+		}
+		if exceedsPrivilegeCeiling(t, handler, c, r.OrganizationID, r.RoleNames) {
+			return nil
+		}
+	}
+
+	for _, r := range rolesUpdateRequest.Roles {
+		if deferred := deferForDualControl(t, handler, c, r.OrganizationID, UserUpdatePermission); deferred != nil {
+			return deferred
+		}
+	}
+
+	assignments := make([]*dao.RoleAssignment, 0, len(rolesUpdateRequest.Roles))
+	for _, r := range rolesUpdateRequest.Roles {
+		assignments = append(assignments, &dao.RoleAssignment{OrganizationID: r.OrganizationID, RoleNames: r.RoleNames})
+	}
+	if err := handler.SetRolesToUserGroup(group.ID, assignments); errors.Is(err, dao.ErrSeparationOfDuty) {
+		c.String(http.StatusConflict, err.Error())
+		return nil
+	}
+	c.Status(http.StatusOK)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"groupID": group.ID, "roles": rolesUpdateRequest.Roles},
+		AuditHumanReadable: fmt.Sprintf("set roles of group %d", group.ID),
+	}
+}
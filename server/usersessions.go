@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/genesis32/complianceweb/auth"
+	"github.com/genesis32/complianceweb/dao"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// The cookie session and the key in it that holds the id of the server side session.
+const (
+	authSessionName    = "auth-session"
+	sessionIDValueName = "session_id"
+)
+
+// SessionRequestHeader must be sent with requests that change something and are authenticated by the session cookie.
+// Other sites can make a browser send the cookie but not a custom header, so it keeps them from making those requests.
+const SessionRequestHeader = "X-Requested-With"
+
+// isSafeMethod reports whether requests with the method only read.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// hashSessionID is what is stored for a session so a copy of the table can't be used to take sessions over.
+func hashSessionID(id string) string {
+	h := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(h[:])
+}
+
+// startUserSession creates a session for the user and stores its id in the cookie session, replacing the
+// session the browser had before.
+func startUserSession(s *Server, daoHandler dao.DaoHandler, session *sessions.Session, userID int64, providerName, idToken string) error {
+	now := time.Now().UTC()
+	if previousID, ok := session.Values[sessionIDValueName].(string); ok {
+		daoHandler.RevokeUserSession(hashSessionID(previousID), now)
+	}
+
+	id, err := auth.NewRandomToken()
+	if err != nil {
+		return err
+	}
+	daoHandler.CreateUserSession(&dao.UserSession{
+		ID:                  hashSessionID(id),
+		OrganizationUserID:  userID,
+		ProviderName:        providerName,
+		IDToken:             idToken,
+		CreatedTimestamp:    now,
+		ExpirationTimestamp: now.Add(s.Config.SessionLifetime),
+	})
+	session.Values[sessionIDValueName] = id
+	return nil
+}
+
+// loadUserSession returns the active session of the browser making the request or nil if it has none.
+func loadUserSession(s *Server, store sessions.Store, c *gin.Context) *dao.UserSession {
+	session, err := store.Get(c.Request, authSessionName)
+	if err != nil {
+		return nil
+	}
+	id, ok := session.Values[sessionIDValueName].(string)
+	if !ok || id == "" {
+		return nil
+	}
+	return s.Dao.LoadUserSession(hashSessionID(id), time.Now().UTC())
+}
+
+// LogoutHandler ends the session of the browser and then at the identity provider if it supports it.
+func LogoutHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, daoHandler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	w := c.Writer
+	r := c.Request
+
+	postLogoutRedirectURI := fmt.Sprintf("%s/webapp/", s.Config.SystemBaseUrl)
+
+	userSession := loadUserSession(s, store, c)
+	if userSession == nil {
+		http.Redirect(w, r, postLogoutRedirectURI, http.StatusSeeOther)
+		return nil
+	}
+	daoHandler.RevokeUserSession(userSession.ID, time.Now().UTC())
+
+	// The error is ignored since a session that can't be decoded is replaced by an empty one.
+	session, _ := store.Get(r, authSessionName)
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	redirectURI := postLogoutRedirectURI
+	if oidcAuthenticator, ok := s.Authenticator.(*auth.OIDCAuthenticator); ok {
+		if provider := oidcAuthenticator.ProviderByName(userSession.ProviderName); provider != nil {
+			if logoutURL := provider.LogoutURL(userSession.IDToken, postLogoutRedirectURI); logoutURL != "" {
+				redirectURI = logoutURL
+			}
+		}
+	}
+	http.Redirect(w, r, redirectURI, http.StatusSeeOther)
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": userSession.OrganizationUserID},
+		AuditHumanReadable: fmt.Sprintf("user %d logged out", userSession.OrganizationUserID),
+	}
+}
+
+// UserSessionsApiDeleteHandler revokes every session of a user.
+func UserSessionsApiDeleteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, handler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	organizationUser := loadManagedUser(t, handler, c)
+	if organizationUser == nil {
+		return nil
+	}
+
+	revoked := handler.RevokeUserSessions(organizationUser.ID, time.Now().UTC())
+	c.JSON(http.StatusOK, &RevokeUserSessionsResponse{Revoked: revoked})
+
+	return &WebAppOperationResult{
+		AuditMetadata:      WebappOperationMetadata{"userID": organizationUser.ID, "revoked": revoked},
+		AuditHumanReadable: fmt.Sprintf("revoked %d sessions of user %d", revoked, organizationUser.ID),
+	}
+}
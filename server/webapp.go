@@ -2,18 +2,17 @@ package server
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/genesis32/complianceweb/auth"
 
 	"github.com/genesis32/complianceweb/utils"
 
-	"github.com/coreos/go-oidc"
 	"github.com/genesis32/complianceweb/dao"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/sessions"
@@ -21,8 +20,13 @@ import (
 
 // IndexHandler is just a placeholder for now.
 func IndexHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, daoHandler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
+	var providers []string
+	if oidcAuthenticator, ok := s.Authenticator.(*auth.OIDCAuthenticator); ok {
+		providers = oidcAuthenticator.ProviderNames()
+	}
 	c.HTML(http.StatusOK, "index.tmpl", gin.H{
-		"title": "Welcome",
+		"title":     "Welcome",
+		"providers": providers,
 	})
 	return nil
 }
@@ -42,76 +46,110 @@ func InviteHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, dao
 	return nil
 }
 
-// LoginHandler initiate the login flow.
-func LoginHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, dao dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
-	var auth0Authenticator *auth.Auth0Authenticator
-	var ok bool
-	if auth0Authenticator, ok = s.Authenticator.(*auth.Auth0Authenticator); !ok {
-		log.Fatalf("Webforms only support Auth0Authenticator")
+// loginStateLifetime is how long a user has to complete a login at the identity provider.
+const loginStateLifetime = 10 * time.Minute
+
+// loginProvider picks the provider the user asked for, defaulting to the only one when there is a single provider.
+func loginProvider(oidcAuthenticator *auth.OIDCAuthenticator, name string) *auth.OIDCProvider {
+	if name == "" {
+		names := oidcAuthenticator.ProviderNames()
+		if len(names) != 1 {
+			return nil
+		}
+		name = names[0]
 	}
+	return oidcAuthenticator.ProviderByName(name)
+}
 
+// LoginHandler initiate the login flow.
+func LoginHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, daoHandler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
 	w := c.Writer
 	r := c.Request
-	// Generate random state
-	b := make([]byte, 32)
-	_, err := rand.Read(b)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	oidcAuthenticator, ok := s.Authenticator.(*auth.OIDCAuthenticator)
+	if !ok {
+		http.Error(w, "Webforms only support OIDCAuthenticator", http.StatusNotImplemented)
 		return nil
 	}
-	state := base64.StdEncoding.EncodeToString(b)
 
-	session, err := store.Get(r, "auth-session")
+	provider := loginProvider(oidcAuthenticator, c.Query("provider"))
+	if provider == nil {
+		http.Error(w, fmt.Sprintf("provider must be one of: %s", strings.Join(oidcAuthenticator.ProviderNames(), ", ")), http.StatusBadRequest)
+		return nil
+	}
+	loginState := &dao.LoginState{ProviderName: provider.Name, InviteCode: c.Query("inviteCode"), CreatedTimestamp: time.Now().UTC()}
+	if c.Query("link") == "true" {
+		userSession := loadUserSession(s, store, c)
+		if userSession == nil {
+			http.Error(w, "You must be logged in to link another account", http.StatusUnauthorized)
+			return nil
+		}
+		loginState.LinkUserID = userSession.OrganizationUserID
+	}
+	var err error
+	for _, v := range []*string{&loginState.State, &loginState.CodeVerifier, &loginState.Nonce} {
+		if *v, err = auth.NewRandomToken(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil
+		}
+	}
+
+	session, err := store.Get(r, authSessionName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return nil
 	}
 
-	// TODO: Hack to get an invite code into the callback
-	inviteCode := c.Query("inviteCode")
-	if inviteCode != "" {
-		state += fmt.Sprintf("|%s", inviteCode)
-	}
+	// The invite code, verifier and nonce never leave the server, the cookie ties the state to this browser.
+	daoHandler.CreateLoginState(loginState)
 
-	session.Values["state"] = state
+	session.Values["state"] = loginState.State
 	err = session.Save(r, w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return nil
 	}
 
-	http.Redirect(w, r, auth0Authenticator.Config.AuthCodeURL(state), http.StatusTemporaryRedirect)
+	http.Redirect(w, r, provider.Config.AuthCodeURL(loginState.State, auth.AuthCodeOptions(loginState.CodeVerifier, loginState.Nonce)...), http.StatusTemporaryRedirect)
 	return nil
 }
 
-// CallbackHandler handles the redirect from auth0.
-func CallbackHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, dao dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
-	var auth0Authenticator *auth.Auth0Authenticator
-	var ok bool
-	if auth0Authenticator, ok = s.Authenticator.(*auth.Auth0Authenticator); !ok {
-		log.Fatalf("Webforms only support Auth0Authenticator")
-	}
-
+// CallbackHandler handles the redirect from the identity provider.
+func CallbackHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, daoHandler dao.DaoHandler, c *gin.Context) *WebAppOperationResult {
 	w := c.Writer
 	r := c.Request
 
-	settings := dao.GetSettings(Auth0ClientIDConfigurationKey)
-	if len(settings) == 0 {
-		log.Fatal("no clientid configured")
+	oidcAuthenticator, ok := s.Authenticator.(*auth.OIDCAuthenticator)
+	if !ok {
+		http.Error(w, "Webforms only support OIDCAuthenticator", http.StatusNotImplemented)
+		return nil
 	}
 
-	session, err := store.Get(r, "auth-session")
+	session, err := store.Get(r, authSessionName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return nil
 	}
 
-	if r.URL.Query().Get("state") != session.Values["state"] {
+	if r.URL.Query().Get("state") == "" || r.URL.Query().Get("state") != session.Values["state"] {
 		http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 		return nil
 	}
+	delete(session.Values, "state")
+
+	loginState := daoHandler.ConsumeLoginState(r.URL.Query().Get("state"), time.Now().UTC().Add(-loginStateLifetime))
+	if loginState == nil {
+		http.Error(w, "Login expired", http.StatusBadRequest)
+		return nil
+	}
+
+	provider := oidcAuthenticator.ProviderByName(loginState.ProviderName)
+	if provider == nil {
+		http.Error(w, "Unknown provider", http.StatusBadRequest)
+		return nil
+	}
 
-	token, err := auth0Authenticator.Config.Exchange(context.TODO(), r.URL.Query().Get("code"))
+	token, err := provider.Config.Exchange(context.TODO(), r.URL.Query().Get("code"), auth.ExchangeOptions(loginState.CodeVerifier)...)
 	if err != nil {
 		log.Printf("no token found: %v", err)
 		w.WriteHeader(http.StatusUnauthorized)
@@ -124,34 +162,50 @@ func CallbackHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, d
 		return nil
 	}
 
-	oidcConfig := &oidc.Config{
-		ClientID: settings[Auth0ClientIDConfigurationKey].Value,
-	}
-
-	idToken, err := auth0Authenticator.Provider.Verifier(oidcConfig).Verify(context.TODO(), rawIDToken)
-
+	profile, err := provider.VerifyIDTokenNonce(context.TODO(), rawIDToken, loginState.Nonce)
 	if err != nil {
 		http.Error(w, "Failed to verify ID Token: "+err.Error(), http.StatusInternalServerError)
 		return nil
 	}
 
-	// Getting now the userInfo
-	var profile map[string]interface{}
-	if err := idToken.Claims(&profile); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return nil
+	sub := fmt.Sprintf("%v", profile["sub"])
+	if loginState.LinkUserID != 0 {
+		err := daoHandler.LinkUserIdentity(&dao.UserIdentity{
+			ID:                 utils.GetNextUniqueId(),
+			OrganizationUserID: loginState.LinkUserID,
+			IdpType:            provider.Type,
+			IdpIssuer:          provider.Issuer,
+			IdpCredentialValue: sub,
+			CreatedTimestamp:   time.Now().UTC(),
+		})
+		if errors.Is(err, dao.ErrIdentityAlreadyLinked) {
+			http.Error(w, identityAlreadyLinkedMessage(provider.Name), http.StatusConflict)
+			return nil
+		}
+		if err != nil {
+			http.Error(w, "Failed to link identity: "+err.Error(), http.StatusInternalServerError)
+			return nil
+		}
+		http.Redirect(w, r, "/webapp/", http.StatusSeeOther)
+		return &WebAppOperationResult{
+			AuditMetadata:      WebappOperationMetadata{"userID": loginState.LinkUserID, "provider": provider.Name},
+			AuditHumanReadable: fmt.Sprintf("linked %s identity to user %d", provider.Name, loginState.LinkUserID),
+		}
 	}
 
-	stateWithInvite := strings.Split(r.URL.Query().Get("state"), "|")
-	if len(stateWithInvite) > 1 {
-		initialized := dao.InitUserFromInviteCode(stateWithInvite[1], fmt.Sprintf("%v", profile["sub"]))
-		if !initialized {
-			http.Error(w, "Failed to initialize user", http.StatusOK)
+	if loginState.InviteCode != "" {
+		err := daoHandler.InitUserFromInviteCode(loginState.InviteCode, provider.Type, provider.Issuer, sub)
+		if errors.Is(err, dao.ErrIdentityAlreadyLinked) {
+			http.Error(w, identityAlreadyLinkedMessage(provider.Name), http.StatusConflict)
+			return nil
+		}
+		if err != nil {
+			http.Error(w, "Failed to initialize user: "+err.Error(), http.StatusBadRequest)
 			return nil
 		}
 	}
 
-	organizationUser, err := dao.LogUserIn(profile["sub"].(string))
+	organizationUser, err := daoHandler.LogUserIn(provider.Issuer, sub)
 	if organizationUser == nil && err == nil {
 		http.Redirect(w, r, "/webapp", http.StatusSeeOther)
 		return nil
@@ -162,10 +216,10 @@ func CallbackHandler(t *dao.OrganizationUser, s *Server, store sessions.Store, d
 		return nil
 	}
 
-	session.Values["id_token"] = rawIDToken
-	session.Values["access_token"] = token.AccessToken
-	session.Values["profile"] = profile
-	session.Values["organization_user"] = organizationUser
+	if err := startUserSession(s, daoHandler, session, organizationUser.ID, provider.Name, rawIDToken); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
 	err = session.Save(r, w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -26,19 +26,52 @@ organization_user
 (
   id BIGINT PRIMARY KEY,
   display_name TEXT,
+  user_type INT DEFAULT 0,
+  user_name TEXT,
+  external_id TEXT,
+  -- Deprecated: identities are in organization_user_identity, these are only read to migrate them.
   idp_type TEXT,
-  idp_credential_value TEXT UNIQUE,
+  idp_issuer TEXT,
+  idp_credential_value TEXT,
   invite_code TEXT,
   current_state INT,
   last_login_timestamp TIMESTAMP,
-  created_timestamp TIMESTAMP
+  created_timestamp TIMESTAMP,
+  UNIQUE (idp_issuer, idp_credential_value)
 );
 
+CREATE TABLE IF NOT EXISTS
+organization_user_identity
+(
+  id BIGINT PRIMARY KEY,
+  organization_user_id BIGINT,
+  idp_type TEXT,
+  idp_issuer TEXT,
+  idp_credential_value TEXT,
+  created_timestamp TIMESTAMP,
+  UNIQUE (idp_issuer, idp_credential_value)
+);
+
+CREATE INDEX IF NOT EXISTS organization_user_identity_organization_user_id_idx ON organization_user_identity (organization_user_id);
+
+INSERT INTO organization_user_identity (id, organization_user_id, idp_type, idp_issuer, idp_credential_value, created_timestamp)
+  SELECT id, id, idp_type, idp_issuer, idp_credential_value, created_timestamp FROM organization_user WHERE idp_credential_value IS NOT NULL
+  ON CONFLICT DO NOTHING;
+
 CREATE TABLE IF NOT EXISTS
 organization_organization_user_role_xref (
     organization_id BIGINT,
     organization_user_id BIGINT,
-    role_id BIGINT
+    role_id BIGINT,
+    -- A NULL bound leaves the assignment open on that side.
+    valid_from TIMESTAMP,
+    valid_until TIMESTAMP,
+    -- 0: the organization and its descendants, 1: the organization only, 2: its descendants only.
+    inheritance INT DEFAULT 0,
+    -- An expression over the request's attributes that must hold for the assignment to apply, see package condition.
+    condition TEXT,
+    -- Set for roles an approved elevation request granted, replacing a user's roles leaves them alone.
+    elevation_request_id BIGINT
 );
 
 CREATE TABLE IF NOT EXISTS
@@ -66,7 +99,16 @@ CREATE TABLE IF NOT EXISTS
 organization_organization_user_role_xref (
     organization_id BIGINT,
     organization_user_id BIGINT,
-    role_id BIGINT
+    role_id BIGINT,
+    -- A NULL bound leaves the assignment open on that side.
+    valid_from TIMESTAMP,
+    valid_until TIMESTAMP,
+    -- 0: the organization and its descendants, 1: the organization only, 2: its descendants only.
+    inheritance INT DEFAULT 0,
+    -- An expression over the request's attributes that must hold for the assignment to apply, see package condition.
+    condition TEXT,
+    -- Set for roles an approved elevation request granted, replacing a user's roles leaves them alone.
+    elevation_request_id BIGINT
 );
 
 CREATE TABLE IF NOT EXISTS
@@ -94,3 +136,260 @@ CREATE TABLE resource_audit_log (
     metadata jsonb,
     human_readable TEXT
 );
+
+CREATE INDEX IF NOT EXISTS resource_audit_log_internal_key_created_idx ON resource_audit_log (internal_key, created);
+
+-- Attached to tables restored from an audit archive so they can only be queried.
+CREATE OR REPLACE FUNCTION resource_audit_log_read_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'restored audit archive % is read only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS
+login_state (
+    state TEXT PRIMARY KEY,
+    provider_name TEXT,
+    code_verifier TEXT,
+    nonce TEXT,
+    invite_code TEXT,
+    link_user_id BIGINT,
+    created_timestamp TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS
+api_key (
+    id BIGINT PRIMARY KEY,
+    organization_user_id BIGINT,
+    key_hash TEXT,
+    scopes TEXT[],
+    current_state INT,
+    created_timestamp TIMESTAMP,
+    expiration_timestamp TIMESTAMP,
+    last_used_timestamp TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS api_key_organization_user_id_idx ON api_key (organization_user_id);
+
+CREATE TABLE IF NOT EXISTS
+signing_key (
+    id TEXT PRIMARY KEY,
+    private_key TEXT,
+    current_state INT,
+    created_timestamp TIMESTAMP,
+    retired_timestamp TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS
+user_session (
+    id TEXT PRIMARY KEY,
+    organization_user_id BIGINT,
+    provider_name TEXT,
+    id_token TEXT,
+    created_timestamp TIMESTAMP,
+    expiration_timestamp TIMESTAMP,
+    revoked_timestamp TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_session_organization_user_id_idx ON user_session (organization_user_id);
+
+CREATE TABLE IF NOT EXISTS
+user_group (
+    id BIGINT PRIMARY KEY,
+    organization_id BIGINT,
+    display_name TEXT,
+    created_timestamp TIMESTAMP,
+    UNIQUE (organization_id, display_name)
+);
+
+-- A member is either a user or a group nested in the group, exactly one of the member columns is set.
+CREATE TABLE IF NOT EXISTS
+user_group_member_xref (
+    user_group_id BIGINT,
+    organization_user_id BIGINT,
+    member_group_id BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS user_group_member_xref_organization_user_id_idx ON user_group_member_xref (organization_user_id);
+CREATE INDEX IF NOT EXISTS user_group_member_xref_member_group_id_idx ON user_group_member_xref (member_group_id);
+
+CREATE TABLE IF NOT EXISTS
+organization_user_group_role_xref (
+    organization_id BIGINT,
+    user_group_id BIGINT,
+    role_id BIGINT
+);
+
+-- Roles that must not be held by the same user in the organization and its descendants. A static constraint
+-- rejects assignments that would violate it, a dynamic one allows them but neither role counts while both are held.
+CREATE TABLE IF NOT EXISTS
+separation_of_duty (
+    id BIGINT PRIMARY KEY,
+    organization_id BIGINT,
+    role_id BIGINT,
+    conflicting_role_id BIGINT,
+    dynamic BOOLEAN,
+    created_timestamp TIMESTAMP
+);
+
+-- The groups a user is a member of. A member of a nested group is also a member of every group it is nested in.
+CREATE OR REPLACE FUNCTION organization_user_groups(uid BIGINT) RETURNS TABLE (user_group_id BIGINT) AS $$
+    WITH RECURSIVE membership (user_group_id) AS (
+        SELECT x.user_group_id FROM user_group_member_xref x WHERE x.organization_user_id = uid
+      UNION
+        SELECT x.user_group_id FROM membership m, user_group_member_xref x WHERE x.member_group_id = m.user_group_id
+    )
+    SELECT user_group_id FROM membership;
+$$ LANGUAGE sql STABLE;
+
+-- Whether a role assigned in the organization at path assigned with the given inheritance applies in the
+-- organization at path target.
+CREATE OR REPLACE FUNCTION role_assignment_reaches(inheritance INT, assigned LTREE, target LTREE) RETURNS BOOLEAN AS $$
+    SELECT CASE inheritance
+        WHEN 1 THEN assigned = target
+        WHEN 2 THEN assigned @> target AND assigned <> target
+        ELSE assigned @> target
+    END;
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Whether a permission of a role grants permission. A * in the role's permission matches one or more segments, e.g.
+-- gcp.serviceaccount.* matches gcp.serviceaccount.read.execute and user.*.execute matches user.read.execute.
+CREATE OR REPLACE FUNCTION permission_matches(pattern TEXT, permission TEXT) RETURNS BOOLEAN AS $$
+    SELECT pattern = permission OR (strpos(pattern, '*') > 0 AND permission ~
+        ('^' || replace(regexp_replace(pattern, '([^a-zA-Z0-9_*])', '\\\1', 'g'), '*', '[^.]+(\.[^.]+)*') || '$'));
+$$ LANGUAGE sql IMMUTABLE;
+
+DROP FUNCTION IF EXISTS organization_user_roles(BIGINT);
+DROP FUNCTION IF EXISTS assigned_organization_user_roles(BIGINT, BOOLEAN);
+
+-- The roles assigned to a user directly or to a group it is a member of. With in_window_only direct assignments
+-- only count within their validity window. Roles of groups always apply to the organization and its descendants and
+-- carry no condition.
+CREATE OR REPLACE FUNCTION assigned_organization_user_roles(uid BIGINT, in_window_only BOOLEAN) RETURNS TABLE (organization_id BIGINT, role_id BIGINT, inheritance INT, condition TEXT) AS $$
+    SELECT x.organization_id, x.role_id, COALESCE(x.inheritance, 0), x.condition FROM organization_organization_user_role_xref x WHERE x.organization_user_id = uid
+        AND (NOT in_window_only OR (
+            (x.valid_from IS NULL OR x.valid_from <= now() AT TIME ZONE 'UTC') AND
+            (x.valid_until IS NULL OR x.valid_until > now() AT TIME ZONE 'UTC')))
+    UNION ALL
+    SELECT g.organization_id, g.role_id, 0, NULL FROM organization_user_groups(uid) m, organization_user_group_role_xref g WHERE g.user_group_id = m.user_group_id;
+$$ LANGUAGE sql STABLE;
+
+-- Pairs of assigned roles that violate a separation of duty constraint. Two assignments conflict when some
+-- organization in the constraint's subtree gets both of them, i.e. their organizations and the constraint's are all
+-- on one path.
+CREATE OR REPLACE FUNCTION separation_of_duty_conflicts(uid BIGINT, in_window_only BOOLEAN)
+RETURNS TABLE (separation_of_duty_id BIGINT, dynamic BOOLEAN, organization_id BIGINT, role_id BIGINT, conflicting_organization_id BIGINT, conflicting_role_id BIGINT) AS $$
+    WITH assigned AS (SELECT DISTINCT * FROM assigned_organization_user_roles(uid, in_window_only))
+    SELECT s.id, s.dynamic, a.organization_id, a.role_id, b.organization_id, b.role_id
+    FROM
+        separation_of_duty s, organization so, assigned a, organization ao, assigned b, organization bo
+    WHERE
+        so.id = s.organization_id AND ao.id = a.organization_id AND bo.id = b.organization_id AND
+        a.role_id = s.role_id AND b.role_id = s.conflicting_role_id AND
+        (ao.path <@ so.path OR ao.path @> so.path) AND
+        (bo.path <@ so.path OR bo.path @> so.path) AND
+        (ao.path <@ bo.path OR ao.path @> bo.path);
+$$ LANGUAGE sql STABLE;
+
+-- The roles a user holds. Assigned roles that conflict under a dynamic separation of duty constraint are left out.
+CREATE OR REPLACE FUNCTION organization_user_roles(uid BIGINT) RETURNS TABLE (organization_id BIGINT, role_id BIGINT, inheritance INT, condition TEXT) AS $$
+    SELECT r.organization_id, r.role_id, r.inheritance, r.condition FROM assigned_organization_user_roles(uid, true) r WHERE NOT EXISTS (
+        SELECT 1 FROM separation_of_duty_conflicts(uid, true) c WHERE c.dynamic AND (
+            (c.organization_id = r.organization_id AND c.role_id = r.role_id) OR
+            (c.conflicting_organization_id = r.organization_id AND c.conflicting_role_id = r.role_id)));
+$$ LANGUAGE sql STABLE;
+
+CREATE TABLE IF NOT EXISTS
+elevation_request (
+    id BIGINT PRIMARY KEY,
+    organization_user_id BIGINT,
+    organization_id BIGINT,
+    role_id BIGINT,
+    duration_seconds BIGINT,
+    justification TEXT,
+    current_state INT,
+    approver_id BIGINT,
+    decision_reason TEXT,
+    requested_timestamp TIMESTAMP,
+    decided_timestamp TIMESTAMP,
+    expiration_timestamp TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS elevation_request_organization_user_id_idx ON elevation_request (organization_user_id);
+
+-- Operations needing a permission listed here in the organization or any of its descendants wait for a second user
+-- to approve them.
+CREATE TABLE IF NOT EXISTS
+dual_control_policy (
+    organization_id BIGINT,
+    permission TEXT,
+    created_timestamp TIMESTAMP,
+    UNIQUE (organization_id, permission)
+);
+
+CREATE TABLE IF NOT EXISTS
+pending_operation (
+    id BIGINT PRIMARY KEY,
+    organization_user_id BIGINT,
+    organization_id BIGINT,
+    permission TEXT,
+    method TEXT,
+    request_uri TEXT,
+    content_type TEXT,
+    body BYTEA,
+    scope TEXT,
+    current_state INT,
+    approver_id BIGINT,
+    decision_reason TEXT,
+    requested_timestamp TIMESTAMP,
+    decided_timestamp TIMESTAMP,
+    result_status INT,
+    result_body TEXT
+);
+
+-- Emergency grants of System Admin, each must be reviewed before the user can break the glass again.
+CREATE TABLE IF NOT EXISTS
+break_glass_access (
+    id BIGINT PRIMARY KEY,
+    organization_user_id BIGINT,
+    reason TEXT,
+    activated_timestamp TIMESTAMP,
+    expiration_timestamp TIMESTAMP,
+    reviewer_id BIGINT,
+    review_notes TEXT,
+    reviewed_timestamp TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS break_glass_access_organization_user_id_idx ON break_glass_access (organization_user_id);
+
+-- A permission denied to a user, or the members of a group, in an organization and its descendants. Denies
+-- override any role granting the permission there, including roles in ancestors.
+CREATE TABLE IF NOT EXISTS
+permission_deny (
+    id BIGINT PRIMARY KEY,
+    organization_id BIGINT,
+    organization_user_id BIGINT,
+    user_group_id BIGINT,
+    permission TEXT,
+    created_timestamp TIMESTAMP
+);
+
+-- The denies that apply to a user directly or through a group it is a member of.
+CREATE OR REPLACE FUNCTION organization_user_denies(uid BIGINT) RETURNS SETOF permission_deny AS $$
+    SELECT d.* FROM permission_deny d WHERE d.organization_user_id = uid
+    UNION ALL
+    SELECT d.* FROM organization_user_groups(uid) m, permission_deny d WHERE d.user_group_id = m.user_group_id;
+$$ LANGUAGE sql STABLE;
+
+-- The organizations a user can view: every organization below one it is a member of, and those its unconditional
+-- roles reach, given the assignment's inheritance, when a permission of the role isn't denied there.
+CREATE OR REPLACE FUNCTION organization_user_visible_organizations(uid BIGINT) RETURNS TABLE (organization_id BIGINT) AS $$
+    SELECT o.id FROM organization_organization_user_xref m, organization mo, organization o
+        WHERE m.organization_user_id = uid AND mo.id = m.organization_id AND o.path <@ mo.path
+    UNION
+    SELECT o.id FROM organization_user_roles(uid) x, organization a, organization o
+        WHERE a.id = x.organization_id AND x.condition IS NULL AND role_assignment_reaches(x.inheritance, a.path, o.path) AND EXISTS (
+            SELECT 1 FROM role_permission_xref rpx, permission p WHERE rpx.role_id = x.role_id AND p.id = rpx.permission_id AND NOT EXISTS (
+                SELECT 1 FROM organization_user_denies(uid) d, organization dorg
+                WHERE d.permission = p.value AND dorg.id = d.organization_id AND dorg.path @> o.path));
+$$ LANGUAGE sql STABLE;
@@ -9,6 +9,9 @@ INSERT INTO permission VALUES (8, 'user update', 'user.read.execute');
 INSERT INTO permission VALUES (9, 'system user create', 'system.user.create.execute');
 INSERT INTO permission VALUES (10, 'gcp service account read', 'gcp.serviceaccount.read.execute');
 INSERT INTO permission VALUES (11, 'aws create iam user', 'aws.iam.user.create.execute');
+INSERT INTO permission VALUES (12, 'system break glass', 'system.breakglass.execute');
+-- Patterns are only held by roles, they grant every permission they match.
+INSERT INTO permission VALUES (13, 'all gcp service account permissions', 'gcp.serviceaccount.*');
 
 INSERT INTO role VALUES (2, 'Organization Admin');
 INSERT INTO role_permission_xref VALUES (2,(SELECT id FROM permission WHERE value = 'serviceaccount.create.execute'));
@@ -23,21 +26,25 @@ INSERT INTO role_permission_xref VALUES (3,(SELECT id FROM permission WHERE valu
 INSERT INTO role_permission_xref VALUES (3,(SELECT id FROM permission WHERE value = 'system.user.create.execute'));
 
 INSERT INTO role VALUES (4, 'GCP Administrator');
-INSERT INTO role_permission_xref VALUES (4,(SELECT id FROM permission WHERE value = 'gcp.serviceaccount.write.execute'));
-INSERT INTO role_permission_xref VALUES (4,(SELECT id FROM permission WHERE value = 'gcp.serviceaccount.read.execute'));
+INSERT INTO role_permission_xref VALUES (4,(SELECT id FROM permission WHERE value = 'gcp.serviceaccount.*'));
 
 INSERT INTO role VALUES (5, 'AWS Administrator');
 INSERT INTO role_permission_xref VALUES (5,(SELECT id FROM permission WHERE value = 'aws.iam.user.create.execute'));
 
+INSERT INTO role VALUES (6, 'Break Glass');
+INSERT INTO role_permission_xref VALUES (6,(SELECT id FROM permission WHERE value = 'system.breakglass.execute'));
+
 
 INSERT INTO registered_resources VALUES (1, 'GCP Service Accounts', 'gcp.serviceaccount', true);
 INSERT INTO registered_resources VALUES (2, 'GCP Service Account Keys', 'gcp.serviceaccount.keys', true);
 INSERT INTO registered_resources VALUES (3, 'AWS IAM User', 'aws.iam.user', true);
 
 INSERT INTO settings (key, value) VALUES ('bootstrap.enabled', 'true');
-INSERT INTO settings (key, value) VALUES ('oidc.issuer.baseurl', 'https://[removed].auth0.com/');
-INSERT INTO settings (key, value) VALUES ('oidc.auth0.clientid', '[REMOVED]');
-INSERT INTO settings (key, value) VALUES ('oidc.auth0.clientsecret', '[REMOVED]');
+INSERT INTO settings (key, value) VALUES ('oidc.provider.auth0.type', 'AUTH0');
+INSERT INTO settings (key, value) VALUES ('oidc.provider.auth0.issuer', 'https://[removed].auth0.com/');
+INSERT INTO settings (key, value) VALUES ('oidc.provider.auth0.clientid', '[REMOVED]');
+INSERT INTO settings (key, value) VALUES ('oidc.provider.auth0.clientsecret', '[REMOVED]');
 INSERT INTO settings (key, value) VALUES ('system.baseurl', 'http://localhost:3000');
+INSERT INTO settings (key, value) VALUES ('audit.retention.webapp', '365');
 
 
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -41,9 +42,13 @@ func GenerateTestJwt(sub string) string {
 	return ret
 }
 
-func ParseTestJwt(jwtBase64 string, key []byte) OpenIDClaims {
-	var err error
-	token, err := jwt.Parse(jwtBase64, func(token *jwt.Token) (interface{}, error) {
+// TestJwtSigningAlgorithms are the only algorithms ParseTestJwt accepts.
+var TestJwtSigningAlgorithms = []string{jwt.SigningMethodHS256.Alg()}
+
+// ParseTestJwt validates a jwt generated by GenerateTestJwt and returns its claims.
+func ParseTestJwt(jwtBase64 string, key []byte) (OpenIDClaims, error) {
+	parser := &jwt.Parser{ValidMethods: TestJwtSigningAlgorithms}
+	token, err := parser.Parse(jwtBase64, func(token *jwt.Token) (interface{}, error) {
 		// Don't forget to validate the alg is what you expect:
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -53,7 +58,7 @@ func ParseTestJwt(jwtBase64 string, key []byte) OpenIDClaims {
 	})
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
@@ -61,9 +66,9 @@ func ParseTestJwt(jwtBase64 string, key []byte) OpenIDClaims {
 		for k, v := range claims {
 			openIDClaims[k] = v
 		}
-		return openIDClaims
+		return openIDClaims, nil
 	}
-	return nil
+	return nil, errors.New("invalid token")
 }
 
 func StringToInt64(v string) (int64, error) {